@@ -0,0 +1,117 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// activeConns 记录当前所有活跃的 TCP 连接，供 -admin 页面展示
+var activeConns = struct {
+	mu    sync.Mutex
+	conns map[uint64]*connState
+}{conns: map[uint64]*connState{}}
+
+func registerConn(s *connState) {
+	activeConns.mu.Lock()
+	defer activeConns.mu.Unlock()
+	activeConns.conns[s.id] = s
+}
+
+func unregisterConn(s *connState) {
+	activeConns.mu.Lock()
+	defer activeConns.mu.Unlock()
+	delete(activeConns.conns, s.id)
+}
+
+// connSnapshot 是某一时刻某条连接的状态，用于渲染页面
+type connSnapshot struct {
+	ID              uint64
+	Client          string
+	Backend         string
+	SNI             string
+	Version         string
+	CipherSuite     string
+	BytesFromClient int64
+	BytesFromServer int64
+	Age             time.Duration
+}
+
+func (s *connState) snapshot(now time.Time) connSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := connSnapshot{
+		ID:              s.id,
+		Client:          s.clientConn.RemoteAddr().String(),
+		Backend:         s.serverConn.RemoteAddr().String(),
+		SNI:             s.sni,
+		Version:         "-",
+		CipherSuite:     "-",
+		BytesFromClient: s.bytesFromClient.Load(),
+		BytesFromServer: s.bytesFromServer.Load(),
+		Age:             now.Sub(s.startTime).Truncate(time.Second),
+	}
+	if s.version != 0 {
+		snap.Version = formatVersion(s.version)
+		snap.CipherSuite = formatCipherSuite(s.cipherSuite)
+	}
+	return snap
+}
+
+// snapshotActiveConns 返回所有活跃连接的状态，按连接编号排序
+func snapshotActiveConns() []connSnapshot {
+	activeConns.mu.Lock()
+	conns := make([]*connState, 0, len(activeConns.conns))
+	for _, s := range activeConns.conns {
+		conns = append(conns, s)
+	}
+	activeConns.mu.Unlock()
+
+	now := time.Now()
+	snaps := make([]connSnapshot, 0, len(conns))
+	for _, s := range conns {
+		snaps = append(snaps, s.snapshot(now))
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].ID < snaps[j].ID })
+	return snaps
+}
+
+var adminPage = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="2">
+<title>record-layer-proxy：活跃连接</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.num { text-align: right; }
+</style>
+</head>
+<body>
+<h1>活跃连接（{{len .}}）</h1>
+<table>
+<tr><th>编号</th><th>客户端</th><th>后端</th><th>SNI</th><th>版本</th><th>密码套件</th><th>客户端 → 服务器</th><th>服务器 → 客户端</th><th>持续时间</th></tr>
+{{range .}}<tr><td class="num">{{.ID}}</td><td>{{.Client}}</td><td>{{.Backend}}</td><td>{{.SNI}}</td><td>{{.Version}}</td><td>{{.CipherSuite}}</td><td class="num">{{.BytesFromClient}}</td><td class="num">{{.BytesFromServer}}</td><td>{{.Age}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// runAdminServer 在 addr 上提供一个 HTTP 页面，列出当前活跃的连接，页面每 2 秒自动刷新
+func runAdminServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := adminPage.Execute(w, snapshotActiveConns()); err != nil {
+			logPrintf("[admin] 渲染页面失败：%v\n", err)
+		}
+	})
+
+	logPrintf("管理页面：http://%s/\n", addr)
+	panicIfErr(http.ListenAndServe(addr, mux), "runAdminServer")
+}
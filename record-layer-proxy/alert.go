@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	alertLevelWarning byte = 1
+	alertLevelFatal   byte = 2
+)
+
+// 警报描述
+const (
+	alertCloseNotify       byte = 0
+	alertUnexpectedMessage byte = 10
+	alertRecordOverflow    byte = 22
+	alertIllegalParameter  byte = 47
+	alertDecodeError       byte = 50
+	alertProtocolVersion   byte = 70
+	alertUnrecognizedName  byte = 112
+)
+
+type alertSpec struct {
+	level       byte
+	description byte
+}
+
+func (a alertSpec) String() string {
+	return fmt.Sprintf(
+		"%s (%d) / %s (%d)",
+		lookupName(ALERT_LEVEL_TABLE, a.level),
+		a.level,
+		lookupName(ALERT_DESCRIPTION_TABLE, a.description),
+		a.description,
+	)
+}
+
+// record 返回承载该警报的完整记录，包括 5 字节的记录层头部
+func (a alertSpec) record() []byte {
+	return []byte{21, 0x03, 0x03, 0x00, 0x02, a.level, a.description}
+}
+
+// parseAlertSpec 解析形如 "fatal:handshake_failure" 或 "2:40" 的警报描述
+func parseAlertSpec(s string) (*alertSpec, error) {
+	levelStr, descStr, found := strings.Cut(s, ":")
+	if !found {
+		return nil, fmt.Errorf("警报格式应为 <级别>:<描述>，实际为 %q", s)
+	}
+
+	level, ok := lookupCode(ALERT_LEVEL_TABLE, levelStr)
+	if !ok {
+		return nil, fmt.Errorf("未知的警报级别 %q", levelStr)
+	}
+	description, ok := lookupCode(ALERT_DESCRIPTION_TABLE, descStr)
+	if !ok {
+		return nil, fmt.Errorf("未知的警报描述 %q", descStr)
+	}
+
+	return &alertSpec{level: level, description: description}, nil
+}
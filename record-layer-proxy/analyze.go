@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/ipid/learn-tls/record-layer-proxy/recordproxy"
+)
+
+// runAnalyze 离线解析文件中连续的 TLS 记录，按 -tree 的格式逐条输出解析树后退出。
+// 文件可以是原始的二进制数据，也可以是十六进制文本（如 Wireshark 的“复制为 Hex 流”、网页工具或问题报告中粘贴的字节），
+// 十六进制文本中的空白会被忽略。文件中的记录视为同一个方向发出的，ChangeCipherSpec 之后的握手和警报记录按加密处理
+func runAnalyze(path string) {
+	data, err := os.ReadFile(path)
+	panicIfErr(err, "runAnalyze")
+
+	format := "二进制"
+	if decoded, ok := decodeHexInput(data); ok {
+		data, format = decoded, "十六进制文本"
+	}
+	logPrintf("[analyze] %s 按%s解析，共 %d 字节\n", path, format, len(data))
+
+	encrypted := false
+	records, offset := 0, 0
+	for len(data) > 0 {
+		header, ok := recordproxy.ParseHeader(data)
+		if !ok {
+			logPrintf("[analyze] 末尾剩余 %d 字节，不足一个记录层头部：%s\n", len(data), hex.EncodeToString(data))
+			break
+		}
+		contentType, version, length := header.ContentType, header.Version, header.Length
+		if _, known := CONTENT_TYPE_TABLE[contentType]; !known {
+			logPrintf("[analyze] 第 %d 字节处的内容类型 %d 不是已知的 TLS 记录类型，可能不是 TLS 记录，或者数据没有从记录层头部开始，停止解析\n",
+				offset, contentType)
+			break
+		}
+		if len(data) < 5+length {
+			logPrintf("[analyze] 第 %d 条记录声明长度 %d，但只剩 %d 字节，数据可能被截断，按现有数据解析\n", records+1, length, len(data)-5)
+			length = len(data) - 5
+		}
+		payload := data[5 : 5+length]
+		records++
+		logPrintf("[analyze] 第 %d 条记录\n%s", records, recordTree(contentType, version, payload, encrypted))
+		if contentType == contentTypeChangeCipherSpec {
+			encrypted = true
+		}
+		data = data[5+length:]
+		offset += 5 + length
+	}
+	logPrintf("[analyze] 共解析 %d 条记录\n", records)
+}
+
+// decodeHexInput 判断 data 是否是十六进制文本，是则忽略其中的空白解码，否则 ok 为 false。
+// 二进制的 TLS 记录以 0x14～0x18 开头，不可能被误判为十六进制文本
+func decodeHexInput(data []byte) (decoded []byte, ok bool) {
+	digits := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, string(data))
+	if digits == "" || len(digits)%2 != 0 {
+		return nil, false
+	}
+	decoded, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
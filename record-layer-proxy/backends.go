@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// backendPool 是 -backends-from-file 指定的一组后端地址，每条新连接从中选出一个。
+// 连接某个后端失败后，在 failTimeout 内选择后端时跳过它；收到 SIGHUP 时重新读取文件
+type backendPool struct {
+	path        string
+	random      bool
+	failTimeout time.Duration
+
+	mu    sync.Mutex
+	addrs []string
+	// 轮询时下一次从 addrs 的哪个位置开始
+	next int
+	// 连接失败的后端，以及在此之前不再选择它的时间
+	downUntil map[string]time.Time
+}
+
+// newBackendPool 读取 path 中的后端列表。selection 为 round-robin 或 random
+func newBackendPool(path, selection string, failTimeout time.Duration) (*backendPool, error) {
+	p := &backendPool{path: path, failTimeout: failTimeout, downUntil: map[string]time.Time{}}
+	switch selection {
+	case "round-robin":
+	case "random":
+		p.random = true
+	default:
+		return nil, fmt.Errorf("-backend-select：应为 round-robin 或 random，而不是 %q", selection)
+	}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// loadBackendsFile 读取后端列表文件：每行一个 host:port，忽略空行和以 # 开头的注释
+func loadBackendsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var addrs []string
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(line); err != nil {
+			return nil, fmt.Errorf("%s 第 %d 行：%w", path, lineNum, err)
+		}
+		addrs = append(addrs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s 中没有任何后端地址", path)
+	}
+	return addrs, nil
+}
+
+// reload 重新读取后端列表文件。读取失败时保留原来的列表
+func (p *backendPool) reload() error {
+	addrs, err := loadBackendsFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addrs = addrs
+	p.next = 0
+	// 已经不在列表中的后端无需再记录
+	for addr := range p.downUntil {
+		if !containsString(addrs, addr) {
+			delete(p.downUntil, addr)
+		}
+	}
+	return nil
+}
+
+// pick 选出下一条连接使用的后端，跳过最近连接失败的后端。
+// 所有后端都连接失败过时，选择最早结束等待的那个，而不是拒绝连接
+func (p *backendPool) pick() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	start := p.next
+	if p.random {
+		start = rand.Intn(len(p.addrs))
+	}
+
+	fallback := ""
+	for i := range p.addrs {
+		addr := p.addrs[(start+i)%len(p.addrs)]
+		until, down := p.downUntil[addr]
+		if !down || now.After(until) {
+			p.next = (start + i + 1) % len(p.addrs)
+			return addr
+		}
+		if fallback == "" || until.Before(p.downUntil[fallback]) {
+			fallback = addr
+		}
+	}
+	return fallback
+}
+
+// reportDial 记录一次连接后端的结果：失败时在 failTimeout 内跳过该后端，成功时立即恢复
+func (p *backendPool) reportDial(addr string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		delete(p.downUntil, addr)
+		return
+	}
+	if _, down := p.downUntil[addr]; !down {
+		logPrintf("[backends] 无法连接后端 %s，%s 内不再选择它\n", addr, p.failTimeout)
+	}
+	p.downUntil[addr] = time.Now().Add(p.failTimeout)
+}
+
+// reloadOnSIGHUP 每次收到 SIGHUP 时重新读取后端列表文件
+func (p *backendPool) reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := p.reload(); err != nil {
+			logPrintf("[backends] 重新读取 %s 失败，继续使用原来的列表：%v\n", p.path, err)
+			continue
+		}
+		p.mu.Lock()
+		addrs := strings.Join(p.addrs, "、")
+		p.mu.Unlock()
+		logPrintf("[backends] 重新读取了 %s：%s\n", p.path, addrs)
+	}
+}
+
+// checkBackendsFile 检查后端列表文件能否读取，格式是否正确
+func checkBackendsFile(path string) error {
+	if path == "" {
+		return errNotSet
+	}
+	addrs, err := loadBackendsFile(path)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := checkRemoteAddr(addr); err != nil {
+			return fmt.Errorf("%s：%w", addr, err)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
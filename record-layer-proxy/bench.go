@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// runBenchmark 在本机上搭建“客户端 → 代理 → 回显服务器”的链路，分别测量直接转发、只分帧计数与逐条解析记录三种转发方式的吞吐量
+func runBenchmark(duration time.Duration, recordSize int) {
+	if recordSize <= 0 || recordSize > maxPlaintextLength {
+		panic(fmt.Sprintf("记录长度应在 1 到 %d 之间", maxPlaintextLength))
+	}
+
+	echoListener := startBenchmarkEchoServer()
+	defer echoListener.Close()
+	echoAddr := echoListener.Addr().String()
+
+	logPrintf("基准测试：每种方式运行 %s，每条记录 %d 字节\n", duration, recordSize)
+
+	// 测量期间丢弃代理的日志，测得的是分帧、解析和格式化日志的开销，而不是终端的输出速度；
+	// 全部测完、恢复原来的日志输出后再输出结果
+	oldLogger := setLogOutput(io.Discard)
+	results := []string{
+		benchmarkOnce("直接转发（-raw）", forwardRaw, echoAddr, duration, recordSize),
+		benchmarkOnce("只分帧计数（-count-only）", forwardCount, echoAddr, duration, recordSize),
+		benchmarkOnce("逐条解析（copyDataFromConnToConn）", forwardParse, echoAddr, duration, recordSize),
+	}
+	setLogger(oldLogger)
+
+	for _, result := range results {
+		logPrint(result)
+	}
+}
+
+// startBenchmarkEchoServer 在本机的随机端口上启动一个 TCP 回显服务器，关闭返回的 listener 即停止接受新连接
+func startBenchmarkEchoServer() *net.TCPListener {
+	echoListener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	panicIfErr(err, "startBenchmarkEchoServer")
+
+	go func() {
+		for {
+			conn, err := echoListener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.CloseWrite()
+			}()
+		}
+	}()
+	return echoListener
+}
+
+// benchmarkOnce 以 mode 转发记录运行一轮持续 duration 的测试，返回结果描述
+func benchmarkOnce(name string, mode forwardMode, echoAddr string, duration time.Duration, recordSize int) string {
+	start := time.Now()
+	received, err := benchmarkTransfer(mode, echoAddr, recordSize, func(int) bool { return time.Since(start) < duration })
+	elapsed := time.Since(start)
+	if err != nil {
+		return fmt.Sprintf("%s：测试失败：%v\n", name, err)
+	}
+
+	records := received / int64(5+recordSize)
+	return fmt.Sprintf(
+		"%s：%.0f 条记录/秒，%.2f MB/秒（共 %d 条记录，%d 字节，用时 %s）\n",
+		name,
+		float64(records)/elapsed.Seconds(),
+		float64(received)/elapsed.Seconds()/1e6,
+		records,
+		received,
+		elapsed.Round(time.Millisecond),
+	)
+}
+
+// benchmarkTransfer 建立一条经过代理（以 mode 转发记录）到 echoAddr 的连接，在 more 返回 true 期间不断发送长度为
+// recordSize 的记录，more 的参数是已发送的记录数。返回从回显服务器收回的字节数，
+// 返回前会等待代理的连接处理函数结束，因此连续调用时前后两轮不会同时运行
+func benchmarkTransfer(mode forwardMode, echoAddr string, recordSize int, more func(sent int) bool) (int64, error) {
+	proxyListener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return 0, err
+	}
+	defer proxyListener.Close()
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+		conn, err := proxyListener.AcceptTCP()
+		if err != nil {
+			return
+		}
+		handleIncomingConnWithMode(conn, echoAddr, mode)
+	}()
+
+	conn, err := net.DialTCP("tcp", nil, proxyListener.Addr().(*net.TCPAddr))
+	if err != nil {
+		return 0, err
+	}
+
+	record := append([]byte{contentTypeApplicationData, 0x03, 0x03, byte(recordSize >> 8), byte(recordSize)}, bytes.Repeat([]byte{0xAA}, recordSize)...)
+
+	go func() {
+		for sent := 0; more(sent); sent++ {
+			if err := writeFull(conn, record); err != nil {
+				return
+			}
+		}
+		_ = conn.CloseWrite()
+	}()
+
+	received, err := io.Copy(io.Discard, conn)
+	_ = conn.Close()
+	<-handlerDone
+	return received, err
+}
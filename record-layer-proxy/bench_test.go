@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func BenchmarkCopyDataFromConnToConn(b *testing.B) {
+	const recordSize = 1024
+
+	echoListener := startBenchmarkEchoServer()
+	defer echoListener.Close()
+	echoAddr := echoListener.Addr().String()
+
+	oldLogger := setLogOutput(io.Discard)
+	defer setLogger(oldLogger)
+
+	for _, bc := range []struct {
+		name string
+		mode forwardMode
+	}{
+		{"raw", forwardRaw},
+		{"parsed", forwardParse},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(5 + recordSize)
+			b.ResetTimer()
+			received, err := benchmarkTransfer(bc.mode, echoAddr, recordSize, func(sent int) bool { return sent < b.N })
+			if err != nil {
+				b.Fatal(err)
+			}
+			if want := int64(b.N) * (5 + recordSize); received != want {
+				b.Fatalf("收回 %d 字节，期望 %d 字节", received, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+// 以下函数用于按 TLS 的编码规则构造消息，与 byteReader 的读取方法一一对应
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint24(b []byte, v uint32) []byte {
+	return append(b, byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendLengthPrefixed 先写入 lenBytes 字节的长度占位，再调用 f 写入字段内容，最后回填实际长度
+func appendLengthPrefixed(b []byte, lenBytes int, f func([]byte) []byte) []byte {
+	start := len(b)
+	for i := 0; i < lenBytes; i++ {
+		b = append(b, 0)
+	}
+
+	b = f(b)
+
+	length := len(b) - start - lenBytes
+	for i := lenBytes - 1; i >= 0; i-- {
+		b[start+i] = byte(length)
+		length >>= 8
+	}
+	return b
+}
+
+func appendUint8LengthPrefixed(b []byte, f func([]byte) []byte) []byte {
+	return appendLengthPrefixed(b, 1, f)
+}
+
+func appendUint16LengthPrefixed(b []byte, f func([]byte) []byte) []byte {
+	return appendLengthPrefixed(b, 2, f)
+}
+
+func appendUint24LengthPrefixed(b []byte, f func([]byte) []byte) []byte {
+	return appendLengthPrefixed(b, 3, f)
+}
+
+// appendHandshakeRecords 将一条完整的握手消息切分成若干条记录，每条记录的长度不超过 maxPlaintextLength
+func appendHandshakeRecords(b []byte, recordVersion uint16, msg []byte) []byte {
+	for len(msg) > 0 {
+		n := len(msg)
+		if n > maxPlaintextLength {
+			n = maxPlaintextLength
+		}
+
+		b = append(b, 22)
+		b = appendUint16(b, recordVersion)
+		b = appendUint16(b, uint16(n))
+		b = append(b, msg[:n]...)
+		msg = msg[n:]
+	}
+	return b
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ipid/learn-tls/record-layer-proxy/recordproxy"
+)
+
+// 捕获文件的格式：文件开头是 8 字节的 captureMagic，之后每条记录占一帧：
+//
+//	方向（1 字节，0 表示客户端发出，1 表示服务器发出）
+//	相对连接建立时的时间（8 字节，纳秒，大端序）
+//	记录长度（4 字节，大端序）
+//	记录内容（含 5 字节的记录层头部）
+const captureMagic = "TLSCAP01"
+
+// recordCapture 是一条连接的捕获文件，-capture-format 决定它的格式
+type recordCapture interface {
+	// writeRecord 写入一条完整的记录（含记录层头部）
+	writeRecord(fromClient bool, record []byte) error
+	Close() error
+}
+
+// captureWriter 把一条连接上两个方向的记录连同时间写入捕获文件，两个方向的 goroutine 会并发调用
+type captureWriter struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	start time.Time
+}
+
+func newCaptureWriter(path string) (*captureWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &captureWriter{file: file, w: bufio.NewWriter(file), start: time.Now()}
+	if _, err := c.w.WriteString(captureMagic); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// newConnCapture 在 dir 下为连接 id 创建捕获文件
+func newConnCapture(dir string, id uint64) (*captureWriter, error) {
+	return newCaptureWriter(filepath.Join(dir, fmt.Sprintf("conn-%d.tlscap", id)))
+}
+
+// writeRecord 写入一帧，record 为完整的记录（含记录层头部）
+func (c *captureWriter) writeRecord(fromClient bool, record []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var frame [13]byte
+	if !fromClient {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint64(frame[1:9], uint64(time.Since(c.start)))
+	binary.BigEndian.PutUint32(frame[9:13], uint32(len(record)))
+	if _, err := c.w.Write(frame[:]); err != nil {
+		return err
+	}
+	_, err := c.w.Write(record)
+	return err
+}
+
+func (c *captureWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.w.Flush()
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// capturedRecord 是捕获文件中的一帧
+type capturedRecord struct {
+	fromClient bool
+	offset     time.Duration
+	record     []byte
+}
+
+// readCapture 读取捕获文件中的全部帧
+func readCapture(path string) ([]capturedRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != captureMagic {
+		return nil, errors.New("不是捕获文件")
+	}
+
+	var records []capturedRecord
+	for {
+		var frame [13]byte
+		if _, err := io.ReadFull(r, frame[:]); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("第 %d 帧的帧头不完整：%w", len(records)+1, err)
+		}
+
+		// 代理不转发超长的记录，也就不会捕获它们；在分配内存前检查，以免损坏的文件让程序一次分配数 GB
+		length := binary.BigEndian.Uint32(frame[9:13])
+		if length > recordproxy.HeaderLength+maxCiphertextLength {
+			return nil, fmt.Errorf("第 %d 帧的长度 %d 超过了一条记录的最大长度 %d", len(records)+1, length, recordproxy.HeaderLength+maxCiphertextLength)
+		}
+		record := make([]byte, length)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return nil, fmt.Errorf("第 %d 帧的内容不完整：%w", len(records)+1, err)
+		}
+		records = append(records, capturedRecord{
+			fromClient: frame[0] == 0,
+			offset:     time.Duration(binary.BigEndian.Uint64(frame[1:9])),
+			record:     record,
+		})
+	}
+}
+
+// describeCapturedRecord 返回形如 “Handshake (22)，长度 512” 的记录描述
+func describeCapturedRecord(record []byte) string {
+	if len(record) < 5 {
+		return fmt.Sprintf("不完整的记录（%d 字节）", len(record))
+	}
+	return fmt.Sprintf("%s (%d)，长度 %d", lookupName(CONTENT_TYPE_TABLE, record[0]), record[0], len(record)-5)
+}
+
+// runReplay 把捕获文件中客户端发出的记录按原来的时间间隔（除以 speed）重新发给 remoteAddr，
+// 并输出服务器返回的每条记录及其到达时间。注意客户端的随机数和密钥交换都是原来的，
+// 服务器每次生成的参数不同，因此服务器通常会在收到客户端的密钥交换或 Finished 后中止握手
+func runReplay(path, remoteAddr string, speed float64) {
+	if speed <= 0 {
+		panic("-replay-speed 必须大于 0")
+	}
+	records, err := readCapture(path)
+	panicIfErr(err, "runReplay")
+
+	conn, err := backendDialer.Dial("tcp", remoteAddr)
+	panicIfErr(err, "runReplay")
+	outConn := conn.(*net.TCPConn)
+	start := time.Now()
+	logPrintf("[replay] 开始重放 %s 中的 %d 条记录，速度 %gx\n", path, len(records), speed)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		headerBuf := make([]byte, recordproxy.HeaderLength)
+		for {
+			header, _, err := recordproxy.ReadHeader(outConn, headerBuf)
+			if err != nil {
+				logPrintf("[replay] 服务器方向结束：%s\n", describeConnError(err))
+				return
+			}
+			if _, err := io.CopyN(io.Discard, outConn, int64(header.Length)); err != nil {
+				logPrintf("[replay] 服务器方向结束：%s\n", describeConnError(err))
+				return
+			}
+			logPrintf("[replay] %s 收到服务器的记录：%s\n", time.Since(start).Round(time.Microsecond), lookupName(CONTENT_TYPE_TABLE, header.ContentType)+fmt.Sprintf(" (%d)，长度 %d", header.ContentType, header.Length))
+		}
+	}()
+
+	sent := 0
+	for _, r := range records {
+		if !r.fromClient {
+			continue
+		}
+		time.Sleep(time.Until(start.Add(time.Duration(float64(r.offset) / speed))))
+		if err := writeFull(outConn, r.record); err != nil {
+			logPrintf("[replay] 发送失败：%s\n", describeConnError(err))
+			break
+		}
+		sent++
+		logPrintf("[replay] %s 发送客户端的记录（原始时间 %s）：%s\n", time.Since(start).Round(time.Microsecond), r.offset.Round(time.Microsecond), describeCapturedRecord(r.record))
+	}
+	_ = outConn.CloseWrite()
+	logPrintf("[replay] 已发送 %d 条记录，等待服务器关闭连接\n", sent)
+	<-done
+	_ = outConn.Close()
+}
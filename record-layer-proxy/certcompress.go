@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// extCompressCertificate 是 compress_certificate 扩展（RFC 8879）。客户端用它列出自己能解压的算法，
+// 服务器随后可以用 CompressedCertificate 代替 Certificate，把证书链压缩后再发送
+const extCompressCertificate uint16 = 27
+
+// certCompressionAlgorithms 返回 compress_certificate 扩展中的压缩算法，未携带该扩展或格式错误时 ok 为 false
+func certCompressionAlgorithms(extensions []tlsExtension) (algorithms []uint16, ok bool) {
+	data, found := findExtension(extensions, extCompressCertificate)
+	if !found {
+		return nil, false
+	}
+	r := byteReader(data)
+	var list byteReader
+	if !r.readUint8LengthPrefixed(&list) || !r.empty() || len(list) == 0 {
+		return nil, false
+	}
+	for !list.empty() {
+		var algorithm uint16
+		if !list.readUint16(&algorithm) {
+			return nil, false
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	return algorithms, true
+}
+
+// describeCertCompressionAlgorithms 返回压缩算法列表的描述
+func describeCertCompressionAlgorithms(algorithms []uint16) string {
+	var names []string
+	for _, algorithm := range algorithms {
+		names = append(names, formatCode(CERT_COMPRESSION_ALGORITHM_TABLE, algorithm))
+	}
+	return strings.Join(names, "、")
+}
+
+// compressedCertificate 是 CompressedCertificate 消息（RFC 8879 4）
+type compressedCertificate struct {
+	algorithm uint16
+	// 解压后的 Certificate 消息体的长度，由发送方声明
+	uncompressedLength uint32
+	compressed         []byte
+}
+
+func parseCompressedCertificate(body []byte) (*compressedCertificate, error) {
+	r := byteReader(body)
+	var cc compressedCertificate
+	var compressed byteReader
+	if !r.readUint16(&cc.algorithm) || !r.readUint24(&cc.uncompressedLength) || !r.readUint24LengthPrefixed(&compressed) || !r.empty() {
+		return nil, errors.New("CompressedCertificate 消息长度不正确")
+	}
+	cc.compressed = compressed
+	return &cc, nil
+}
+
+// decompress 解压出 Certificate 消息体。标准库只有 zlib，brotli 和 zstd 无法解压
+func (cc *compressedCertificate) decompress() ([]byte, error) {
+	if cc.algorithm != 1 {
+		return nil, fmt.Errorf("不支持解压 %s", formatCode(CERT_COMPRESSION_ALGORITHM_TABLE, cc.algorithm))
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(cc.compressed))
+	if err != nil {
+		return nil, err
+	}
+	// 多读 1 字节，以便发现实际长度超过声明的长度
+	plain, err := io.ReadAll(io.LimitReader(zr, int64(cc.uncompressedLength)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) != int(cc.uncompressedLength) {
+		return nil, fmt.Errorf("解压后为 %d 字节，与声明的 %d 字节不符", len(plain), cc.uncompressedLength)
+	}
+	return plain, nil
+}
+
+// logCompressedCertificate 输出 CompressedCertificate 的压缩算法和压缩前后的长度，并检查算法是否是客户端提供过的。
+// source 不为空时表示消息是解密出来的。能解压时返回解压出的 Certificate 消息体，调用时需持有 mu
+func (s *connState) logCompressedCertificate(fromClient bool, source string, body []byte) []byte {
+	prefix := ""
+	if source != "" {
+		prefix = source + "："
+	}
+	cc, err := parseCompressedCertificate(body)
+	if err != nil {
+		s.warnf(fromClient, "%s无法解析 CompressedCertificate：%v", prefix, err)
+		return nil
+	}
+	ratio := ""
+	if cc.uncompressedLength > 0 {
+		ratio = fmt.Sprintf("，压缩到原来的 %.1f%%", float64(len(cc.compressed))*100/float64(cc.uncompressedLength))
+	}
+	s.logf(fromClient, "%sCompressedCertificate：算法 %s，压缩后 %d 字节，解压后 %d 字节%s",
+		prefix, formatCode(CERT_COMPRESSION_ALGORITHM_TABLE, cc.algorithm), len(cc.compressed), cc.uncompressedLength, ratio)
+	s.narrate(fromClient, "发送 CompressedCertificate，用 %s 压缩了证书链（%d → %d 字节）",
+		formatCode(CERT_COMPRESSION_ALGORITHM_TABLE, cc.algorithm), cc.uncompressedLength, len(cc.compressed))
+
+	// 客户端在 ClientHello 中提供算法，服务器的 CertificateRequest 中提供算法（客户端证书），这里只检查服务器一侧
+	if !fromClient {
+		offered := false
+		for _, algorithm := range s.clientCertCompression {
+			offered = offered || algorithm == cc.algorithm
+		}
+		if !offered {
+			s.protocolViolation(fromClient, alertIllegalParameter, "服务器使用了客户端没有在 compress_certificate 中提供的压缩算法 %s",
+				formatCode(CERT_COMPRESSION_ALGORITHM_TABLE, cc.algorithm))
+		}
+	}
+
+	plain, err := cc.decompress()
+	if err != nil {
+		if cc.algorithm == 1 {
+			s.warnf(fromClient, "%s无法解压 CompressedCertificate：%v", prefix, err)
+		}
+		return nil
+	}
+	return plain
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// verifyCertificateChain 解析 Certificate 消息中的证书链（第一张是叶子证书，其余是中间证书），
+// 用系统根证书验证它，返回叶子证书的信息和验证结果（每项一行）以及验证错误。
+// sni 不为空时同时检查叶子证书是否适用于该主机名
+func verifyCertificateChain(chain [][]byte, sni string) ([]string, error) {
+	if len(chain) == 0 {
+		return []string{"证书链为空"}, fmt.Errorf("证书链为空")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(chain))
+	for i, der := range chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return []string{fmt.Sprintf("无法解析第 %d 张证书：%v", i+1, err)}, err
+		}
+		certs = append(certs, cert)
+	}
+
+	leaf := certs[0]
+	var names []string
+	names = append(names, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		names = append(names, "（无）")
+	}
+	lines := []string{
+		"叶子证书主题：" + leaf.Subject.String(),
+		"叶子证书 SAN：" + strings.Join(names, "、"),
+		"叶子证书签发者：" + leaf.Issuer.String(),
+		fmt.Sprintf("叶子证书有效期：%s 至 %s", leaf.NotBefore.UTC().Format(time.RFC3339), leaf.NotAfter.UTC().Format(time.RFC3339)),
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		lines = append(lines, fmt.Sprintf("无法加载系统根证书：%v", err))
+		return lines, err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	verified, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       sni,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		lines = append(lines, "证书链验证失败："+err.Error())
+		return lines, err
+	}
+
+	var path []string
+	for _, cert := range verified[0] {
+		path = append(path, cert.Subject.CommonName)
+	}
+	lines = append(lines, "证书链验证通过："+strings.Join(path, " → "))
+	return lines, nil
+}
+
+// leafFingerprints 返回叶子证书的 SHA-256 指纹（即整张证书 DER 编码的 SHA-256，按惯例写成冒号分隔的十六进制），
+// 以及 HPKP（RFC 7469）风格的 pin-sha256：它是证书公钥（SubjectPublicKeyInfo）的 SHA-256 的 Base64，
+// 续期时若沿用同一把密钥，pin-sha256 不变而证书指纹会变
+func leafFingerprints(der []byte) []string {
+	sum := sha256.Sum256(der)
+	hexParts := make([]string, len(sum))
+	for i, b := range sum {
+		hexParts[i] = fmt.Sprintf("%02X", b)
+	}
+	lines := []string{"叶子证书 SHA-256 指纹：" + strings.Join(hexParts, ":")}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return append(lines, fmt.Sprintf("无法解析叶子证书，不能计算 pin-sha256：%v", err))
+	}
+	spkiSum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return append(lines, "叶子证书公钥 pin-sha256："+base64.StdEncoding.EncodeToString(spkiSum[:]))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// errNotSet 表示该项没有设置，-check 报告中显示为 “未设置”，不算失败
+var errNotSet = errors.New("未设置")
+
+// checkItem 是 -check 报告中的一项，err 为 nil 表示检查通过
+type checkItem struct {
+	name string
+	err  error
+}
+
+// runCheck 输出检查报告，有任何一项失败时以状态码 1 退出
+func runCheck(items []checkItem) {
+	failed := 0
+	for _, item := range items {
+		switch {
+		case item.err == nil:
+			logPrintf("✔ %s\n", item.name)
+		case errors.Is(item.err, errNotSet):
+			logPrintf("- %s：未设置\n", item.name)
+		default:
+			failed++
+			logPrintf("✘ %s：%v\n", item.name, item.err)
+		}
+	}
+
+	if failed > 0 {
+		logPrintf("检查完成，%d 项未通过\n", failed)
+		os.Exit(1)
+	}
+	logPrint("检查完成，全部通过\n")
+}
+
+// checkListenAddr 检查本地地址能否解析，并尝试监听一次以确认端口可用
+func checkListenAddr(addr string, udp bool) error {
+	if addr == "" {
+		return errors.New("必须填写 -l 或设置环境变量 PROXY_LISTEN")
+	}
+
+	if udp {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return err
+		}
+		conn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp4", addr)
+	if err != nil {
+		return err
+	}
+	listener, err := net.ListenTCP("tcp4", tcpAddr)
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// checkRemoteAddr 检查远程地址格式是否正确、主机名能否解析。不会连接远程地址
+func checkRemoteAddr(addr string) error {
+	if addr == "" {
+		return errors.New("必须填写 -r 或设置环境变量 PROXY_REMOTE")
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("%s 没有解析出任何地址", host)
+	}
+	return nil
+}
+
+// checkRemoteOrBackends 检查远程地址；使用 -backends-from-file 时不需要 -r，由后端列表一项单独检查
+func checkRemoteOrBackends(addr, backendsFile string) error {
+	if backendsFile == "" {
+		return checkRemoteAddr(addr)
+	}
+	if addr != "" {
+		return errors.New("-r 与 -backends-from-file 只能指定一个")
+	}
+	return errNotSet
+}
+
+// checkDecryptKey 检查 -decrypt-key 指定的文件能否读取，其中是否是 RSA 私钥
+func checkDecryptKey(path string) error {
+	if path == "" {
+		return errNotSet
+	}
+	_, err := loadRSAPrivateKey(path)
+	return err
+}
+
+// checkOptionalTCPAddr 检查可选的 TCP 地址能否解析
+func checkOptionalTCPAddr(addr string) error {
+	if addr == "" {
+		return errNotSet
+	}
+	_, err := net.ResolveTCPAddr("tcp", addr)
+	return err
+}
+
+// checkWritableFile 检查文件能否以追加方式打开。文件原本不存在时，检查后会把新建的空文件删掉
+func checkWritableFile(path string) error {
+	if path == "" {
+		return errNotSet
+	}
+
+	_, statErr := os.Stat(path)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+	if os.IsNotExist(statErr) {
+		_ = os.Remove(path)
+	}
+	return nil
+}
+
+// checkWritableDir 检查目录是否存在且可以在其中创建文件
+func checkWritableDir(dir string) error {
+	if dir == "" {
+		return errNotSet
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s 不是目录", dir)
+	}
+	file, err := os.CreateTemp(dir, ".check-*")
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkCaptureFile 检查捕获文件能否完整读取
+func checkCaptureFile(path string) error {
+	if path == "" {
+		return errNotSet
+	}
+
+	records, err := readCapture(path)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return errors.New("捕获文件中没有记录")
+	}
+	return nil
+}
+
+// checkHelloLimits 检查缓存 ClientHello 的长度上限和超时时间是否为正数
+func checkHelloLimits(maxSize int, timeout time.Duration) error {
+	if maxSize <= 0 {
+		return fmt.Errorf("-max-hello-size 必须为正数，当前为 %d", maxSize)
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("-hello-timeout 必须为正数，当前为 %s", timeout)
+	}
+	return nil
+}
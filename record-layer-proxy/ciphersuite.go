@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// explainCipherSuite 把密码套件的名称拆成密钥交换、身份验证、对称加密和 MAC/PRF 四部分，
+// 例如 TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 拆成 “ECDHE / RSA / AES-256-GCM / SHA384”。
+// TLS 1.2 及更早版本的名称格式为 TLS_<密钥交换>_<身份验证>_WITH_<对称加密>_<MAC>；
+// TLS 1.3 的名称只包含对称加密和 HKDF 所用的哈希，密钥交换和签名算法由扩展单独协商
+func explainCipherSuite(suite uint16) string {
+	name, ok := CIPHER_SUITE_TABLE[suite]
+	if !ok {
+		return "未知的密码套件"
+	}
+	if strings.HasSuffix(name, "_SCSV") {
+		return "信令值（SCSV），不是真正的密码套件"
+	}
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "TLS_"), "SSL_")
+
+	left, right, hasWith := strings.Cut(name, "_WITH_")
+	if !hasWith {
+		// TLS 1.3：<对称加密>_<哈希>，RFC 9150 的 SHA256_SHA256 等只做完整性保护
+		cipher, hash := splitLast(name)
+		if cipher == hash {
+			cipher = "NULL（不加密，只做完整性保护）"
+		}
+		return fmt.Sprintf("密钥交换 由 key_share 协商 / 身份验证 由 signature_algorithms 协商 / 对称加密 %s / HKDF 哈希 %s",
+			formatCipherName(cipher), hash)
+	}
+
+	var kxTokens []string
+	export := ""
+	for _, t := range strings.Split(left, "_") {
+		if strings.HasPrefix(t, "EXPORT") {
+			export = "（出口级，已被破解）"
+			continue
+		}
+		kxTokens = append(kxTokens, t)
+	}
+	kx, auth := left, left
+	switch len(kxTokens) {
+	case 1:
+		kx, auth = kxTokens[0], kxTokens[0]
+	default:
+		kx = strings.Join(kxTokens[:len(kxTokens)-1], "_")
+		auth = kxTokens[len(kxTokens)-1]
+	}
+	if auth == "anon" {
+		auth = "无（匿名）"
+	}
+
+	cipher, mac := splitLast(right)
+	switch mac {
+	case "SHA", "SHA256", "SHA384", "MD5", "SM3", "NULL":
+	default:
+		// AES_128_CCM、AES_128_CCM_8 等名称里没有 MAC 部分：它们是 AEAD，PRF 使用 SHA-256
+		cipher, mac = right, "无（AEAD），PRF 为 SHA256"
+	}
+	if mac == "SHA" {
+		mac = "SHA1"
+	}
+	macLabel := "MAC " + mac
+	if strings.Contains(cipher, "GCM") || strings.Contains(cipher, "POLY1305") {
+		// AEAD 自带完整性保护，名称末尾的哈希只用于 PRF
+		macLabel = "PRF " + mac + "（AEAD 不需要单独的 MAC）"
+	} else if strings.HasPrefix(mac, "无") {
+		macLabel = mac
+	}
+	return fmt.Sprintf("密钥交换 %s%s / 身份验证 %s / 对称加密 %s / %s", kx, export, auth, formatCipherName(cipher), macLabel)
+}
+
+// splitLast 在最后一个下划线处把 s 分成两部分，没有下划线时两部分都是 s
+func splitLast(s string) (string, string) {
+	i := strings.LastIndex(s, "_")
+	if i < 0 {
+		return s, s
+	}
+	return s[:i], s[i+1:]
+}
+
+// formatCipherName 把 AES_256_GCM 写成 AES-256-GCM
+func formatCipherName(cipher string) string {
+	if cipher == "NULL" {
+		return "NULL（不加密）"
+	}
+	return strings.ReplaceAll(cipher, "_", "-")
+}
+
+// endOfEarlyDataRecordLength 返回 TLS 1.3 中加密的 EndOfEarlyData 记录在不加填充时的长度：
+// 4 字节消息头、1 字节真实内容类型，再加上 AEAD 的认证标签（CCM_8 为 8 字节，其余均为 16 字节）
+func endOfEarlyDataRecordLength(suite uint16) int {
+	if strings.HasSuffix(CIPHER_SUITE_TABLE[suite], "_CCM_8_SHA256") {
+		return 4 + 1 + 8
+	}
+	return 4 + 1 + 16
+}
+
+// describeCipherPreference 根据服务器选定的套件在客户端列表中的位置，说明服务器是按客户端的偏好顺序选择，还是使用了自己的偏好顺序。
+// 只比较与协商出的版本相容的套件：TLS 1.3 只能选 TLS 1.3 的套件（0x13XX），更早的版本则不能选它们
+func describeCipherPreference(offered []uint16, chosen uint16, version uint16) string {
+	var usable []uint16
+	for _, suite := range offered {
+		if isGREASE(suite) || suite == 0x00FF || suite == 0x5600 {
+			// 跳过 GREASE 和 TLS_EMPTY_RENEGOTIATION_INFO_SCSV、TLS_FALLBACK_SCSV 这两个信令值
+			continue
+		}
+		if (suite>>8 == 0x13) == (version == versionTLS13) {
+			usable = append(usable, suite)
+		}
+	}
+
+	for i, suite := range usable {
+		if suite != chosen {
+			continue
+		}
+		if i == 0 {
+			return fmt.Sprintf("密码套件偏好：服务器选择了客户端最优先的 %s（共 %d 个可用）。服务器可能遵循客户端的偏好，也可能它自己的偏好恰好相同", formatCipherSuite(chosen), len(usable))
+		}
+		return fmt.Sprintf(
+			"密码套件偏好：服务器选择了客户端列表中第 %d 个可用的 %s，跳过了客户端更偏好的 %d 个套件（首选 %s）。"+
+				"服务器要么使用了自己的偏好顺序，要么不支持前面这些套件（例如证书类型不符）",
+			i+1, formatCipherSuite(chosen), i, formatCipherSuite(usable[0]),
+		)
+	}
+	return fmt.Sprintf("密码套件偏好：服务器选择的 %s 不在客户端提供的列表中", formatCipherSuite(chosen))
+}
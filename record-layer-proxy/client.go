@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipid/learn-tls/record-layer-proxy/recordproxy"
+)
+
+// clientTimeout 是子命令 client 等待服务器回应的最长时间
+const clientTimeout = 10 * time.Second
+
+// clientDefaultCipherSuites 是子命令 client 未指定 -ciphers 时提供的密码套件，按偏好顺序排列
+var clientDefaultCipherSuites = []uint16{
+	0x1301, 0x1302, 0x1303, // TLS 1.3 的 AES-128-GCM、AES-256-GCM、ChaCha20-Poly1305
+	0xC02B, 0xC02F, 0xC02C, 0xC030, 0xCCA9, 0xCCA8, // ECDHE + AEAD
+	0xC009, 0xC013, 0xC00A, 0xC014, // ECDHE + CBC
+	0x009C, 0x009D, 0x002F, 0x0035, // RSA 密钥交换
+}
+
+// clientOptions 是子命令 client 构造 ClientHello 所用的参数
+type clientOptions struct {
+	// 不为 0 时只提供该版本，否则同时提供 TLS 1.2 和 TLS 1.3
+	version uint16
+	// 为空时不发送 server_name 扩展
+	sni          string
+	alpn         []string
+	cipherSuites []uint16
+}
+
+// parseClientOptions 解析子命令 client 的参数。未指定 -sni 时，目标地址中的主机名不是 IP 地址就用它作为 SNI
+func parseClientOptions(addr, version, sni, alpn, ciphers string) (*clientOptions, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("目标地址：%w", err)
+	}
+
+	opts := &clientOptions{sni: sni}
+	if opts.sni == "" && net.ParseIP(host) == nil {
+		opts.sni = host
+	}
+
+	if version != "" {
+		if opts.version, err = parseForceVersion(version); err != nil {
+			return nil, fmt.Errorf("-version：%w", err)
+		}
+	}
+
+	for _, proto := range strings.Split(alpn, ",") {
+		if proto = strings.TrimSpace(proto); proto != "" {
+			opts.alpn = append(opts.alpn, proto)
+		}
+	}
+
+	if ciphers == "" {
+		for _, suite := range clientDefaultCipherSuites {
+			// 只提供一个版本时，去掉不能与该版本一起使用的默认套件，以免服务器选不出套件
+			if opts.version == 0 || (suite>>8 == 0x13) == (opts.version == versionTLS13) {
+				opts.cipherSuites = append(opts.cipherSuites, suite)
+			}
+		}
+		return opts, nil
+	}
+	for _, field := range strings.Split(ciphers, ",") {
+		field = strings.TrimSpace(field)
+		suite, ok := lookupCode(CIPHER_SUITE_TABLE, field)
+		if !ok {
+			// 也接受 0x1301 这种十六进制写法
+			n, err := strconv.ParseUint(field, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("-ciphers：未知的密码套件 %q", field)
+			}
+			suite = uint16(n)
+		}
+		opts.cipherSuites = append(opts.cipherSuites, suite)
+	}
+	return opts, nil
+}
+
+// buildClientHello 按 opts 构造一条 ClientHello 记录（含记录层头部）。
+// 代理不会继续完成握手，因此 key_share 中的 X25519 公钥只是随机字节，没有对应的私钥
+func buildClientHello(opts *clientOptions) []byte {
+	hello := &clientHello{
+		legacyVersion:      versionTLS12,
+		random:             randomBytes(32),
+		sessionID:          randomBytes(32),
+		cipherSuites:       opts.cipherSuites,
+		compressionMethods: []byte{0},
+		extensions:         []tlsExtension{},
+	}
+
+	if opts.sni != "" {
+		hello.extensions = append(hello.extensions, tlsExtension{extServerName, buildServerNameExtension(opts.sni)})
+	}
+	hello.extensions = append(hello.extensions,
+		// extended_master_secret
+		tlsExtension{23, nil},
+		// renegotiation_info
+		tlsExtension{0xFF01, []byte{0}},
+		// x25519、secp256r1、secp384r1
+		tlsExtension{extSupportedGroups, []byte{0x00, 0x06, 0x00, 0x1D, 0x00, 0x17, 0x00, 0x18}},
+		tlsExtension{extECPointFormats, []byte{1, 0}},
+		// ecdsa_secp256r1_sha256、rsa_pss_rsae_sha256、rsa_pkcs1_sha256、ecdsa_secp384r1_sha384、
+		// rsa_pss_rsae_sha384、rsa_pkcs1_sha384、rsa_pss_rsae_sha512、rsa_pkcs1_sha512、ed25519、rsa_pkcs1_sha1
+		tlsExtension{extSignatureAlgorithms, []byte{
+			0x00, 0x14, 0x04, 0x03, 0x08, 0x04, 0x04, 0x01, 0x05, 0x03, 0x08, 0x05, 0x05, 0x01, 0x08, 0x06, 0x06, 0x01, 0x08, 0x07, 0x02, 0x01,
+		}},
+	)
+	if len(opts.alpn) > 0 {
+		hello.extensions = append(hello.extensions, tlsExtension{extALPN, appendUint16LengthPrefixed(nil, func(b []byte) []byte {
+			for _, proto := range opts.alpn {
+				b = appendUint8LengthPrefixed(b, func(b []byte) []byte {
+					return append(b, proto...)
+				})
+			}
+			return b
+		})})
+	}
+	hello.extensions = append(hello.extensions,
+		tlsExtension{extSupportedVersions, []byte{4, 0x03, 0x04, 0x03, 0x03}},
+		tlsExtension{extKeyShare, appendUint16LengthPrefixed(nil, func(b []byte) []byte {
+			b = appendUint16(b, 0x001D)
+			return appendUint16LengthPrefixed(b, func(b []byte) []byte {
+				return append(b, randomBytes(32)...)
+			})
+		})},
+		// psk_dhe_ke
+		tlsExtension{extPSKKeyExchangeModes, []byte{1, 1}},
+	)
+
+	if opts.version != 0 {
+		hello.forceVersion(opts.version)
+	}
+	// 与常见的客户端相同，记录层版本使用 TLS 1.0，以兼容只认识旧版本的中间设备
+	return appendHandshakeRecords(nil, versionTLS10, hello.marshal())
+}
+
+// runClient 作为 TLS 客户端向 addr 发送 ClientHello，读取服务器的回应直到看不到更多明文握手消息：
+// TLS 1.2 及更早版本读到 ServerHelloDone（其间包括证书），TLS 1.3 读到 ServerHello 后的第一条加密记录。
+// 连接经过一个进程内的代理，因此所有记录都按代理的规则输出，-tree、-narrate 等参数同样适用
+func runClient(addr string, opts *clientOptions) {
+	proxyListener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	panicIfErr(err, "runClient")
+	defer proxyListener.Close()
+
+	proxyDone := make(chan struct{})
+	go func() {
+		defer close(proxyDone)
+		conn, err := proxyListener.AcceptTCP()
+		if err != nil {
+			return
+		}
+		handleNewIncomingConn(conn, addr)
+	}()
+
+	conn, err := net.DialTCP("tcp", nil, proxyListener.Addr().(*net.TCPAddr))
+	panicIfErr(err, "runClient")
+	_ = conn.SetDeadline(time.Now().Add(clientTimeout))
+
+	var reason string
+	if _, err := conn.Write(buildClientHello(opts)); err != nil {
+		reason = fmt.Sprintf("发送 ClientHello 失败：%v", err)
+	} else {
+		reason = readServerFlight(conn)
+	}
+	_ = conn.Close()
+
+	select {
+	case <-proxyDone:
+	case <-time.After(clientTimeout):
+	}
+	logPrintf("[client %s] 结束：%s\n", addr, reason)
+}
+
+// readServerFlight 读取服务器回应的记录，返回停止读取的原因
+func readServerFlight(conn *net.TCPConn) string {
+	var handshakeBuf []byte
+	tls13 := false
+	for {
+		var headerBuf [recordproxy.HeaderLength]byte
+		header, _, err := recordproxy.ReadHeader(conn, headerBuf[:])
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "服务器关闭了连接"
+			}
+			return fmt.Sprintf("读取失败：%v", err)
+		}
+		payload := make([]byte, header.Length)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return fmt.Sprintf("读取失败：%v", err)
+		}
+
+		switch header.ContentType {
+		case contentTypeAlert:
+			if len(payload) == 2 {
+				return fmt.Sprintf("服务器发送了警报 %s", alertSpec{level: payload[0], description: payload[1]})
+			}
+			return "服务器发送了警报"
+		case contentTypeApplicationData:
+			if tls13 {
+				return "TLS 1.3 中 ServerHello 之后的握手消息（包括证书）都是加密的，无法继续解析"
+			}
+			return "服务器在握手完成前发送了应用数据"
+		case contentTypeHandshake:
+		default:
+			continue
+		}
+
+		handshakeBuf = append(handshakeBuf, payload...)
+		for len(handshakeBuf) >= 4 {
+			msgLength := 4 + (int(handshakeBuf[1])<<16 | int(handshakeBuf[2])<<8 | int(handshakeBuf[3]))
+			if len(handshakeBuf) < msgLength {
+				break
+			}
+			msgType, body := handshakeBuf[0], handshakeBuf[4:msgLength]
+			handshakeBuf = handshakeBuf[msgLength:]
+
+			switch msgType {
+			case handshakeTypeServerHello:
+				hello, err := parseServerHello(body)
+				if err != nil {
+					return fmt.Sprintf("无法解析 ServerHello：%v", err)
+				}
+				if hello.isHelloRetryRequest() {
+					return "服务器发送了 HelloRetryRequest，子命令 client 不会重新发送 ClientHello"
+				}
+				tls13 = hello.selectedVersion() == versionTLS13
+			case handshakeTypeServerHelloDone:
+				return "收到了 ServerHelloDone，服务器的明文握手消息已全部收到"
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// knownJA3 是内置的 JA3 指纹库：JA3 哈希 → 客户端。同一客户端的不同版本、不同配置（如是否提供 ALPN）的指纹并不相同，
+// 这里只收录了几个常见命令行工具默认配置下的指纹，可以用 -client-fingerprints 补充
+var knownJA3 = map[string]string{
+	"9b7dcdf3f997f1fb7b4409c94cb7ef36": "Go crypto/tls（Go 1.27 默认配置）",
+	"0149f47eabf9a20d0893e2a44e5a6323": "curl 7.88（OpenSSL 3.0）",
+	"93c7d42c0df602fb91589311534831f5": "Python ssl（OpenSSL 3.0）",
+}
+
+// clientTrait 是某类 TLS 实现在 ClientHello 中的典型特征。JA3 对扩展顺序敏感，
+// Chrome 每次连接都会打乱扩展顺序，JA3 也就每次都不同，这时只能依靠这些特征推测
+type clientTrait struct {
+	name string
+	// 依据的特征
+	basis string
+	match func(hello *clientHello) bool
+}
+
+// clientTraits 按从具体到笼统的顺序排列，取第一条匹配的
+var clientTraits = []clientTrait{
+	{"Chrome 或其他基于 Chromium 的浏览器", "带 GREASE，且携带只有 Chrome 使用的 ALPS 扩展", func(h *clientHello) bool {
+		_, _, alps := alpsProtocols(h.extensions)
+		return hasGREASE(h) && alps
+	}},
+	{"Safari 或其他使用 Apple TLS 栈的客户端", "带 GREASE，证书压缩只支持 zlib", func(h *clientHello) bool {
+		algorithms, ok := certCompressionAlgorithms(h.extensions)
+		return hasGREASE(h) && ok && len(algorithms) == 1 && algorithms[0] == 1
+	}},
+	{"基于 BoringSSL 的客户端（如 Chromium 内核的应用、Cronet、Android 应用）", "带 GREASE", hasGREASE},
+	{"Firefox（NSS）", "没有 GREASE，同时携带 record_size_limit 和 delegated_credentials", func(h *clientHello) bool {
+		return !hasGREASE(h) && hasExtensions(h, extRecordSizeLimit, extDelegatedCredentials)
+	}},
+	{"Java（JSSE）", "携带几乎只有 Java 使用的 status_request_v2 扩展", func(h *clientHello) bool {
+		return hasExtensions(h, 17)
+	}},
+	{"基于 OpenSSL 的客户端（如 curl、Python、wget）", "没有 GREASE，携带 encrypt_then_mac，且 TLS 1.3 套件中 AES-256-GCM 排在最前", func(h *clientHello) bool {
+		return !hasGREASE(h) && hasExtensions(h, extEncryptThenMAC) && firstTLS13Suite(h) == 0x1302
+	}},
+	{"Go crypto/tls", "没有 GREASE 和 padding，携带 signed_certificate_timestamp 但不携带 encrypt_then_mac", func(h *clientHello) bool {
+		return !hasGREASE(h) && hasExtensions(h, 18) && !hasExtensions(h, extEncryptThenMAC) && !hasExtensions(h, extPadding)
+	}},
+}
+
+func hasGREASE(hello *clientHello) bool {
+	for _, ext := range hello.extensions {
+		if isGREASE(ext.extType) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasExtensions 判断 ClientHello 是否携带了全部 extTypes
+func hasExtensions(hello *clientHello, extTypes ...uint16) bool {
+	for _, extType := range extTypes {
+		if _, ok := findExtension(hello.extensions, extType); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// firstTLS13Suite 返回客户端最优先的 TLS 1.3 密码套件，没有时返回 0
+func firstTLS13Suite(hello *clientHello) uint16 {
+	for _, suite := range hello.cipherSuites {
+		if suite>>8 == 0x13 {
+			return suite
+		}
+	}
+	return 0
+}
+
+// guessClient 推测发出 ClientHello 的 TLS 实现，返回推测结果和可信程度的说明，无法推测时 name 为空。
+// JA3 与指纹库完全一致时最可信，其次才依据特征推测。两者都可以被刻意伪造，只能作为参考
+func guessClient(hello *clientHello, ja3Hash string) (name, basis string) {
+	fingerprints := config.clientFingerprints
+	if fingerprints == nil {
+		fingerprints = knownJA3
+	}
+	if name, ok := fingerprints[ja3Hash]; ok {
+		return name, "JA3 与指纹库中的记录完全一致，可信度较高"
+	}
+	for _, trait := range clientTraits {
+		if trait.match(hello) {
+			return trait.name, "依据特征：" + trait.basis + "，可信度一般"
+		}
+	}
+	return "", ""
+}
+
+// loadClientFingerprints 读取 -client-fingerprints 指定的文件，每行为“JA3 哈希 客户端名称”，# 之后为注释。
+// 返回内置指纹库与文件内容合并后的结果，文件中的记录优先
+func loadClientFingerprints(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fingerprints := map[string]string{}
+	for hash, name := range knownJA3 {
+		fingerprints[hash] = name
+	}
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		hash, name := strings.ToLower(fields[0]), strings.Join(fields[1:], " ")
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != 16 {
+			return nil, fmt.Errorf("%s 第 %d 行：%q 不是 JA3 哈希（32 位十六进制的 MD5）", path, lineNum, hash)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%s 第 %d 行：JA3 哈希之后缺少客户端名称", path, lineNum)
+		}
+		fingerprints[hash] = name
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return fingerprints, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ipid/learn-tls/record-layer-proxy/recordproxy"
+)
+
+// clientHelloBuffer 缓存客户端发出的第一条握手消息（它可能跨越多条记录），
+// 待 ClientHello 完整后再决定是拒绝、改写还是原样转发
+type clientHelloBuffer struct {
+	recordVersion uint16
+	buf           []byte
+	// 已缓存的各条记录的载荷长度，日志中与实际转发的长度对照
+	recordLengths []int
+	done          bool
+}
+
+// helloDecision 是对缓存下来的 ClientHello 的处理结果
+type helloDecision struct {
+	// 需要转发给服务器的全部记录（含记录层头部）
+	records []byte
+	// 处理过程中产生的说明
+	notes []string
+	// 不为 nil 时拒绝该连接：不转发任何数据，向客户端发送该警报后断开
+	reject *alertSpec
+}
+
+// push 放入一条握手记录的载荷。ClientHello 仍不完整时返回 nil；
+// 完整后返回对它的处理结果，并将 done 置为 true。
+// 消息头声明的长度或已缓存的数据超过 maxSize 时返回错误，调用方应放弃该连接，
+// 以免恶意客户端声明一个很大的长度后一点点地发送，让代理一直缓存下去
+func (b *clientHelloBuffer) push(recordVersion uint16, payload []byte, maxSize int) (*helloDecision, error) {
+	if b.buf == nil {
+		b.recordVersion = recordVersion
+	}
+	if len(b.buf)+len(payload) > maxSize {
+		return nil, fmt.Errorf("缓存的数据将达到 %d 字节，超过了上限 %d 字节", len(b.buf)+len(payload), maxSize)
+	}
+	b.buf = append(b.buf, payload...)
+	b.recordLengths = append(b.recordLengths, len(payload))
+
+	if len(b.buf) < 4 {
+		return nil, nil
+	}
+	msgLength := 4 + (int(b.buf[1])<<16 | int(b.buf[2])<<8 | int(b.buf[3]))
+	if msgLength > maxSize {
+		return nil, fmt.Errorf("握手消息声明的长度为 %d 字节，超过了上限 %d 字节", msgLength, maxSize)
+	}
+	if len(b.buf) < msgLength {
+		return nil, nil
+	}
+	b.done = true
+
+	msg, rest := b.buf[:msgLength], b.buf[msgLength:]
+	decision := decideClientHello(msg)
+	if decision.reject == nil {
+		// 同一条记录里 ClientHello 之后若还有别的数据，原样接在（可能改写过的）消息后面
+		decision.records = appendHandshakeRecords(nil, b.recordVersion, append(decision.records, rest...))
+	}
+	return decision, nil
+}
+
+// describeForwardedLengths 返回日志中转发缓存的 ClientHello 时的长度说明，如 “308（改写前 319）”。
+// ClientHello 跨越或被切分成多条记录时依次列出各条记录的长度，与原始长度相同时不再附上原始长度
+func describeForwardedLengths(forwarded []recordproxy.Record, original []int) string {
+	join := func(lengths []int) string {
+		parts := make([]string, len(lengths))
+		for i, n := range lengths {
+			parts[i] = strconv.Itoa(n)
+		}
+		return strings.Join(parts, "、")
+	}
+	forwardedLengths := make([]int, len(forwarded))
+	for i, record := range forwarded {
+		forwardedLengths[i] = record.Length
+	}
+	text := join(forwardedLengths)
+	if origText := join(original); origText != text {
+		text += "（改写前 " + origText + "）"
+	}
+	return text
+}
+
+// decideClientHello 依次按 -inject-alert、SNI 策略、JA3 策略、版本策略和改写配置处理一条完整的 ClientHello 握手消息（含消息头）。
+// 返回结果中的 records 此时只是（可能改写过的）握手消息本身，尚未分装成记录
+func decideClientHello(msg []byte) *helloDecision {
+	if config.injectAlert != nil {
+		return &helloDecision{
+			notes:  []string{fmt.Sprintf("按 -inject-alert 的配置注入警报 %s", config.injectAlert)},
+			reject: config.injectAlert,
+		}
+	}
+
+	if config.sniPolicy != nil && msg[0] == handshakeTypeClientHello {
+		sni := ""
+		if hello, err := parseClientHello(msg[4:]); err == nil {
+			sni = serverName(hello.extensions)
+		}
+		if allowed, reason := config.sniPolicy.check(sni); !allowed {
+			return &helloDecision{
+				notes:  []string{fmt.Sprintf("SNI 策略拒绝了该连接：%s，发送警报 %s", reason, config.sniPolicy.alert)},
+				reject: &config.sniPolicy.alert,
+			}
+		}
+	}
+
+	if config.ja3Policy != nil && msg[0] == handshakeTypeClientHello {
+		// JA3 按客户端发出的原始 ClientHello 计算，因此要在改写之前判断
+		hello, err := parseClientHello(msg[4:])
+		if err != nil {
+			hello = nil
+		}
+		if allowed, reason := config.ja3Policy.check(hello); !allowed {
+			return &helloDecision{
+				notes:  []string{fmt.Sprintf("JA3 策略拒绝了该连接：%s，发送警报 %s", reason, config.ja3Policy.alert)},
+				reject: &config.ja3Policy.alert,
+			}
+		}
+	}
+
+	if config.versionPolicy != nil && msg[0] == handshakeTypeClientHello {
+		hello, err := parseClientHello(msg[4:])
+		if err != nil {
+			hello = nil
+		}
+		if allowed, reason := config.versionPolicy.check(hello); !allowed {
+			return &helloDecision{
+				notes:  []string{fmt.Sprintf("版本策略拒绝了该连接：%s，发送警报 %s", reason, config.versionPolicy.alert)},
+				reject: &config.versionPolicy.alert,
+			}
+		}
+	}
+
+	newMsg, notes, err := rewriteClientHello(msg)
+	if err != nil {
+		notes = append(notes, fmt.Sprintf("无法解析 ClientHello，原样转发：%v", err))
+	}
+	return &helloDecision{records: newMsg, notes: notes}
+}
+
+// rewriteClientHello 按配置改写一条完整的 ClientHello 握手消息（含消息头），
+// 返回改写后的消息以及对改动的说明。没有任何改动时返回原消息
+func rewriteClientHello(msg []byte) ([]byte, []string, error) {
+	if msg[0] != handshakeTypeClientHello {
+		return msg, []string{"第一条握手消息不是 ClientHello，原样转发"}, nil
+	}
+
+	hello, err := parseClientHello(msg[4:])
+	if err != nil {
+		return msg, nil, err
+	}
+
+	var notes []string
+	changed := false
+
+	if config.stripExt != nil {
+		extType := *config.stripExt
+		name := fmt.Sprintf("%s (%d)", lookupName(EXTENSION_TYPE_TABLE, extType), extType)
+		if hello.removeExtension(extType) {
+			changed = true
+			notes = append(notes, fmt.Sprintf("移除了扩展 %s", name))
+		} else {
+			notes = append(notes, fmt.Sprintf("ClientHello 中没有扩展 %s，无需移除", name))
+		}
+	}
+
+	if config.rewriteSNI != "" {
+		oldHost, existed := hello.setServerName(config.rewriteSNI)
+		changed = true
+		if existed {
+			notes = append(notes, fmt.Sprintf("SNI 由 %q 改写为 %q", oldHost, config.rewriteSNI))
+		} else {
+			notes = append(notes, fmt.Sprintf("原 ClientHello 中没有 SNI，插入了 %q", config.rewriteSNI))
+		}
+	}
+
+	if config.forceVersion != 0 {
+		version := config.forceVersion
+		offeredTLS13 := false
+		for _, v := range hello.supportedVersions() {
+			offeredTLS13 = offeredTLS13 || v == versionTLS13
+		}
+		hello.forceVersion(version)
+		changed = true
+		notes = append(notes, fmt.Sprintf("只提供 %s", formatVersion(version)))
+		if offeredTLS13 && version != versionTLS13 {
+			// RFC 8446 4.1.3：协商出更低版本的 TLS 1.3 服务器会在 ServerHello.random 的最后 8 字节写入降级标记
+			notes = append(notes, "客户端本身支持 TLS 1.3，若服务器也支持，它会在 ServerHello.random 中写入降级保护标记，客户端发现后会以 illegal_parameter 中止握手")
+		}
+		if note := forceVersionCaveat(hello, version); note != "" {
+			notes = append(notes, note)
+		}
+	}
+
+	if !changed {
+		return msg, notes, nil
+	}
+
+	newMsg := hello.marshal()
+	notes = append(notes, fmt.Sprintf(
+		"握手消息长度 %d → %d；由于双方计算的握手摘要已不一致，握手通常会失败",
+		len(msg)-4,
+		len(newMsg)-4,
+	))
+	return newMsg, notes, nil
+}
+
+// parseForceVersion 解析 -force-version 和 -min-version 的参数，接受 “1.2”、“tls1.2” 和 “TLS 1.2” 等写法。
+// SSL 3.0 已被 RFC 7568 禁用，几乎所有服务器都会直接断开而不是回应警报，因此不允许强制使用
+func parseForceVersion(s string) (uint16, error) {
+	name := strings.TrimPrefix(strings.ToLower(strings.ReplaceAll(s, " ", "")), "tls")
+	for version, versionName := range VERSION_TABLE {
+		if strings.TrimPrefix(strings.ToLower(strings.ReplaceAll(versionName, " ", "")), "tls") == name {
+			if version < versionTLS10 {
+				return 0, fmt.Errorf("不能强制使用 %s，只支持 TLS 1.0 到 TLS 1.3", versionName)
+			}
+			return version, nil
+		}
+	}
+	return 0, fmt.Errorf("未知的版本 %q，可以使用 1.0、1.1、1.2 或 1.3", s)
+}
+
+// forceVersionCaveat 检查改写后的 ClientHello 是否还可能与强制的版本配合使用，返回需要提醒的问题
+func forceVersionCaveat(hello *clientHello, version uint16) string {
+	hasTLS13Suite, hasOtherSuite := false, false
+	for _, suite := range hello.cipherSuites {
+		if isGREASE(suite) {
+			continue
+		}
+		// TLS 1.3 的密码套件编号都是 0x13XX
+		if suite>>8 == 0x13 {
+			hasTLS13Suite = true
+		} else {
+			hasOtherSuite = true
+		}
+	}
+
+	switch {
+	case version == versionTLS13 && !hasTLS13Suite:
+		return "ClientHello 中没有 TLS 1.3 的密码套件，服务器无法选择 TLS 1.3"
+	case version == versionTLS13:
+		if _, ok := findExtension(hello.extensions, extKeyShare); !ok {
+			return "ClientHello 中没有 key_share 扩展，服务器至少需要发送 HelloRetryRequest"
+		}
+	case !hasOtherSuite:
+		return fmt.Sprintf("ClientHello 中只有 TLS 1.3 的密码套件，服务器无法以 %s 完成协商", formatVersion(version))
+	}
+	return ""
+}
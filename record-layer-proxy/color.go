@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI 颜色代码
+const (
+	colorRed    = "31"
+	colorYellow = "33"
+)
+
+// decideColor 决定日志是否使用 ANSI 颜色，优先级从高到低为：
+//  1. -color=always 或 -color=never；
+//  2. 环境变量 NO_COLOR 非空时不使用颜色（https://no-color.org）；
+//  3. 环境变量 CLICOLOR_FORCE 非空且不为 0 时使用颜色，即使输出不是终端；
+//  4. 日志输出到终端时使用颜色，输出到文件或管道时不使用。
+//
+// 2～4 只在 -color=auto（默认）时生效
+func decideColor(mode string, toTerminal bool) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+	default:
+		return false, fmt.Errorf("-color：应为 always、auto 或 never，而不是 %q", mode)
+	}
+
+	if os.Getenv("NO_COLOR") != "" {
+		return false, nil
+	}
+	if force := os.Getenv("CLICOLOR_FORCE"); force != "" && force != "0" {
+		return true, nil
+	}
+	return toTerminal, nil
+}
+
+// isTerminal 判断 f 是否是终端（字符设备）
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize 在启用颜色时用 ANSI 转义序列给 s 着色
+func colorize(color, s string) string {
+	if !config.color {
+		return s
+	}
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
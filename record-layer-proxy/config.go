@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"os"
+	"time"
+)
+
+// proxyConfig 保存转发过程中需要用到的命令行配置，在 main 中填好后只读（logger 除外）
+type proxyConfig struct {
+	// 不为 nil 时，代理在看到 ClientHello 后不再转发，而是向客户端注入该警报并断开连接
+	injectAlert *alertSpec
+	// 不为 nil 时，按 ClientHello 中的 SNI 决定是否放行连接
+	sniPolicy *sniPolicy
+	// 不为 nil 时，只放行 JA3 哈希在允许列表中的客户端
+	ja3Policy *ja3Policy
+	// 不为 nil 时，拒绝低于指定版本的连接
+	versionPolicy *versionPolicy
+	// 不为 nil 时，转发 ClientHello 前移除该类型的扩展
+	stripExt *uint16
+	// 不为空时，转发 ClientHello 前将其中的 SNI 改写为该主机名
+	rewriteSNI string
+	// 不为 0 时，转发 ClientHello 前将其改写为只提供该版本
+	forceVersion uint16
+	// 不为 nil 时每条连接从中选择一个后端，代替 -r 指定的远程地址
+	backends *backendPool
+	// 是否以叙述的方式输出握手过程
+	narrate bool
+	// 是否不解析记录，直接原样转发
+	raw bool
+	// 是否在发现协议违规时发送致命警报并中止连接，而不只是输出警告
+	strict bool
+	// 是否在后端中途断开而客户端仍连接时重新连接后端（只支持 -raw）
+	follow bool
+	// 是否只详细输出第一条 ClientHello，之后原样转发
+	firstRecordOnly bool
+	// 是否只按内容类型统计记录，不解析也不输出逐条记录
+	countOnly bool
+	// 握手完成前多久没有推进握手的记录、或连续多少条记录没有推进握手时报告握手停滞，0 表示不检查
+	handshakeStallTimeout time.Duration
+	maxIdleRecords        int
+	// 是否以十六进制文本输出每条连接的第一条 ClientHello 记录，以及不为空时把它写入该目录下的文件
+	printHelloBytes bool
+	helloBytesDir   string
+	// 不为空时把每条连接的记录写入该目录下的捕获文件
+	captureDir string
+	// 捕获文件是否使用 pcapng 格式（-capture-format pcapng），否则使用 tlscap 格式
+	capturePcapng bool
+	// 是否只输出连接摘要，不输出逐条记录的信息
+	summaryOnly bool
+	// 是否以 JSON 格式输出连接摘要
+	jsonSummary bool
+	// 是否在握手完成后立即关闭连接，不转发应用数据
+	noForward bool
+	// 是否用系统根证书验证服务器的证书链
+	verifyCerts bool
+	// 是否输出服务器叶子证书的 SHA-256 指纹
+	certFingerprint bool
+	// 是否解释密码套件名称的各个组成部分
+	explain bool
+	// 是否说明服务器选择密码套件时遵循的是客户端还是自己的偏好顺序
+	cipherPreference bool
+	// 不为 nil 时用这个服务器私钥解密 TLS 1.2 RSA 密钥交换的连接
+	decryptKey *rsa.PrivateKey
+	// 不为 nil 时从这个 NSS 格式的密钥日志中查找密钥，解密 TLS 1.2 和 TLS 1.3 的连接
+	keyLog *keyLog
+	// 日志中的警告是否使用 ANSI 颜色，由 decideColor 根据 -color 和环境变量决定
+	color bool
+	// 是否以 Wireshark 的风格输出每条记录的逐层解析树
+	tree bool
+	// 是否在 ChangeCipherSpec 之后仍按明文的格式解析握手和警报记录，用来演示加密数据对明文解析器来说只是噪声
+	inspectAfterCCS bool
+	// 连接到这些后端端口时视为明文流量，不解析 TLS 记录
+	plaintextPorts map[int]bool
+	// 不为 0 时每条连接在握手完成前最多允许的握手数据字节数，超过后关闭连接
+	maxHandshakeSize int
+	// ClientHello 中扩展和密码套件数量的合理上限，超过时发出警告，0 表示不检查
+	maxHelloExtensions   int
+	maxHelloCipherSuites int
+	// 不为 nil 时记住最近见过的 ClientHello random，发现不同连接间重复的 random 时发出警告
+	seenRandoms *randomLRU
+	// 不为 nil 时只输出这些内容类型的记录（转发行、-hexdump 和 -tree），握手分析和警告不受影响
+	onlyContentTypes map[byte]bool
+	// 不为空时只解析该方向（"client" 或 "server"）的记录，另一个方向原样转发
+	tap string
+	// 不为空时把解析的记录镜像到该 TCP 地址
+	tapSink string
+	// 是否以十六进制输出每条记录的原始字节
+	hexdump bool
+	// 输出记录的原始字节时，每条记录最多输出的字节数，0 表示不限制
+	maxRecordLogBytes int
+	// 不为 0 时每个方向只解析前 limitRecords 条记录，之后原样转发，limitRecordsClose 为 true 时改为关闭连接。
+	// 按方向而不是按连接计数，这样结果不受两个方向的记录到达先后的影响
+	limitRecords      int
+	limitRecordsClose bool
+	// 缓存 ClientHello 时最多缓存的字节数
+	maxHelloSize int
+	// 缓存 ClientHello 时，从连接建立起等待 ClientHello 完整到达的最长时间
+	helloTimeout time.Duration
+	// 指定 -mitm 时用于为客户端签发证书的根证书，不为 nil 时代理终结两侧的 TLS 并输出明文
+	mitmCA *mitmCA
+	// -mitm 模式下是否跳过对后端证书的验证，仅供实验环境使用
+	mitmInsecure bool
+	// 与后端之间的 TLS 配置（-upstream-*），指定 -upstream-tls 或 -mitm 时不为 nil。
+	// 不指定 -mitm 时，客户端的数据原样放在这条 TLS 连接中转发给后端
+	upstreamTLS *tls.Config
+	// JA3 哈希 → 客户端名称，用于推测客户端的 TLS 实现；为 nil 时只使用内置的指纹库
+	clientFingerprints map[string]string
+	// 所有日志的输出目标，默认为标准输出，指定 -log-file 时改为滚动写入的日志文件。
+	// 运行期间只能通过 setLogger 替换
+	logger Logger
+}
+
+// needsHelloBuffer 判断是否需要先把客户端的 ClientHello 完整缓存下来，经过检查或改写后再转发
+func (c *proxyConfig) needsHelloBuffer() bool {
+	return c.injectAlert != nil || c.sniPolicy != nil || c.ja3Policy != nil || c.versionPolicy != nil || c.stripExt != nil || c.rewriteSNI != "" || c.forceVersion != 0
+}
+
+// needsHelloBytes 判断是否需要输出客户端的第一条 ClientHello 的原始字节
+func (c *proxyConfig) needsHelloBytes() bool {
+	return c.printHelloBytes || c.helloBytesDir != ""
+}
+
+var config = proxyConfig{logger: writerLogger{os.Stdout}}
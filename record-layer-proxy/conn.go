@@ -0,0 +1,1205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// warnSSLv3 输出一条醒目的 SSL 3.0 警告。SSL 3.0 已被 RFC 7568 废弃，
+// 它的 CBC 填充不受 MAC 保护，因此易受 POODLE 攻击
+func warnSSLv3(what string) {
+	logPrintf("%s\n", colorize(colorRed, fmt.Sprintf("⚠ 警告：%s。SSL 3.0 已被 RFC 7568 废弃，且易受 POODLE 攻击（CVE-2014-3566）", what)))
+}
+
+// warnInsecureCipherSuite 输出一条醒目的不安全密码套件警告
+func warnInsecureCipherSuite(what string) {
+	logPrintf("%s\n", colorize(colorRed, fmt.Sprintf("⚠ 警告：%s。NULL 加密的密码套件不提供机密性，匿名密钥交换不验证服务器的身份，两者都不应在实际中使用", what)))
+}
+
+// warnWeakCipherSuites 输出一条醒目的警告，lines 是 describeWeakCipherSuites 按类别归并的说明，每类一行
+func warnWeakCipherSuites(what string, lines []string) {
+	msg := fmt.Sprintf("⚠ 警告：%s，所用的算法已被攻破或严重削弱：", what)
+	for _, line := range lines {
+		msg += "\n  - " + line
+	}
+	logPrintf("%s\n", colorize(colorRed, msg))
+}
+
+// connState 保存一条被代理连接的共享状态。
+// 两个方向的转发 goroutine 都会读写它，访问可变字段时需持有 mu
+type connState struct {
+	// 连接编号，从 1 开始递增
+	id         uint64
+	startTime  time.Time
+	clientConn *net.TCPConn
+	serverConn *net.TCPConn
+	// 两个方向上已转发的字节数
+	bytesFromClient atomic.Int64
+	bytesFromServer atomic.Int64
+	// 指定 -capture-dir 时该连接的捕获文件，否则为 nil
+	capture recordCapture
+	// 是否以 -count-only 的方式转发，此时连接关闭时输出计数而不是连接摘要
+	countOnly bool
+	// 两个方向上按内容类型统计的记录数和字节数，下标 0 为客户端方向，1 为服务器方向。
+	// 各自只由该方向的转发 goroutine 写入，两个方向都结束后才在连接摘要中读取，因此无需加锁
+	typeStats [2]contentTypeStats
+	// 两个方向上相邻记录到达间隔的直方图，下标和访问方式与 typeStats 相同
+	gapStats [2]recordGapStats
+	// 指定 -tap-sink 时该连接的镜像连接，否则为 nil。只由被解析的那个方向的 goroutine 写入，写入失败后置为 nil
+	tapSink net.Conn
+	// 分别保护向客户端和向服务器的写入。注入警报等操作会从另一个方向的 goroutine 写入同一条连接，
+	// 持锁写入保证一条记录的头部和载荷是连续写出的，不会与其他写入交错
+	clientWriteMu sync.Mutex
+	serverWriteMu sync.Mutex
+
+	mu sync.Mutex
+	// 客户端在 ClientHello 中请求的主机名，未携带 SNI 时为空
+	sni string
+	// 服务器在 ServerHello 中选定的版本和密码套件，version 为 0 表示尚未看到 ServerHello
+	version     uint16
+	cipherSuite uint16
+	// 客户端与服务器各自通告的 record_size_limit（RFC 8449），0 表示未通告
+	clientRecordSizeLimit uint16
+	serverRecordSizeLimit uint16
+	// 双方是否已经发送 Finished，两者都为 true 时握手完成
+	clientFinished bool
+	serverFinished bool
+	// 握手完成的时间，握手未完成时为零值
+	handshakeDoneAt time.Time
+	// 这条连接上出现过的警告，会列入连接摘要
+	warnings []string
+	// 客户端 ClientHello 的 JA3 指纹及其 MD5
+	ja3     string
+	ja3Hash string
+	// 根据 ClientHello 推测的客户端 TLS 实现，无法推测时为空
+	clientGuess string
+	// 服务器叶子证书 DER 编码的 SHA-256（十六进制），只有 TLS 1.2 及更早版本中才看得到
+	leafSHA256 string
+	// ClientHello 的 legacy_version 及承载它的记录的记录层版本，0 表示尚未看到 ClientHello
+	clientLegacyVersion      uint16
+	clientHelloRecordVersion uint16
+	// 客户端提供的 ALPN 协议列表，以及服务器在 ServerHello 中选中的协议（TLS 1.3 中位于加密的 EncryptedExtensions，代理看不到）
+	clientALPN []string
+	serverALPN string
+	// 客户端在 ClientHello 中按偏好顺序提供的密码套件
+	clientCipherSuites []uint16
+	// 客户端在 supported_versions 扩展中提供的版本，未携带该扩展时为 nil
+	clientVersions []uint16
+	// 客户端在 ClientHello 中携带的 session_id，以及是否尝试用 PSK 恢复会话、发送 0-RTT 数据（TLS 1.3）
+	clientSessionID  []byte
+	offeredPSK       bool
+	offeredEarlyData bool
+	// 客户端是否通过 delegated_credentials 扩展表示接受委托凭据（RFC 9345）
+	clientOfferedDC bool
+	// 客户端在 compress_certificate 扩展中提供的证书压缩算法（RFC 8879），未携带该扩展时为 nil
+	clientCertCompression []uint16
+	// 服务器是否发送过 HelloRetryRequest
+	helloRetried bool
+	// 本次连接是否是通过会话恢复建立的
+	resumed bool
+	// 服务器签发的 NewSessionTicket 数量，只统计以明文传输的 TLS 1.2 票据
+	sessionTickets int
+	// 握手完成前两个方向的握手记录（TLS 1.3 中还包括承载加密握手消息的 application_data 记录）的载荷总字节数
+	handshakeBytes int
+	// 双方的 random，以及是否协商了 extended_master_secret（RFC 7627）和 encrypt_then_mac（RFC 7366）
+	clientRandom         []byte
+	serverRandom         []byte
+	clientOfferedEMS     bool
+	extendedMasterSecret bool
+	encryptThenMAC       bool
+	// 指定 -decrypt-key 时按顺序拼接的明文握手消息（含消息头），用于计算 extended_master_secret
+	transcript []byte
+	// 成功解密预主密钥（-decrypt-key）或在密钥日志中找到主密钥（-decrypt-keylog）后，TLS 1.2 两个方向的记录密钥
+	keys *sessionKeys
+	// -strict 模式下第一处待中止连接的协议违规，以及是否已经据此中止了连接
+	strictViolation *strictViolation
+	strictAborted   bool
+	// 指定 -handshake-stall-timeout 或 -max-idle-records 时检查握手是否停滞
+	watchdog handshakeWatchdog
+	// -narrate 模式下按发生顺序记录的握手过程，以及是否已经输出过
+	narration        []string
+	narrationPrinted bool
+}
+
+// lastConnID 是最近分配的连接编号
+var lastConnID atomic.Uint64
+
+func newConnState(clientConn, serverConn *net.TCPConn) *connState {
+	return &connState{
+		id:         lastConnID.Add(1),
+		startTime:  time.Now(),
+		clientConn: clientConn,
+		serverConn: serverConn,
+	}
+}
+
+// addBytes 累计某个方向上已转发的字节数
+func (s *connState) addBytes(fromClient bool, n int64) {
+	if fromClient {
+		s.bytesFromClient.Add(n)
+	} else {
+		s.bytesFromServer.Add(n)
+	}
+}
+
+// addHandshakeBytes 把一条握手完成前的记录计入 handshakeBytes，返回累计的字节数；握手完成后的记录不计入
+func (s *connState) addHandshakeBytes(contentType byte, n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handshakeComplete() {
+		return s.handshakeBytes
+	}
+	if contentType == contentTypeHandshake || contentType == contentTypeApplicationData && s.version == versionTLS13 {
+		s.handshakeBytes += n
+	}
+	return s.handshakeBytes
+}
+
+// directionLabel 返回形如 "客户端 127.0.0.1:1234 → 服务器 127.0.0.1:443" 的方向标签，用作日志前缀。
+// 代理接受的入站连接一方是客户端，代理主动连接的一方是服务器，标明角色后就不必记住哪个地址是谁
+func (s *connState) directionLabel(fromClient bool) string {
+	if fromClient {
+		return fmt.Sprintf("客户端 %s → 服务器 %s", s.clientConn.RemoteAddr(), s.serverConn.RemoteAddr())
+	}
+	return fmt.Sprintf("服务器 %s → 客户端 %s", s.serverConn.RemoteAddr(), s.clientConn.RemoteAddr())
+}
+
+// writeTo 持有 to 对应的写锁，把 parts 依次完整写入 to。
+// 所有解析过记录的转发都应通过它写入；rawForward 不解析也不注入数据，直接使用 rawCopy
+func (s *connState) writeTo(to *net.TCPConn, parts ...[]byte) error {
+	mu := &s.serverWriteMu
+	if to == s.clientConn {
+		mu = &s.clientWriteMu
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, data := range parts {
+		if err := writeFull(to, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTapSink 把一条记录写入镜像连接。写入失败时关闭镜像连接并不再使用，但不影响被代理的连接
+func (s *connState) writeTapSink(header, payload []byte) error {
+	if err := writeFull(s.tapSink, header); err != nil {
+		_ = s.tapSink.Close()
+		s.tapSink = nil
+		return err
+	}
+	if err := writeFull(s.tapSink, payload); err != nil {
+		_ = s.tapSink.Close()
+		s.tapSink = nil
+		return err
+	}
+	return nil
+}
+
+// closeBoth 立即关闭两侧的连接，两个方向的转发 goroutine 都会因此结束
+func (s *connState) closeBoth() {
+	_ = s.clientConn.Close()
+	_ = s.serverConn.Close()
+}
+
+// describeDirection 返回形如 "客户端 (127.0.0.1:1234 --> 127.0.0.1:443) " 的方向描述，用于提示信息
+func (s *connState) describeDirection(fromClient bool) string {
+	if fromClient {
+		return fmt.Sprintf("客户端 (%s --> %s) ", s.clientConn.RemoteAddr(), s.serverConn.RemoteAddr())
+	}
+	return fmt.Sprintf("服务器 (%s --> %s) ", s.serverConn.RemoteAddr(), s.clientConn.RemoteAddr())
+}
+
+// handshakeComplete 判断握手是否已经完成，调用时需持有 mu
+func (s *connState) handshakeComplete() bool {
+	return s.clientFinished && s.serverFinished
+}
+
+// isHandshakeComplete 与 handshakeComplete 相同，但会自己获取 mu
+func (s *connState) isHandshakeComplete() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handshakeComplete()
+}
+
+// setFinished 记录某一方已经发送 Finished，并在握手完成时记下完成的时间，调用时需持有 mu
+func (s *connState) setFinished(fromClient bool) {
+	if fromClient {
+		s.clientFinished = true
+	} else {
+		s.serverFinished = true
+	}
+	if s.handshakeComplete() && s.handshakeDoneAt.IsZero() {
+		s.handshakeDoneAt = time.Now()
+	}
+}
+
+// addWarningLocked 把一条警告记入连接摘要，重复的警告只记一次，调用时需持有 mu
+func (s *connState) addWarningLocked(fromClient bool, msg string) {
+	role := "服务器"
+	if fromClient {
+		role = "客户端"
+	}
+	msg = role + "：" + msg
+	// 同一条警告可能在每条记录上都出现一次，摘要里只保留一条
+	for _, w := range s.warnings {
+		if w == msg {
+			return
+		}
+	}
+	s.warnings = append(s.warnings, msg)
+}
+
+// addWarning 把一条警告记入连接摘要
+func (s *connState) addWarning(fromClient bool, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addWarningLocked(fromClient, msg)
+}
+
+// warnf 输出一条警告并把它记入连接摘要，调用时需持有 mu
+func (s *connState) warnf(fromClient bool, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	s.logf(fromClient, "%s", colorize(colorYellow, msg))
+	s.addWarningLocked(fromClient, msg)
+}
+
+// maxRecordLength 返回发往另一方的、内容类型为 contentType 的记录允许的最大长度
+func (s *connState) maxRecordLength(contentType byte) int {
+	if contentType != contentTypeApplicationData {
+		return maxPlaintextLength
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 加密后的记录允许比明文多出一些：TLS 1.3 最多 256 字节（RFC 8446 5.2），
+	// TLS 1.2 最多 2048 字节（RFC 5246 6.2.3）
+	if s.version == versionTLS13 {
+		return maxPlaintextLength + 256
+	}
+	return maxPlaintextLength + 2048
+}
+
+// peerRecordSizeLimit 返回接收方（即 fromClient 的另一端）生效的 record_size_limit，0 表示不受限或无法确认。
+// RFC 8449 规定双方都携带该扩展、协商成功后限制才生效，因此只有代理看到了双方的扩展时才返回限制。
+// TLS 1.3 中服务器的回应位于加密的 EncryptedExtensions 里，代理看不到，因此总是返回 0，
+// 否则会把遵守协议、只是没有接受该扩展的服务器误报为违规（-strict 模式下还会中止连接）
+func (s *connState) peerRecordSizeLimit(fromClient bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.version == versionTLS13 || s.clientRecordSizeLimit == 0 || s.serverRecordSizeLimit == 0 {
+		return 0
+	}
+	if fromClient {
+		return int(s.serverRecordSizeLimit)
+	}
+	return int(s.clientRecordSizeLimit)
+}
+
+// logf 输出一条关于某个方向上握手消息的详细信息
+func (s *connState) logf(fromClient bool, format string, args ...any) {
+	logDetailf("[handshake %s] %s\n", s.directionLabel(fromClient), fmt.Sprintf(format, args...))
+}
+
+// narrate 在 -narrate 模式下追加一步握手过程，调用时需持有 mu
+func (s *connState) narrate(fromClient bool, format string, args ...any) {
+	if !config.narrate {
+		return
+	}
+
+	role := "服务器"
+	if fromClient {
+		role = "客户端"
+	}
+	s.narration = append(s.narration, role+fmt.Sprintf(format, args...))
+}
+
+// printNarration 输出整理好的握手过程，每条连接只输出一次，调用时需持有 mu
+func (s *connState) printNarration(ending string) {
+	if !config.narrate || s.narrationPrinted {
+		return
+	}
+	s.narrationPrinted = true
+
+	story := fmt.Sprintf("[narrate %s --> %s] 握手过程：\n", s.clientConn.RemoteAddr(), s.serverConn.RemoteAddr())
+	for i, step := range append(s.narration, ending) {
+		story += fmt.Sprintf("  %d. %s\n", i+1, step)
+	}
+	logPrint(story)
+}
+
+// onClose 在两个方向都结束后调用
+func (s *connState) onClose() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.printNarration("连接关闭，握手未完成")
+	if s.countOnly && !config.jsonSummary {
+		s.printCountTally()
+	} else if config.jsonSummary {
+		s.printJSONSummary()
+	} else {
+		s.printSummary()
+	}
+}
+
+// contentTypeStats 按内容类型统计一个方向上转发的记录数和字节数（含 5 字节的记录层头部）
+type contentTypeStats struct {
+	records [256]int64
+	bytes   [256]int64
+}
+
+// describe 按内容类型编号的顺序列出出现过的类型，例如 “Handshake 4 条 / 1808 字节”
+func (t *contentTypeStats) describe() []string {
+	var parts []string
+	for contentType, records := range t.records {
+		if records == 0 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s %d 条 / %d 字节", lookupName(CONTENT_TYPE_TABLE, byte(contentType)), records, t.bytes[contentType]))
+	}
+	return parts
+}
+
+// directionState 保存一个方向上的解析状态，只由该方向的转发 goroutine 访问
+type directionState struct {
+	conn       *connState
+	fromClient bool
+	// 尚未拼成完整消息的握手数据
+	handshakeBuf []byte
+	// 该方向是否已经发送过 ChangeCipherSpec（TLS 1.2），此后发出的记录都是加密的
+	encrypted bool
+	// 该方向是否已经发送过应用数据记录（不含 0-RTT 数据和 EndOfEarlyData）
+	sentApplicationData bool
+	// 客户端在 ServerHello 之前发送的 0-RTT 应用数据记录数，以及是否已经发送 EndOfEarlyData
+	earlyDataRecords   int
+	sentEndOfEarlyData bool
+	// 是否已经就该方向的 SSL 3.0 记录版本、无法识别的记录版本发出过警告
+	warnedSSLv3              bool
+	warnedImplausibleVersion bool
+	// 该方向是否已经发送过明文的 close_notify，或者 TLS 1.2 中 ChangeCipherSpec 之后的加密警报（通常就是 close_notify），
+	// 以及是否已经就此后又出现的记录发出过警告
+	sentCloseNotify    bool
+	sentEncryptedAlert bool
+	warnedAfterClose   bool
+	// 已经发出过的内容类型违规警告，同一种违规每个方向只提示一次
+	warnedPhase map[string]bool
+	// 是否已经按 -print-hello-bytes 或 -hello-bytes-dir 输出过该方向的第一条 ClientHello
+	printedHello bool
+	// 该方向按内容类型的统计和记录到达间隔的直方图，分别指向 conn.typeStats 和 conn.gapStats 中的对应元素
+	typeStats *contentTypeStats
+	gapStats  *recordGapStats
+	// 指定 -decrypt-key 或 -decrypt-keylog 时解密该方向加密记录的解密器，在第一条加密记录到达时创建
+	decryptor *recordDecryptor
+	// 解密出的握手消息的拼接缓冲区，以及是否已经解密出该方向的 Finished（TLS 1.3 中此后换用应用流量密钥）
+	decryptedHandshakeBuf []byte
+	decryptedFinished     bool
+	// -decrypt-keylog 模式下等待密钥写入密钥日志而缓存的记录，以及是否已经因为等不到密钥放弃解密
+	pendingDecrypt   []pendingRecord
+	decryptAbandoned bool
+}
+
+func newDirectionState(conn *connState, fromClient bool) *directionState {
+	i := 1
+	if fromClient {
+		i = 0
+	}
+	return &directionState{
+		conn:       conn,
+		fromClient: fromClient,
+		typeStats:  &conn.typeStats[i],
+		gapStats:   &conn.gapStats[i],
+	}
+}
+
+// countRecord 把一条已转发的记录计入按内容类型的统计，length 含记录层头部
+func (d *directionState) countRecord(contentType byte, length int) {
+	d.typeStats.records[contentType]++
+	d.typeStats.bytes[contentType] += int64(length)
+}
+
+// observeRecord 分析一条完整的记录，更新连接状态
+func (d *directionState) observeRecord(contentType byte, version uint16, payload []byte) {
+	if version == versionSSL30 && !d.warnedSSLv3 {
+		d.warnedSSLv3 = true
+		warnSSLv3(d.conn.describeDirection(d.fromClient) + "的记录层版本为 SSL 3.0")
+		d.conn.addWarning(d.fromClient, "记录层版本为 SSL 3.0")
+	}
+	if _, known := VERSION_TABLE[version]; !known && !d.warnedImplausibleVersion {
+		// 每个方向只提示一次，以免非 TLS 流量的每个“记录”都刷出一条警告
+		d.warnedImplausibleVersion = true
+		d.conn.mu.Lock()
+		d.conn.warnf(d.fromClient, "注意：记录层版本 0x%04X 不是任何已知的 SSL/TLS 版本（0x0300～0x0304），这可能不是 TLS 流量，或者数据已经损坏", version)
+		d.conn.mu.Unlock()
+	}
+	// RFC 5246 7.2.1、RFC 8446 6.1：发送 close_notify 的一方此后不能再发送任何记录。
+	// TLS 1.3 的警报与应用数据一样以 application_data 的形式加密发送，代理无法识别其中的 close_notify
+	if (d.sentCloseNotify || d.sentEncryptedAlert) && !d.warnedAfterClose {
+		d.warnedAfterClose = true
+		name := lookupName(CONTENT_TYPE_TABLE, contentType)
+		d.conn.mu.Lock()
+		if d.sentCloseNotify {
+			d.conn.protocolViolation(d.fromClient, alertUnexpectedMessage, "发送 close_notify 之后又发送了 %s 记录", name)
+		} else {
+			d.conn.warnf(d.fromClient, "注意：发送加密的警报（通常是 close_notify）之后又发送了 %s 记录，若那条警报是 close_notify 则违反了协议", name)
+		}
+		d.conn.mu.Unlock()
+	}
+	if violation := d.checkContentType(contentType); violation != "" && !d.warnedPhase[violation] {
+		if d.warnedPhase == nil {
+			d.warnedPhase = map[string]bool{}
+		}
+		d.warnedPhase[violation] = true
+		d.conn.mu.Lock()
+		d.conn.protocolViolation(d.fromClient, alertUnexpectedMessage, "%s", violation)
+		d.conn.mu.Unlock()
+	}
+
+	if d.shouldDecrypt(contentType) {
+		d.decryptRecord(contentType, version, payload)
+	}
+
+	switch contentType {
+	case contentTypeHandshake:
+		if d.encrypted {
+			d.observeEncryptedHandshake()
+			return
+		}
+
+		// 握手消息可能跨越多条记录，一条记录里也可能有多条握手消息，因此先拼接再逐条取出
+		d.handshakeBuf = append(d.handshakeBuf, payload...)
+		for len(d.handshakeBuf) >= 4 {
+			msgLength := 4 + (int(d.handshakeBuf[1])<<16 | int(d.handshakeBuf[2])<<8 | int(d.handshakeBuf[3]))
+			if len(d.handshakeBuf) < msgLength {
+				break
+			}
+			if d.fromClient && !d.printedHello && d.handshakeBuf[0] == handshakeTypeClientHello && config.needsHelloBytes() {
+				d.printedHello = true
+				d.conn.printHelloBytes(version, d.handshakeBuf[:msgLength])
+			}
+			d.conn.observeHandshakeMessage(d.fromClient, d.handshakeBuf[0], d.handshakeBuf[4:msgLength])
+			d.conn.checkHelloRecordVersion(d.fromClient, d.handshakeBuf[0], version)
+			d.handshakeBuf = d.handshakeBuf[msgLength:]
+		}
+		if len(d.handshakeBuf) == 0 {
+			d.handshakeBuf = nil
+		}
+
+	case contentTypeChangeCipherSpec:
+		d.conn.mu.Lock()
+		d.conn.markHandshakeProgress(d.fromClient, "ChangeCipherSpec")
+		if d.conn.version == versionTLS13 || d.conn.version == 0 && d.fromClient {
+			// TLS 1.3 中的 ChangeCipherSpec 只是为了兼容中间设备而发送的，不代表开始加密
+			d.conn.narrate(d.fromClient, "发送 ChangeCipherSpec（TLS 1.3 中仅用于兼容中间设备）")
+		} else {
+			d.encrypted = true
+			d.conn.narrate(d.fromClient, "发送 ChangeCipherSpec，此后该方向的记录均已加密")
+		}
+		d.conn.mu.Unlock()
+
+	case contentTypeAlert:
+		d.conn.mu.Lock()
+		if d.encrypted || len(payload) != 2 {
+			if d.encrypted {
+				d.sentEncryptedAlert = true
+			}
+			d.conn.narrate(d.fromClient, "发送加密的警报")
+		} else {
+			if payload[1] == alertCloseNotify {
+				d.sentCloseNotify = true
+			}
+			d.conn.narrate(d.fromClient, "发送警报：%s", alertSpec{level: payload[0], description: payload[1]})
+			if !d.fromClient && payload[1] == alertUnrecognizedName {
+				d.conn.explainUnrecognizedName(payload[0])
+			}
+			if config.forceVersion != 0 && !d.fromClient && !d.conn.handshakeComplete() {
+				d.conn.logf(false, "服务器在握手期间发送了警报 %s，可能不接受 -force-version 指定的 %s",
+					alertSpec{level: payload[0], description: payload[1]}, formatVersion(config.forceVersion))
+			}
+		}
+		d.conn.mu.Unlock()
+
+	case contentTypeApplicationData:
+		d.observeApplicationData(len(payload))
+	}
+}
+
+// shouldDecrypt 判断指定 -decrypt-key 或 -decrypt-keylog 时是否要解密这条记录：
+// TLS 1.2 中是 ChangeCipherSpec 之后除 ChangeCipherSpec 以外的记录，TLS 1.3 中是 ServerHello 之后的 application_data 记录
+func (d *directionState) shouldDecrypt(contentType byte) bool {
+	if config.decryptKey == nil && config.keyLog == nil {
+		return false
+	}
+	if d.encrypted {
+		return contentType != contentTypeChangeCipherSpec
+	}
+	if config.keyLog == nil || contentType != contentTypeApplicationData {
+		return false
+	}
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+	return d.conn.version == versionTLS13
+}
+
+// describeHandshakeRecord 列出一条握手记录中的每条握手消息，需在 observeRecord 处理该记录之前调用。
+// 一条记录里可以有多条握手消息（例如一条 TLS 1.2 记录里同时放着 ServerHello、Certificate 和 ServerHelloDone），
+// 记录开头也可能是上一条记录中未完成的消息的后续部分
+func (d *directionState) describeHandshakeRecord(payload []byte) string {
+	if d.encrypted {
+		// 记录内容已加密，开头的 4 个字节并不是握手消息头，不能按类型和长度解析
+		suffix := ""
+		if config.inspectAfterCCS {
+			suffix = "；" + inspectEncryptedRecord(contentTypeHandshake, payload)
+		}
+		if d.hasSentFinished() {
+			return "，加密的握手消息（Finished 之后，可能是重新协商）" + suffix
+		}
+		return "，加密的 Finished" + suffix
+	}
+
+	pending := len(d.handshakeBuf)
+	data := append(append([]byte(nil), d.handshakeBuf...), payload...)
+	var parts []string
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < 4 {
+			parts = append(parts, "不完整的握手消息头（持续到后续记录）")
+			break
+		}
+		length := int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+		part := fmt.Sprintf("%s (%d)，长度 %d", lookupName(HANDSHAKE_TYPE_TABLE, data[pos]), data[pos], length)
+		if pos < pending {
+			part = "上一条记录中 " + part + " 的后续部分"
+		}
+		end := pos + 4 + length
+		if end > len(data) {
+			parts = append(parts, part+"（持续到后续记录）")
+			break
+		}
+		parts = append(parts, part)
+		pos = end
+	}
+	return "，握手消息：" + strings.Join(parts, "；")
+}
+
+// inspectEncryptedRecord 是 -inspect-after-ccs：明知记录已经加密，仍按明文握手消息或警报的格式解析。
+// 密文看起来是随机字节，解析出的类型多半未知、声明的长度也对不上记录的长度，这正说明了为什么必须先解密
+func inspectEncryptedRecord(contentType byte, payload []byte) string {
+	const label = "尝试解析加密数据（结果无意义）："
+	switch contentType {
+	case contentTypeHandshake:
+		if len(payload) < 4 {
+			return label + fmt.Sprintf("只有 %d 字节，连握手消息头都不够", len(payload))
+		}
+		length := int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+		result := fmt.Sprintf("握手类型 %s (%d)，声明长度 %d", lookupName(HANDSHAKE_TYPE_TABLE, payload[0]), payload[0], length)
+		if length != len(payload)-4 {
+			result += fmt.Sprintf("，而记录中只剩 %d 字节", len(payload)-4)
+		}
+		return label + result
+	case contentTypeAlert:
+		if len(payload) < 2 {
+			return label + fmt.Sprintf("只有 %d 字节，不够一条警报", len(payload))
+		}
+		result := fmt.Sprintf("警报级别 %s (%d)，警报描述 %s (%d)",
+			lookupName(ALERT_LEVEL_TABLE, payload[0]), payload[0], lookupName(ALERT_DESCRIPTION_TABLE, payload[1]), payload[1])
+		if len(payload) != 2 {
+			result += fmt.Sprintf("，但警报应为 2 字节，记录却有 %d 字节", len(payload))
+		}
+		return label + result
+	}
+	return ""
+}
+
+// checkContentType 检查内容类型为 contentType 的记录是否可以出现在当前的握手阶段，
+// 不可以时返回违规的描述，否则返回空字符串
+func (d *directionState) checkContentType(contentType byte) string {
+	s := d.conn
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch contentType {
+	case contentTypeHandshake:
+		// TLS 1.3 中握手完成后的握手消息（NewSessionTicket、KeyUpdate 等）都是加密的，不会以明文握手记录出现
+		if s.version == versionTLS13 && s.handshakeComplete() {
+			return "TLS 1.3 握手完成后出现了明文的握手记录"
+		}
+
+	case contentTypeChangeCipherSpec:
+		// 客户端在 TLS 1.3 兼容模式下可能在收到 ServerHello 之前就发出 ChangeCipherSpec，服务器则不会
+		if s.version == 0 && !d.fromClient {
+			return "服务器在 ServerHello 之前发送了 ChangeCipherSpec"
+		}
+		if s.version == versionTLS13 && s.handshakeComplete() {
+			return "TLS 1.3 握手完成后出现了 ChangeCipherSpec（RFC 8446 5）"
+		}
+
+	case contentTypeApplicationData:
+		// 携带 early_data 扩展的客户端可以紧跟着 ClientHello 发送 0-RTT 数据
+		if s.version == 0 && d.fromClient && s.offeredEarlyData {
+			return ""
+		}
+		if s.version == 0 {
+			return "握手开始前（尚未看到 ServerHello）出现了应用数据"
+		}
+		// TLS 1.2 及更早的版本中，应用数据只能在该方向发送 ChangeCipherSpec、开始加密之后出现
+		if s.version != versionTLS13 && !d.encrypted {
+			return "该方向发送 ChangeCipherSpec 之前出现了应用数据，应用数据必须加密传输"
+		}
+
+	case contentTypeAlert, contentTypeHeartbeat:
+
+	default:
+		return fmt.Sprintf("未知的内容类型 %d", contentType)
+	}
+	return ""
+}
+
+// checkHelloListSizes 检查 ClientHello 中扩展和密码套件的数量是否超出 -max-hello-extensions 和 -max-hello-cipher-suites 的上限。
+// 格式上扩展最多可以有上万个、密码套件最多 32767 个，但真实的客户端远远用不了这么多，
+// 数量异常往往说明对端是模糊测试工具，或者在试探服务器解析器的资源消耗。调用时需持有 mu
+func (s *connState) checkHelloListSizes(fromClient bool, hello *clientHello) {
+	grease := 0
+	for _, suite := range hello.cipherSuites {
+		if isGREASE(suite) {
+			grease++
+		}
+	}
+	if limit := config.maxHelloCipherSuites; limit > 0 && len(hello.cipherSuites) > limit {
+		s.warnf(fromClient, "异常：ClientHello 提供了 %d 个密码套件（其中 GREASE %d 个），超过了 -max-hello-cipher-suites 的上限 %d，可能是攻击或模糊测试",
+			len(hello.cipherSuites), grease, limit)
+	}
+
+	grease = 0
+	for _, ext := range hello.extensions {
+		if isGREASE(ext.extType) {
+			grease++
+		}
+	}
+	if limit := config.maxHelloExtensions; limit > 0 && len(hello.extensions) > limit {
+		s.warnf(fromClient, "异常：ClientHello 携带了 %d 个扩展（其中 GREASE %d 个），超过了 -max-hello-extensions 的上限 %d，可能是攻击或模糊测试",
+			len(hello.extensions), grease, limit)
+	}
+}
+
+// checkHelloRecordVersion 检查承载 ClientHello 和 ServerHello 的记录的记录层版本，
+// 出现无法用“记录层版本惯例”解释的情况时输出提示。
+// 惯例是：客户端还不知道服务器支持哪些版本，第一条记录的版本通常写 TLS 1.0（0x0301），
+// 且不高于 ClientHello 的 legacy_version（RFC 5246 附录 E、RFC 8446 5.1）；
+// 服务器的记录层版本等于协商出的版本，但 TLS 1.3 中固定写 TLS 1.2（0x0303）。
+// 因此客户端写 0x0301、服务器回 0x0303 是正常的
+func (s *connState) checkHelloRecordVersion(fromClient bool, msgType byte, recordVersion uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case fromClient && msgType == handshakeTypeClientHello:
+		if s.clientHelloRecordVersion != 0 {
+			// HelloRetryRequest 之后的第二个 ClientHello 不再检查
+			return
+		}
+		s.clientHelloRecordVersion = recordVersion
+		if recordVersion < versionSSL30 || recordVersion > versionTLS12 {
+			s.warnf(fromClient, "注意：ClientHello 的记录层版本 0x%04X 不在 0x0300～0x0303 之内", recordVersion)
+		} else if s.clientLegacyVersion != 0 && recordVersion > s.clientLegacyVersion {
+			s.warnf(
+				fromClient,
+				"注意：ClientHello 的记录层版本 %s 高于它的 legacy_version %s，记录层版本通常不高于客户端支持的最高版本",
+				formatVersion(recordVersion),
+				formatVersion(s.clientLegacyVersion),
+			)
+		}
+
+	case !fromClient && msgType == handshakeTypeServerHello:
+		expected := s.version
+		if expected >= versionTLS13 {
+			expected = versionTLS12
+		}
+		if recordVersion != expected {
+			s.warnf(
+				fromClient,
+				"注意：ServerHello 的记录层版本为 %s，ClientHello 的为 %s，但协商出的版本是 %s，按惯例服务器的记录层版本应为 %s",
+				formatVersion(recordVersion),
+				formatVersion(s.clientHelloRecordVersion),
+				formatVersion(s.version),
+				formatVersion(expected),
+			)
+		}
+	}
+}
+
+// explainUnrecognizedName 把服务器发送的 unrecognized_name 警报与客户端请求的 SNI 联系起来，调用时需持有 mu。
+// 服务器不认识客户端请求的主机名时会发送这个警报（RFC 6066 3），常见于虚拟主机配置缺失或 SNI 拼写错误
+func (s *connState) explainUnrecognizedName(level byte) {
+	var msg string
+	if s.sni == "" {
+		msg = "服务器发送了 unrecognized_name 警报，但客户端没有发送 SNI：服务器可能要求客户端必须提供 SNI"
+	} else {
+		msg = fmt.Sprintf("服务器以 unrecognized_name 警报回应了 SNI=%s，很可能是 SNI 不匹配：服务器上没有配置这个主机名的虚拟主机", s.sni)
+	}
+	if level == alertLevelWarning {
+		msg += "。这是警告级别的警报，握手可能继续，但服务器多半会使用默认证书，客户端验证主机名时可能失败"
+	} else {
+		msg += "。这是致命警报，握手就此中止"
+	}
+	s.warnf(false, "%s", msg)
+}
+
+// hasSentFinished 判断该方向是否已经发送过 Finished
+func (d *directionState) hasSentFinished() bool {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+	if d.fromClient {
+		return d.conn.clientFinished
+	}
+	return d.conn.serverFinished
+}
+
+// observeEncryptedHandshake 处理 TLS 1.2 中 ChangeCipherSpec 之后的握手记录。
+// 紧跟在 ChangeCipherSpec 之后的一定是加密的 Finished，再之后的只可能是重新协商等握手后的消息
+func (d *directionState) observeEncryptedHandshake() {
+	if d.hasSentFinished() {
+		d.conn.mu.Lock()
+		d.conn.narrate(d.fromClient, "发送加密的握手消息（可能是重新协商）")
+		d.conn.mu.Unlock()
+		return
+	}
+
+	s := d.conn
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.narrate(d.fromClient, "发送加密的 Finished")
+	s.markHandshakeProgress(d.fromClient, "加密的 Finished")
+	s.setFinished(d.fromClient)
+	if s.handshakeComplete() {
+		s.printNarration("握手完成")
+	}
+}
+
+// observeApplicationData 处理应用数据记录。TLS 1.3 中 ServerHello 之后的握手消息也以应用数据记录的形式加密传输，
+// 因此服务器发出的第一批应用数据记录是加密的握手消息，客户端发出的第一条应用数据记录是它的 Finished。
+// 客户端发送过 0-RTT 数据并且服务器接受时，Finished 之前还有一条加密的 EndOfEarlyData
+func (d *directionState) observeApplicationData(length int) {
+	s := d.conn
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.version == versionTLS13 && !s.handshakeComplete() {
+		s.markHandshakeProgress(d.fromClient, "加密的握手消息")
+	}
+
+	// 0-RTT：ClientHello 中带有 early_data 扩展的客户端不等 ServerHello 就用早期密钥发送应用数据
+	if d.fromClient && s.version == 0 && s.offeredEarlyData {
+		d.earlyDataRecords++
+		if d.earlyDataRecords == 1 {
+			s.narrate(true, "不等服务器回应就发送 0-RTT 早期数据")
+		}
+		return
+	}
+
+	// 服务器接受了早期数据时，客户端在 Finished 之前先发送 EndOfEarlyData（RFC 8446 4.5）。
+	// 它用握手密钥加密，无法看到内容，只能根据长度推测：消息本身只有 4 字节的消息头
+	if d.fromClient && s.version == versionTLS13 && !s.handshakeComplete() && d.earlyDataRecords > 0 &&
+		!d.sentEndOfEarlyData && length == endOfEarlyDataRecordLength(s.cipherSuite) {
+		d.sentEndOfEarlyData = true
+		s.logf(true, "这条 %d 字节的加密记录应该是 EndOfEarlyData：此前客户端发送了 %d 条 0-RTT 记录，服务器接受了早期数据", length, d.earlyDataRecords)
+		s.narrate(true, "发送加密的 EndOfEarlyData，0-RTT 早期数据到此结束")
+		return
+	}
+
+	first := !d.sentApplicationData
+	d.sentApplicationData = true
+
+	// TLS False Start（RFC 7918）：TLS 1.2 完整握手中，客户端发出自己的 Finished 后不等服务器的 Finished
+	// 就开始发送应用数据，从而节省一个往返。会话恢复时服务器先发 Finished，因此不会被误判
+	if d.fromClient && first && s.version != versionTLS13 && s.clientFinished && !s.serverFinished {
+		s.logf(true, "检测到 TLS False Start：客户端在收到服务器的 Finished 之前就发送了应用数据")
+		s.narrate(true, "未等待服务器的 Finished 就开始发送应用数据（False Start）")
+		return
+	}
+
+	if s.version != versionTLS13 || s.handshakeComplete() || !first {
+		return
+	}
+
+	if d.fromClient {
+		s.narrate(true, "发送加密的 Finished")
+		// 客户端只有在验证了服务器的 Finished 之后才会发送自己的 Finished
+		s.setFinished(false)
+		s.setFinished(true)
+		s.printNarration("握手完成")
+	} else {
+		s.narrate(false, "发送加密的握手消息（EncryptedExtensions、Certificate、CertificateVerify、Finished 等），代理无法看到内容")
+	}
+}
+
+// observeHandshakeMessage 解析一条完整的明文握手消息（body 不含 4 字节消息头），并把协商出的参数记入 state
+func (s *connState) observeHandshakeMessage(fromClient bool, msgType byte, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.markHandshakeProgress(fromClient, lookupName(HANDSHAKE_TYPE_TABLE, msgType))
+
+	if config.decryptKey != nil && s.keys == nil {
+		s.transcript = append(appendUint24(append(s.transcript, msgType), uint32(len(body))), body...)
+	}
+
+	switch msgType {
+	case handshakeTypeClientHello:
+		hello, err := parseClientHello(body)
+		if err != nil {
+			s.narrate(fromClient, "发送了无法解析的 ClientHello：%v", err)
+			if config.strict {
+				// 不指定 -strict 时不作为违规报告，以免非 TLS 流量或代理尚不认识的格式刷出警告
+				s.protocolViolation(fromClient, alertDecodeError, "ClientHello 的长度字段与内容不一致，无法解析：%v", err)
+			}
+			return
+		}
+		if extType, dup := duplicateExtension(hello.extensions); dup {
+			s.protocolViolation(fromClient, alertIllegalParameter, "ClientHello 中扩展 %s 出现了多次", formatCode(EXTENSION_TYPE_TABLE, extType))
+		}
+		s.clientRecordSizeLimit = recordSizeLimit(hello.extensions)
+		s.clientCipherSuites = hello.cipherSuites
+		s.checkHelloListSizes(fromClient, hello)
+		if hello.legacyVersion == versionSSL30 {
+			warnSSLv3(s.describeDirection(fromClient) + "的 ClientHello 最高只支持 SSL 3.0")
+			s.addWarningLocked(fromClient, "ClientHello 最高只支持 SSL 3.0")
+		}
+
+		var insecure []string
+		for _, suite := range hello.cipherSuites {
+			if len(cipherSuiteFlaws(suite)) > 0 {
+				insecure = append(insecure, formatCipherSuite(suite))
+			}
+		}
+		if len(insecure) > 0 {
+			warnInsecureCipherSuite(fmt.Sprintf("%s的 ClientHello 提供了 %d 个不安全的密码套件：%s", s.describeDirection(fromClient), len(insecure), strings.Join(insecure, "、")))
+			s.addWarningLocked(fromClient, fmt.Sprintf("提供了 %d 个不安全的密码套件", len(insecure)))
+		}
+		if lines, n := describeWeakCipherSuites(hello.cipherSuites); n > 0 {
+			warnWeakCipherSuites(fmt.Sprintf("%s的 ClientHello 提供了 %d 个已知脆弱的旧式密码套件", s.describeDirection(fromClient), n), lines)
+			s.addWarningLocked(fromClient, fmt.Sprintf("提供了 %d 个已知脆弱的旧式密码套件", n))
+		}
+
+		order, note := extensionOrder(hello.extensions)
+		s.logf(fromClient, "ClientHello 的扩展顺序（%d 个）：%s；%s", len(hello.extensions), order, note)
+
+		if protocols, extType, ok := alpsProtocols(hello.extensions); ok {
+			// 服务器的回应位于 EncryptedExtensions 中，代理看不到服务器是否同意以及交换的设置内容
+			s.logf(fromClient, "ClientHello 携带非标准的实验性扩展 application_settings（ALPS，%d），愿意在握手中交换应用层设置的协议：%s",
+				extType, strings.Join(protocols, "、"))
+		}
+
+		// Chrome 等客户端会用 padding 把 ClientHello 凑到 512 字节以上，
+		// 以避开某些 F5 设备在 ClientHello 长度处于 256～511 字节时的 bug
+		if length, allZero, ok := padding(hello.extensions); ok {
+			s.logf(fromClient, "ClientHello 携带 padding 扩展：填充 %d 字节，ClientHello 总长 %d 字节", length, len(body)+4)
+			if !allZero {
+				s.protocolViolation(fromClient, alertIllegalParameter, "padding 扩展的内容应全为 0")
+			}
+		}
+
+		s.clientSessionID = hello.sessionID
+		// 收到 HelloRetryRequest 后，客户端的第二条 ClientHello 会沿用同一个 random，只检查第一条
+		if config.seenRandoms != nil && s.clientRandom == nil {
+			if previous := config.seenRandoms.observe(hello.random, s.id, s.clientConn.RemoteAddr().String()); previous != nil {
+				s.warnf(fromClient, "注意：ClientHello 的 random 与 %s 前的连接 #%d（客户端 %s）完全相同，可能是重放的 ClientHello，或者客户端的随机数生成器有问题",
+					time.Since(previous.seenAt).Round(time.Millisecond), previous.connID, previous.clientAddr)
+			}
+		}
+		s.clientRandom = hello.random
+		_, s.clientOfferedEMS = findExtension(hello.extensions, extExtendedMasterSecret)
+		s.clientLegacyVersion = hello.legacyVersion
+		s.clientVersions = hello.supportedVersions()
+		s.clientALPN = alpnProtocols(hello.extensions)
+		s.ja3, s.ja3Hash = ja3(hello)
+		if name, basis := guessClient(hello, s.ja3Hash); name != "" && s.clientGuess == "" {
+			s.clientGuess = name
+			s.logf(fromClient, "推测客户端为 %s（%s，仅供参考：TLS 握手会暴露客户端的身份，但也可以被刻意伪造）", name, basis)
+		}
+		if s.clientVersions != nil {
+			s.logf(fromClient, "客户端通过 supported_versions 提供的版本：%s（legacy_version 为 %s）", formatVersionList(s.clientVersions), formatVersion(hello.legacyVersion))
+		}
+		_, s.offeredPSK = findExtension(hello.extensions, extPreSharedKey)
+		_, s.offeredEarlyData = findExtension(hello.extensions, extEarlyData)
+		if schemes, ok := delegatedCredentialSchemes(hello.extensions); ok {
+			s.clientOfferedDC = true
+			s.logf(fromClient, "客户端接受委托凭据（delegated_credentials），委托凭据可使用的签名算法：%s", describeDelegatedCredentialSchemes(schemes))
+		}
+		if algorithms, ok := certCompressionAlgorithms(hello.extensions); ok {
+			s.clientCertCompression = algorithms
+			s.logf(fromClient, "客户端支持压缩的证书（compress_certificate），可解压的算法：%s", describeCertCompressionAlgorithms(algorithms))
+		}
+		if authorities, ok := trustedCAKeys(hello.extensions); ok {
+			s.logf(fromClient, "客户端通过 trusted_ca_keys 列出了自己信任的 CA，供服务器挑选证书链：%s", describeTrustedCAKeys(authorities))
+		} else if _, found := findExtension(hello.extensions, extTrustedCAKeys); found {
+			s.warnf(fromClient, "trusted_ca_keys 扩展格式错误，无法解析")
+		}
+		if data, found := findExtension(hello.extensions, extQUICTransportParameters); found {
+			s.logf(fromClient, "ClientHello 携带 quic_transport_parameters（%s）。这个扩展属于 QUIC：QUIC 用 TLS 1.3 握手，"+
+				"但握手消息放在 QUIC 的 CRYPTO 帧中传输，不使用 TLS 记录层。本代理只转发 TCP 上的 TLS，并不代理 QUIC",
+				describeQUICTransportParameters(data))
+		}
+
+		s.sni = serverName(hello.extensions)
+		sni := s.sni
+		if sni == "" {
+			sni = "（无）"
+		}
+		if s.helloRetried {
+			s.narrate(fromClient, "按 HelloRetryRequest 的要求重新发送 ClientHello，提供 %d 个密码套件，SNI=%s", len(hello.cipherSuites), sni)
+			return
+		}
+		s.narrate(fromClient, "发送 ClientHello，提供 %d 个密码套件，SNI=%s", len(hello.cipherSuites), sni)
+
+	case handshakeTypeServerHello:
+		hello, err := parseServerHello(body)
+		if err != nil {
+			s.narrate(fromClient, "发送了无法解析的 ServerHello：%v", err)
+			if config.strict {
+				s.protocolViolation(fromClient, alertDecodeError, "ServerHello 的长度字段与内容不一致，无法解析：%v", err)
+			}
+			return
+		}
+		if extType, dup := duplicateExtension(hello.extensions); dup {
+			s.protocolViolation(fromClient, alertIllegalParameter, "ServerHello 中扩展 %s 出现了多次", formatCode(EXTENSION_TYPE_TABLE, extType))
+		}
+		s.version = hello.selectedVersion()
+		s.cipherSuite = hello.cipherSuite
+		if hello.isHelloRetryRequest() {
+			s.helloRetried = true
+			reason := "要求客户端重新发送 ClientHello"
+			if group, ok := hello.retryGroup(); ok {
+				reason = fmt.Sprintf("要求客户端改用 %s 重新发送 ClientHello", formatCode(SUPPORTED_GROUP_TABLE, group))
+			}
+			s.logf(fromClient, "这条 ServerHello 的 random 为 SHA-256(\"HelloRetryRequest\")，实际是 HelloRetryRequest：%s", reason)
+			// 握手摘要的替换规则见 RFC 8446 4.4.1
+			s.logf(fromClient,
+				"计算 TLS 1.3 的握手摘要时，第一个 ClientHello 会被替换成一条合成的 message_hash（类型 254）消息，"+
+					"其内容是第一个 ClientHello 的哈希值。这条消息只存在于摘要的计算中，从不在网络上传输，因此抓包中看不到它")
+			s.narrate(fromClient, "回应 HelloRetryRequest，%s", reason)
+			return
+		}
+		s.serverRecordSizeLimit = recordSizeLimit(hello.extensions)
+		if protocols := alpnProtocols(hello.extensions); len(protocols) == 1 {
+			s.serverALPN = protocols[0]
+		}
+		switch {
+		case s.version == versionTLS13 && s.serverALPN != "":
+			s.protocolViolation(fromClient, alertIllegalParameter, "TLS 1.3 的 ALPN 应放在 EncryptedExtensions 中，而不是 ServerHello（RFC 8446 4.2）")
+		case s.version == versionTLS13 && len(s.clientALPN) > 0:
+			s.logf(fromClient, "客户端提供了 ALPN（%s），但 TLS 1.3 中服务器的选择位于加密的 EncryptedExtensions 中，被动代理无法看到；TLS 1.2 中它位于明文的 ServerHello 里", strings.Join(s.clientALPN, "、"))
+		}
+		if s.version == versionSSL30 {
+			warnSSLv3(s.describeDirection(fromClient) + "在 ServerHello 中选择了 SSL 3.0")
+			s.addWarningLocked(fromClient, "选择了 SSL 3.0")
+		}
+		if config.explain {
+			s.logf(fromClient, "选定的密码套件 %s：%s", formatCipherSuite(s.cipherSuite), explainCipherSuite(s.cipherSuite))
+		}
+		if config.verifyCerts && s.version == versionTLS13 {
+			s.logf(fromClient, "TLS 1.3 的证书位于加密的握手消息中，无法验证证书链")
+		}
+		if len(cipherSuiteFlaws(s.cipherSuite)) > 0 {
+			warnInsecureCipherSuite(s.describeDirection(fromClient) + "在 ServerHello 中选择了不安全的密码套件 " + formatCipherSuite(s.cipherSuite))
+			s.addWarningLocked(fromClient, "选择了不安全的密码套件 "+formatCipherSuite(s.cipherSuite))
+		}
+		if lines, n := describeWeakCipherSuites([]uint16{s.cipherSuite}); n > 0 {
+			warnWeakCipherSuites(s.describeDirection(fromClient)+"在 ServerHello 中选择了已知脆弱的旧式密码套件 "+formatCipherSuite(s.cipherSuite), lines)
+			s.addWarningLocked(fromClient, "选择了已知脆弱的旧式密码套件 "+formatCipherSuite(s.cipherSuite))
+		}
+		if _, ok := findExtension(hello.extensions, extSupportedVersions); ok {
+			s.logf(fromClient, "服务器通过 supported_versions 选择了 %s（legacy_version 为 %s）", formatVersion(s.version), formatVersion(hello.legacyVersion))
+			offered := false
+			for _, v := range s.clientVersions {
+				offered = offered || v == s.version
+			}
+			if !offered {
+				s.protocolViolation(fromClient, alertIllegalParameter, "服务器选择的版本不在客户端 supported_versions 提供的列表中")
+			}
+		}
+		// TLS 1.3 中服务器接受 PSK 时会在 ServerHello 中携带 pre_shared_key；更早的版本中，
+		// 服务器回显客户端提供的非空 session_id 表示恢复会话（基于 session ID 或 RFC 5077 票据）
+		if s.version == versionTLS13 {
+			_, s.resumed = findExtension(hello.extensions, extPreSharedKey)
+			if len(hello.sessionID) > 0 && bytes.Equal(hello.sessionID, s.clientSessionID) {
+				// RFC 8446 附录 D.4：中间设备兼容模式下，客户端发送随机的 legacy_session_id，服务器原样回显，
+				// 让这次握手在中间设备看来像是 TLS 1.2 的会话恢复，从而不被拦截
+				s.logf(fromClient,
+					"服务器回显了客户端 %d 字节的 legacy_session_id：这是 TLS 1.3 的中间设备兼容模式，不代表会话恢复（TLS 1.3 的会话恢复要看 pre_shared_key 扩展）",
+					len(hello.sessionID))
+			}
+		} else {
+			s.resumed = len(hello.sessionID) > 0 && bytes.Equal(hello.sessionID, s.clientSessionID)
+		}
+		// RFC 6066 3：服务器使用了客户端请求的主机名时，可以在 ServerHello 中返回一个空的 server_name 扩展。
+		// TLS 1.3 中这个确认位于加密的 EncryptedExtensions 中
+		if data, ok := findExtension(hello.extensions, extServerName); ok && len(data) == 0 && s.sni != "" {
+			s.logf(fromClient, "服务器返回了空的 server_name 扩展，表示它识别了客户端请求的主机名 %q", s.sni)
+		}
+		s.serverRandom = hello.random
+		_, ems := findExtension(hello.extensions, extExtendedMasterSecret)
+		s.extendedMasterSecret = ems && s.clientOfferedEMS
+		_, s.encryptThenMAC = findExtension(hello.extensions, extEncryptThenMAC)
+		if config.decryptKey != nil {
+			s.explainDecryptability(fromClient)
+		}
+		if config.keyLog != nil {
+			s.explainKeyLogDecryptability(fromClient)
+		}
+		if config.cipherPreference {
+			s.logf(fromClient, "%s", describeCipherPreference(s.clientCipherSuites, s.cipherSuite, s.version))
+		}
+		if config.forceVersion != 0 && s.version != config.forceVersion {
+			s.logf(fromClient, "注意：-force-version 指定了 %s，服务器却选择了 %s", formatVersion(config.forceVersion), formatVersion(s.version))
+		}
+		if s.clientOfferedDC && !hello.isHelloRetryRequest() {
+			if s.version != versionTLS13 {
+				s.logf(fromClient, "委托凭据只能用于 TLS 1.3，本连接为 %s，不会使用客户端提供的 delegated_credentials", formatVersion(s.version))
+			} else if config.keyLog == nil {
+				s.logf(fromClient, "服务器是否使用委托凭据要看加密的 Certificate 消息，指定 -decrypt-keylog 才能看到")
+			}
+		}
+		s.narrate(fromClient, "回应 ServerHello，选择 %s，密码套件 %s", formatVersion(s.version), formatCipherSuite(s.cipherSuite))
+
+	case handshakeTypeCertificate:
+		certs, err := parseCertificateList(body)
+		if err != nil {
+			s.narrate(fromClient, "发送了无法解析的 Certificate：%v", err)
+			return
+		}
+		s.narrate(fromClient, "发送证书链（%d 张）", len(certs))
+
+		if !fromClient && len(certs) > 0 {
+			sum := sha256.Sum256(certs[0])
+			s.leafSHA256 = hex.EncodeToString(sum[:])
+		}
+		if config.decryptKey != nil && !fromClient && len(certs) > 0 {
+			s.checkDecryptKeyMatches(fromClient, certs[0])
+		}
+		if config.certFingerprint && !fromClient && len(certs) > 0 {
+			for _, line := range leafFingerprints(certs[0]) {
+				s.logf(fromClient, "%s", line)
+			}
+		}
+		if config.verifyCerts && !fromClient {
+			lines, err := verifyCertificateChain(certs, s.sni)
+			for _, line := range lines {
+				s.logf(fromClient, "%s", line)
+			}
+			if err != nil {
+				s.addWarningLocked(fromClient, "证书链验证失败："+err.Error())
+			}
+		}
+
+	case handshakeTypeCompressedCertificate:
+		// 证书压缩只用于 TLS 1.3，此时 CompressedCertificate 是加密的，明文出现说明对端并不是真正的 TLS 1.3
+		s.logf(fromClient, "注意：CompressedCertificate 以明文出现，RFC 8879 中它只用于 TLS 1.3，应当用握手密钥加密")
+		s.logCompressedCertificate(fromClient, "", body)
+
+	case handshakeTypeServerKeyExchange:
+		s.narrate(fromClient, "发送 ServerKeyExchange（%s）", describeServerKeyExchange(s.cipherSuite, body))
+
+	case handshakeTypeCertificateRequest:
+		s.narrate(fromClient, "发送 CertificateRequest，要求客户端提供证书")
+		s.logCertificateRequest(fromClient, body)
+
+	case handshakeTypeNewSessionTicket:
+		ticket, err := parseNewSessionTicket(body)
+		if err != nil {
+			s.narrate(fromClient, "发送了无法解析的 NewSessionTicket：%v", err)
+			return
+		}
+		s.sessionTickets++
+		lifetime := "未指定有效期"
+		if ticket.lifetimeHint != 0 {
+			lifetime = fmt.Sprintf("建议有效期 %d 秒", ticket.lifetimeHint)
+		}
+		s.narrate(fromClient, "发送 NewSessionTicket（票据 %d 字节，%s）", len(ticket.ticket), lifetime)
+
+	case handshakeTypeCertificateStatus:
+		if s.version == versionTLS13 {
+			// TLS 1.3 中不存在这条消息，而且此时的握手消息都是加密的，不会走到这里
+			return
+		}
+		st, err := parseCertificateStatus(body)
+		if err != nil {
+			s.logf(fromClient, "无法解析 CertificateStatus：%v", err)
+			return
+		}
+		statusType := fmt.Sprintf("未知类型 %d", st.statusType)
+		if st.statusType == 1 {
+			statusType = "OCSP"
+		}
+		s.logf(fromClient, "CertificateStatus：%s，响应 %d 字节", statusType, len(st.response))
+		s.narrate(fromClient, "发送 CertificateStatus，附带了叶子证书的 %s 响应（%d 字节），客户端无需再自行查询吊销状态", statusType, len(st.response))
+
+	case handshakeTypeEndOfEarlyData:
+		// TLS 1.3 正式版中 EndOfEarlyData 是加密的，明文出现说明对端实现的是早期草案，或者并不是真正的 TLS
+		s.logf(fromClient, "注意：EndOfEarlyData 以明文出现，RFC 8446 中它应当用握手密钥加密")
+		if len(body) != 0 {
+			s.protocolViolation(fromClient, alertDecodeError, "EndOfEarlyData 的消息体应为空，实际为 %d 字节", len(body))
+		}
+		s.narrate(fromClient, "发送 EndOfEarlyData（明文），0-RTT 早期数据到此结束")
+
+	case handshakeTypeServerHelloDone:
+		s.narrate(fromClient, "发送 ServerHelloDone，等待客户端回应")
+
+	case handshakeTypeClientKeyExchange:
+		s.narrate(fromClient, "发送 ClientKeyExchange（%d 字节）", len(body))
+		if config.decryptKey != nil {
+			s.deriveKeysRSA(fromClient, body)
+		}
+
+	default:
+		s.narrate(fromClient, "发送 %s (%d)，长度 %d", lookupName(HANDSHAKE_TYPE_TABLE, msgType), msgType, len(body))
+	}
+}
+
+// logCertificateRequest 输出 CertificateRequest 中的证书类型、签名算法和可接受的 CA，调用时需持有 mu。
+// TLS 1.3 的 CertificateRequest 结构完全不同，并且是加密传输的，这里只处理 TLS 1.2 及更早的版本
+func (s *connState) logCertificateRequest(fromClient bool, body []byte) {
+	if s.version == versionTLS13 {
+		return
+	}
+
+	req, err := parseCertificateRequest(body, s.version == versionTLS12)
+	if err != nil {
+		s.logf(fromClient, "无法解析 CertificateRequest：%v", err)
+		return
+	}
+
+	var types []string
+	for _, t := range req.certificateTypes {
+		types = append(types, fmt.Sprintf("%s (%d)", lookupName(CERTIFICATE_TYPE_TABLE, t), t))
+	}
+	var algorithms []string
+	for _, a := range req.signatureAlgorithms {
+		algorithms = append(algorithms, formatSignatureScheme(a))
+	}
+	var dnLengths []string
+	for _, dn := range req.authorities {
+		dnLengths = append(dnLengths, fmt.Sprint(len(dn)))
+	}
+
+	info := fmt.Sprintf("CertificateRequest：证书类型 %d 种（%s）", len(types), strings.Join(types, "、"))
+	if s.version == versionTLS12 {
+		info += fmt.Sprintf("，签名算法 %d 种（%s）", len(algorithms), strings.Join(algorithms, "、"))
+	}
+	if len(dnLengths) == 0 {
+		info += "，未限定 CA"
+	} else {
+		info += fmt.Sprintf("，可接受的 CA %d 个（DN 长度分别为 %s 字节）", len(dnLengths), strings.Join(dnLengths, "、"))
+	}
+	s.logf(fromClient, "%s", info)
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestPeerRecordSizeLimit(t *testing.T) {
+	tests := []struct {
+		name                     string
+		version                  uint16
+		clientLimit, serverLimit uint16
+		// 客户端发出、服务器发出的记录分别受到的限制
+		wantFromClient, wantFromServer int
+	}{
+		{"TLS 1.2 双方都携带", versionTLS12, 1000, 2000, 2000, 1000},
+		{"TLS 1.2 只有客户端携带", versionTLS12, 1000, 0, 0, 0},
+		{"TLS 1.2 只有服务器携带", versionTLS12, 0, 2000, 0, 0},
+		// 服务器是否接受位于加密的 EncryptedExtensions 中，无法确认
+		{"TLS 1.3 只有客户端携带", versionTLS13, 1000, 0, 0, 0},
+		{"TLS 1.3 ServerHello 中也出现了", versionTLS13, 1000, 2000, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &connState{version: tt.version, clientRecordSizeLimit: tt.clientLimit, serverRecordSizeLimit: tt.serverLimit}
+			if got := s.peerRecordSizeLimit(true); got != tt.wantFromClient {
+				t.Errorf("peerRecordSizeLimit(true) = %d，期望 %d", got, tt.wantFromClient)
+			}
+			if got := s.peerRecordSizeLimit(false); got != tt.wantFromServer {
+				t.Errorf("peerRecordSizeLimit(false) = %d，期望 %d", got, tt.wantFromServer)
+			}
+		})
+	}
+}
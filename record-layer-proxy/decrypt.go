@@ -0,0 +1,521 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	extEncryptThenMAC       uint16 = 22
+	extExtendedMasterSecret uint16 = 23
+)
+
+// decryptSuite 描述 -decrypt-key 和 -decrypt-keylog 能够解密的一种密码套件的记录保护方式
+type decryptSuite struct {
+	keyLen int
+	// CBC 套件的 HMAC 密钥长度，AEAD 套件为 0
+	macLen int
+	gcm    bool
+	// PRF（TLS 1.3 中为 HKDF）所用的哈希，以及 CBC 套件的 HMAC 所用的哈希
+	prfHash func() hash.Hash
+	macHash func() hash.Hash
+}
+
+// tls12DecryptSuites 是能够解密的 TLS 1.2 密码套件。其中只有 RSA 密钥交换的套件能用 -decrypt-key 解密，
+// (EC)DHE 的套件需要从 -decrypt-keylog 中得到主密钥
+var tls12DecryptSuites = map[uint16]decryptSuite{
+	0x002F: {keyLen: 16, macLen: 20, prfHash: sha256.New, macHash: sha1.New},   // TLS_RSA_WITH_AES_128_CBC_SHA
+	0x0035: {keyLen: 32, macLen: 20, prfHash: sha256.New, macHash: sha1.New},   // TLS_RSA_WITH_AES_256_CBC_SHA
+	0x003C: {keyLen: 16, macLen: 32, prfHash: sha256.New, macHash: sha256.New}, // TLS_RSA_WITH_AES_128_CBC_SHA256
+	0x003D: {keyLen: 32, macLen: 32, prfHash: sha256.New, macHash: sha256.New}, // TLS_RSA_WITH_AES_256_CBC_SHA256
+	0x009C: {keyLen: 16, gcm: true, prfHash: sha256.New},                       // TLS_RSA_WITH_AES_128_GCM_SHA256
+	0x009D: {keyLen: 32, gcm: true, prfHash: sha512.New384},                    // TLS_RSA_WITH_AES_256_GCM_SHA384
+	0xC009: {keyLen: 16, macLen: 20, prfHash: sha256.New, macHash: sha1.New},   // TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA
+	0xC00A: {keyLen: 32, macLen: 20, prfHash: sha256.New, macHash: sha1.New},   // TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA
+	0xC013: {keyLen: 16, macLen: 20, prfHash: sha256.New, macHash: sha1.New},   // TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA
+	0xC014: {keyLen: 32, macLen: 20, prfHash: sha256.New, macHash: sha1.New},   // TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA
+	0xC023: {keyLen: 16, macLen: 32, prfHash: sha256.New, macHash: sha256.New}, // TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256
+	0xC027: {keyLen: 16, macLen: 32, prfHash: sha256.New, macHash: sha256.New}, // TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256
+	0xC02B: {keyLen: 16, gcm: true, prfHash: sha256.New},                       // TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+	0xC02C: {keyLen: 32, gcm: true, prfHash: sha512.New384},                    // TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384
+	0xC02F: {keyLen: 16, gcm: true, prfHash: sha256.New},                       // TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	0xC030: {keyLen: 32, gcm: true, prfHash: sha512.New384},                    // TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384
+	0x009E: {keyLen: 16, gcm: true, prfHash: sha256.New},                       // TLS_DHE_RSA_WITH_AES_128_GCM_SHA256
+	0x009F: {keyLen: 32, gcm: true, prfHash: sha512.New384},                    // TLS_DHE_RSA_WITH_AES_256_GCM_SHA384
+}
+
+// isRSAKeyExchange 判断 TLS 1.2 密码套件是否使用 RSA 密钥交换（而不是 (EC)DHE）
+func isRSAKeyExchange(suite uint16) bool {
+	return strings.HasPrefix(CIPHER_SUITE_TABLE[suite], "TLS_RSA_")
+}
+
+// loadRSAPrivateKey 读取 PEM 格式的 RSA 私钥，支持 PKCS #1（BEGIN RSA PRIVATE KEY）和 PKCS #8（BEGIN PRIVATE KEY）
+func loadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s 中没有 PEM 格式的私钥", path)
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			rsaKey, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("%s 中的私钥不是 RSA 私钥（%T），只有 RSA 密钥交换才能用私钥解密", path, key)
+			}
+			return rsaKey, nil
+		}
+	}
+}
+
+// prf12 是 TLS 1.2 的 PRF（RFC 5246 5）：P_hash(secret, label + seed)，输出 n 字节
+func prf12(h func() hash.Hash, secret []byte, label string, seed []byte, n int) []byte {
+	seed = append([]byte(label), seed...)
+	mac := hmac.New(h, secret)
+	mac.Write(seed)
+	a := mac.Sum(nil)
+
+	var out []byte
+	for len(out) < n {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(seed)
+		out = mac.Sum(out)
+
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+	return out[:n]
+}
+
+// sessionKeys 是由主密钥展开出的两个方向的密钥
+type sessionKeys struct {
+	suite decryptSuite
+	etm   bool
+	// 密钥的来源（-decrypt-key 或 -decrypt-keylog），用作输出的前缀
+	source string
+	client directionKeys
+	server directionKeys
+}
+
+type directionKeys struct {
+	macKey []byte
+	key    []byte
+	// GCM 的 4 字节隐式 nonce，CBC 套件在 TLS 1.2 中使用每条记录自带的显式 IV，这里为空
+	iv []byte
+}
+
+// explainDecryptability 在看到 ServerHello 后说明 -decrypt-key 能否解密这条连接，调用时需持有 mu
+func (s *connState) explainDecryptability(fromClient bool) {
+	name := formatCipherSuite(s.cipherSuite)
+	switch {
+	case s.version == versionTLS13:
+		s.logf(fromClient, "-decrypt-key：TLS 1.3 只使用 (EC)DHE 密钥交换，预主密钥从不经过网络传输，无法用服务器私钥解密")
+	case s.version != versionTLS12:
+		s.logf(fromClient, "-decrypt-key：只支持解密 TLS 1.2，本连接为 %s", formatVersion(s.version))
+	case strings.Contains(CIPHER_SUITE_TABLE[s.cipherSuite], "DHE_"):
+		s.logf(fromClient,
+			"-decrypt-key：密码套件 %s 使用 (EC)DHE 密钥交换，具有前向安全性：预主密钥由双方的临时密钥算出，服务器私钥只用来签名，拿到私钥也无法解密",
+			name)
+	default:
+		if _, ok := tls12DecryptSuites[s.cipherSuite]; !ok || !isRSAKeyExchange(s.cipherSuite) {
+			s.logf(fromClient, "-decrypt-key：暂不支持解密密码套件 %s，只支持 RSA 密钥交换配合 AES-CBC 或 AES-GCM 的套件", name)
+			return
+		}
+		s.logf(fromClient, "-decrypt-key：密码套件 %s 使用 RSA 密钥交换，客户端会用服务器公钥加密预主密钥，持有私钥即可解密整条连接（没有前向安全性）", name)
+		if s.resumed {
+			s.logf(fromClient, "-decrypt-key：本次为会话恢复，没有 ClientKeyExchange，代理不知道原来的主密钥，无法解密")
+		}
+	}
+}
+
+// checkDecryptKeyMatches 检查服务器的叶子证书是否与 -decrypt-key 指定的私钥配对，调用时需持有 mu
+func (s *connState) checkDecryptKeyMatches(fromClient bool, leaf []byte) {
+	cert, err := x509.ParseCertificate(leaf)
+	if err != nil {
+		return
+	}
+	if pub, ok := cert.PublicKey.(*rsa.PublicKey); !ok || !pub.Equal(&config.decryptKey.PublicKey) {
+		s.logf(fromClient, "-decrypt-key：服务器证书的公钥与指定的私钥不匹配，将无法解密预主密钥")
+	}
+}
+
+// deriveKeysRSA 用 -decrypt-key 解密 ClientKeyExchange 中的预主密钥，算出主密钥和两个方向的密钥，调用时需持有 mu
+func (s *connState) deriveKeysRSA(fromClient bool, body []byte) {
+	suite, ok := tls12DecryptSuites[s.cipherSuite]
+	if !ok || !isRSAKeyExchange(s.cipherSuite) || s.version != versionTLS12 {
+		return
+	}
+
+	// TLS 1.2 的 RSA ClientKeyExchange 是 2 字节长度前缀的 EncryptedPreMasterSecret
+	r := byteReader(body)
+	var encrypted byteReader
+	if !r.readUint16LengthPrefixed(&encrypted) || !r.empty() {
+		s.logf(fromClient, "-decrypt-key：ClientKeyExchange 格式错误")
+		return
+	}
+	premaster, err := rsa.DecryptPKCS1v15(rand.Reader, config.decryptKey, encrypted)
+	if err != nil {
+		s.logf(fromClient, "-decrypt-key：无法解密预主密钥：%v", err)
+		return
+	}
+	if len(premaster) != 48 {
+		s.logf(fromClient, "-decrypt-key：预主密钥的长度为 %d 字节，应为 48 字节", len(premaster))
+		return
+	}
+	// 预主密钥的前两个字节是 ClientHello 中的版本，用来防止版本回退攻击（RFC 5246 7.4.7.1）
+	s.logf(fromClient, "-decrypt-key：解密出预主密钥（48 字节，其中的版本为 %s）", formatVersion(binary.BigEndian.Uint16(premaster)))
+
+	var master []byte
+	if s.extendedMasterSecret {
+		// RFC 7627：主密钥由截至 ClientKeyExchange 的握手消息摘要算出，而不是双方的 random
+		h := suite.prfHash()
+		h.Write(s.transcript)
+		master = prf12(suite.prfHash, premaster, "extended master secret", h.Sum(nil), 48)
+	} else {
+		master = prf12(suite.prfHash, premaster, "master secret", append(append([]byte(nil), s.clientRandom...), s.serverRandom...), 48)
+	}
+	// 与 SSLKEYLOGFILE 的格式相同，复制到文件中即可让 Wireshark 解密同样的流量
+	s.logf(fromClient, "-decrypt-key：算出主密钥：CLIENT_RANDOM %s %s", hex.EncodeToString(s.clientRandom), hex.EncodeToString(master))
+
+	s.keys = newSessionKeys12(suite, master, s.clientRandom, s.serverRandom, s.encryptThenMAC, "-decrypt-key")
+}
+
+// newSessionKeys12 用 TLS 1.2 的主密钥展开出两个方向的记录密钥（RFC 5246 6.3）
+func newSessionKeys12(suite decryptSuite, master, clientRandom, serverRandom []byte, etm bool, source string) *sessionKeys {
+	ivLen := 0
+	if suite.gcm {
+		ivLen = 4
+	}
+	seed := append(append([]byte(nil), serverRandom...), clientRandom...)
+	block := prf12(suite.prfHash, master, "key expansion", seed, 2*suite.macLen+2*suite.keyLen+2*ivLen)
+	take := func(n int) []byte {
+		b := block[:n]
+		block = block[n:]
+		return b
+	}
+	keys := &sessionKeys{suite: suite, etm: etm, source: source}
+	keys.client.macKey = take(suite.macLen)
+	keys.server.macKey = take(suite.macLen)
+	keys.client.key = take(suite.keyLen)
+	keys.server.key = take(suite.keyLen)
+	keys.client.iv = take(ivLen)
+	keys.server.iv = take(ivLen)
+	return keys
+}
+
+// recordDecryptor 解密一个方向上的加密记录：TLS 1.2 中是 ChangeCipherSpec 之后的记录，
+// TLS 1.3 中是使用某一代流量密钥加密的记录
+type recordDecryptor struct {
+	suite  decryptSuite
+	etm    bool
+	keys   directionKeys
+	block  cipher.Block
+	aead   cipher.AEAD
+	seqNum uint64
+	// 输出的前缀，即密钥的来源
+	source string
+	// TLS 1.3 中算出这组密钥的流量密钥，KeyUpdate 时由它算出下一代；TLS 1.2 中为空
+	trafficSecret []byte
+}
+
+func newRecordDecryptor(keys *sessionKeys, fromClient bool) (*recordDecryptor, error) {
+	d := &recordDecryptor{suite: keys.suite, etm: keys.etm, keys: keys.server, source: keys.source}
+	if fromClient {
+		d.keys = keys.client
+	}
+	var err error
+	if d.block, err = aes.NewCipher(d.keys.key); err != nil {
+		return nil, err
+	}
+	if d.suite.gcm {
+		if d.aead, err = cipher.NewGCM(d.block); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// additionalData 构造 MAC 和 AEAD 使用的 seq_num + type + version + length
+func (d *recordDecryptor) additionalData(contentType byte, version uint16, length int) []byte {
+	b := binary.BigEndian.AppendUint64(nil, d.seqNum)
+	b = append(b, contentType)
+	b = appendUint16(b, version)
+	return appendUint16(b, uint16(length))
+}
+
+func (d *recordDecryptor) mac(data ...[]byte) []byte {
+	mac := hmac.New(d.suite.macHash, d.keys.macKey)
+	for _, b := range data {
+		mac.Write(b)
+	}
+	return mac.Sum(nil)
+}
+
+// decrypt 解密一条记录的载荷并校验完整性，返回真正的内容类型（只有 TLS 1.3 会与外层不同）和明文。
+// 无论成功与否，序列号都会递增
+func (d *recordDecryptor) decrypt(contentType byte, version uint16, payload []byte) (byte, []byte, error) {
+	defer func() { d.seqNum++ }()
+
+	if d.trafficSecret != nil {
+		return d.decrypt13(contentType, version, payload)
+	}
+
+	if d.suite.gcm {
+		// GCM 记录：8 字节显式 nonce + 密文 + 16 字节认证标签（RFC 5288 3）
+		if len(payload) < 8+d.aead.Overhead() {
+			return 0, nil, errors.New("记录太短")
+		}
+		nonce := append(append([]byte(nil), d.keys.iv...), payload[:8]...)
+		aad := d.additionalData(contentType, version, len(payload)-8-d.aead.Overhead())
+		plain, err := d.aead.Open(nil, nonce, payload[8:], aad)
+		return contentType, plain, err
+	}
+
+	macLen, blockSize := d.suite.macLen, d.block.BlockSize()
+	if d.etm {
+		// RFC 7366：先加密后计算 MAC，MAC 覆盖 IV 和密文，因此先校验再解密
+		if len(payload) < blockSize+macLen {
+			return 0, nil, errors.New("记录太短")
+		}
+		ciphertext, tag := payload[:len(payload)-macLen], payload[len(payload)-macLen:]
+		if !hmac.Equal(tag, d.mac(d.additionalData(contentType, version, len(ciphertext)), ciphertext)) {
+			return 0, nil, errors.New("MAC 校验失败")
+		}
+		payload = ciphertext
+	}
+
+	// CBC 记录：16 字节显式 IV + 密文，明文末尾是 MAC（非 encrypt_then_mac 时）和填充
+	if len(payload) < 2*blockSize || len(payload)%blockSize != 0 {
+		return 0, nil, fmt.Errorf("记录长度 %d 不是分组长度的整数倍", len(payload))
+	}
+	plain := make([]byte, len(payload)-blockSize)
+	cipher.NewCBCDecrypter(d.block, payload[:blockSize]).CryptBlocks(plain, payload[blockSize:])
+
+	padLen := int(plain[len(plain)-1])
+	if padLen+1 > len(plain) {
+		return 0, nil, errors.New("填充长度错误")
+	}
+	for _, b := range plain[len(plain)-padLen-1:] {
+		if int(b) != padLen {
+			return 0, nil, errors.New("填充内容错误")
+		}
+	}
+	plain = plain[:len(plain)-padLen-1]
+	if d.etm {
+		return contentType, plain, nil
+	}
+
+	if len(plain) < macLen {
+		return 0, nil, errors.New("记录太短")
+	}
+	content, tag := plain[:len(plain)-macLen], plain[len(plain)-macLen:]
+	expected := d.mac(d.additionalData(contentType, version, len(content)), content)
+	if subtle.ConstantTimeCompare(tag, expected) != 1 {
+		return 0, nil, errors.New("MAC 校验失败")
+	}
+	return contentType, content, nil
+}
+
+// maxPendingDecrypt 是 -decrypt-keylog 模式下等待密钥写入密钥日志时，每个方向最多缓存的记录数
+const maxPendingDecrypt = 64
+
+// pendingRecord 是一条因为暂时没有密钥而缓存起来的加密记录
+type pendingRecord struct {
+	contentType byte
+	version     uint16
+	payload     []byte
+}
+
+// decryptRecord 在 -decrypt-key 或 -decrypt-keylog 模式下解密该方向的一条加密记录并输出明文
+func (d *directionState) decryptRecord(contentType byte, version uint16, payload []byte) {
+	if d.decryptAbandoned {
+		return
+	}
+	if len(d.pendingDecrypt) == 0 && d.readyToDecrypt() {
+		d.decryptOne(contentType, version, payload)
+		return
+	}
+	if config.keyLog == nil || !d.conn.supportsKeyLogDecryption() {
+		return
+	}
+
+	// 代理比对端更早看到记录：例如 TLS 1.3 中服务器的加密握手消息到达代理时，客户端还没有收到 ServerHello，
+	// 自然也还没有把握手流量密钥写入密钥日志。因此先缓存记录，等密钥出现后再按顺序解密
+	d.pendingDecrypt = append(d.pendingDecrypt, pendingRecord{contentType, version, append([]byte(nil), payload...)})
+	for len(d.pendingDecrypt) > 0 && d.readyToDecrypt() {
+		r := d.pendingDecrypt[0]
+		d.pendingDecrypt = d.pendingDecrypt[1:]
+		d.decryptOne(r.contentType, r.version, r.payload)
+	}
+	if len(d.pendingDecrypt) > maxPendingDecrypt {
+		d.conn.mu.Lock()
+		d.conn.logf(d.fromClient, "-decrypt-keylog：缓存了 %d 条记录后仍未在密钥日志中找到 client random 为 %s 的密钥，放弃解密该方向的记录",
+			len(d.pendingDecrypt), hex.EncodeToString(d.conn.clientRandom))
+		d.conn.mu.Unlock()
+		d.decryptAbandoned = true
+	}
+	if len(d.pendingDecrypt) == 0 || d.decryptAbandoned {
+		d.pendingDecrypt = nil
+	}
+}
+
+// readyToDecrypt 判断该方向是否已经有可用的解密器，没有时尝试创建
+func (d *directionState) readyToDecrypt() bool {
+	return d.decryptor != nil || d.createDecryptor()
+}
+
+// decryptOne 用当前的解密器解密一条记录并输出明文
+func (d *directionState) decryptOne(contentType byte, version uint16, payload []byte) {
+	decryptor := d.decryptor
+	seqNum := decryptor.seqNum
+	innerType, plain, err := decryptor.decrypt(contentType, version, payload)
+
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+	if err != nil {
+		d.conn.logf(d.fromClient, "%s：无法解密第 %d 条加密记录：%v", decryptor.source, seqNum, err)
+		return
+	}
+	switch innerType {
+	case contentTypeHandshake:
+		d.logDecryptedHandshake(plain)
+	case contentTypeAlert:
+		if len(plain) == 2 {
+			d.conn.logf(d.fromClient, "%s：解密出警报 %s", decryptor.source, alertSpec{level: plain[0], description: plain[1]})
+			if plain[1] == alertCloseNotify {
+				d.sentCloseNotify = true
+			}
+			return
+		}
+		d.conn.logf(d.fromClient, "%s：解密出长度为 %d 的警报：%s", decryptor.source, len(plain), hex.EncodeToString(plain))
+	case contentTypeApplicationData:
+		d.conn.logf(d.fromClient, "%s：解密出 %d 字节的应用数据：%s", decryptor.source, len(plain), formatPlaintext(plain))
+	default:
+		d.conn.logf(d.fromClient, "%s：解密出 %s 记录（%d 字节）：%s", decryptor.source, lookupName(CONTENT_TYPE_TABLE, innerType), len(plain), hex.EncodeToString(plain))
+	}
+}
+
+// createDecryptor 在该方向的第一条加密记录到达时创建解密器，没有可用的密钥时返回 false。
+// 查找密钥日志时可能要读取文件，因此查找期间不持有 mu，以免阻塞另一个方向的转发
+func (d *directionState) createDecryptor() bool {
+	s := d.conn
+	s.mu.Lock()
+	label := s.keyLogLabel(d.fromClient, d.decryptedFinished)
+	clientRandom := s.clientRandom
+	s.mu.Unlock()
+
+	var secret []byte
+	if label != "" {
+		secret = config.keyLog.lookup(label, clientRandom)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.version == versionTLS13 {
+		d.decryptor = s.tls13Decryptor(d.fromClient, secret)
+		return d.decryptor != nil
+	}
+	// 另一个方向可能在查找期间已经展开了密钥
+	if s.keys == nil && secret != nil {
+		s.deriveKeysFromKeyLog(d.fromClient, secret)
+	}
+	if s.keys == nil {
+		return false
+	}
+	decryptor, err := newRecordDecryptor(s.keys, d.fromClient)
+	if err != nil {
+		s.logf(d.fromClient, "%s：%v", s.keys.source, err)
+		return false
+	}
+	d.decryptor = decryptor
+	return true
+}
+
+// logDecryptedHandshake 逐条输出解密出的握手消息，调用时需持有 mu。
+// TLS 1.3 中消息同样可以跨越多条记录；该方向的 Finished 和 KeyUpdate 之后要换用下一代流量密钥
+func (d *directionState) logDecryptedHandshake(plain []byte) {
+	source := d.decryptor.source
+	d.decryptedHandshakeBuf = append(d.decryptedHandshakeBuf, plain...)
+	for len(d.decryptedHandshakeBuf) >= 4 {
+		buf := d.decryptedHandshakeBuf
+		msgLength := 4 + (int(buf[1])<<16 | int(buf[2])<<8 | int(buf[3]))
+		if len(buf) < msgLength {
+			break
+		}
+		msgType, body := buf[0], buf[4:msgLength]
+		d.decryptedHandshakeBuf = buf[msgLength:]
+
+		switch msgType {
+		case handshakeTypeFinished:
+			d.conn.logf(d.fromClient, "%s：解密出 Finished，verify_data 为 %s", source, hex.EncodeToString(body))
+			if d.decryptor.trafficSecret != nil && !d.decryptedFinished {
+				// TLS 1.3 中该方向此后的记录使用应用流量密钥 *_TRAFFIC_SECRET_0 加密，
+				// 下一条记录到达时再由 createDecryptor 在不持有 mu 的情况下查找
+				d.decryptedFinished = true
+				d.decryptor = nil
+			}
+		case handshakeTypeKeyUpdate:
+			d.conn.logf(d.fromClient, "%s：解密出 KeyUpdate，此后该方向换用下一代流量密钥", source)
+			if d.decryptor != nil && d.decryptor.trafficSecret != nil {
+				d.decryptor = d.decryptor.nextGeneration()
+			}
+		case handshakeTypeCertificate:
+			d.conn.logf(d.fromClient, "%s：解密出握手消息 %s (%d)，长度 %d", source, lookupName(HANDSHAKE_TYPE_TABLE, msgType), msgType, len(body))
+			if d.decryptor.trafficSecret != nil && !d.fromClient {
+				d.conn.logDelegatedCredential(d.fromClient, source, body)
+			}
+		case handshakeTypeCompressedCertificate:
+			if plain := d.conn.logCompressedCertificate(d.fromClient, source, body); plain != nil && d.decryptor.trafficSecret != nil && !d.fromClient {
+				d.conn.logDelegatedCredential(d.fromClient, source, plain)
+			}
+		default:
+			d.conn.logf(d.fromClient, "%s：解密出握手消息 %s (%d)，长度 %d", source, lookupName(HANDSHAKE_TYPE_TABLE, msgType), msgType, len(body))
+		}
+		if d.decryptor == nil {
+			// 没有下一代密钥，剩下的握手数据也无法再使用
+			d.decryptedHandshakeBuf = nil
+			return
+		}
+	}
+	if len(d.decryptedHandshakeBuf) == 0 {
+		d.decryptedHandshakeBuf = nil
+	}
+}
+
+// formatPlaintext 输出解密出的应用数据：UTF-8 文本（如 HTTP）以带引号的字符串显示，其余以十六进制显示，超过 -max-record-log-bytes 的部分截断
+func formatPlaintext(plain []byte) string {
+	shown, more := truncateForLog(plain, config.maxRecordLogBytes)
+	if utf8.Valid(shown) {
+		return fmt.Sprintf("%q%s", shown, more)
+	}
+	return hex.EncodeToString(shown) + more
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"time"
+)
+
+// extDelegatedCredentials 是 delegated_credentials 扩展（RFC 9345）。委托凭据是证书持有者用证书私钥签发的一把短期密钥，
+// 服务器用它代替证书私钥完成 CertificateVerify，这样 CDN 等前端就不必持有长期的证书私钥
+const extDelegatedCredentials uint16 = 34
+
+// delegatedCredentialSchemes 返回 ClientHello 的 delegated_credentials 扩展中客户端接受的签名算法，
+// 即委托凭据可以使用的签名算法。未携带该扩展或格式错误时 ok 为 false
+func delegatedCredentialSchemes(extensions []tlsExtension) (schemes []uint16, ok bool) {
+	data, found := findExtension(extensions, extDelegatedCredentials)
+	if !found {
+		return nil, false
+	}
+	r := byteReader(data)
+	var list byteReader
+	if !r.readUint16LengthPrefixed(&list) || !r.empty() {
+		return nil, false
+	}
+	for !list.empty() {
+		var scheme uint16
+		if !list.readUint16(&scheme) {
+			return nil, false
+		}
+		schemes = append(schemes, scheme)
+	}
+	return schemes, true
+}
+
+// tls13CertificateEntry 是 TLS 1.3 Certificate 消息中的一项：每张证书之后都跟着自己的扩展列表（RFC 8446 4.4.2）
+type tls13CertificateEntry struct {
+	cert       []byte
+	extensions []tlsExtension
+}
+
+// parseTLS13Certificate 解析 TLS 1.3 的 Certificate 消息体。只有解密之后才能看到这条消息
+func parseTLS13Certificate(body []byte) ([]tls13CertificateEntry, error) {
+	r := byteReader(body)
+	var context, list byteReader
+	if !r.readUint8LengthPrefixed(&context) || !r.readUint24LengthPrefixed(&list) || !r.empty() {
+		return nil, errors.New("Certificate 消息长度不正确")
+	}
+
+	var entries []tls13CertificateEntry
+	for !list.empty() {
+		var cert, extBlock byteReader
+		if !list.readUint24LengthPrefixed(&cert) || !list.readUint16LengthPrefixed(&extBlock) {
+			return nil, errors.New("证书项格式错误")
+		}
+		entry := tls13CertificateEntry{cert: cert}
+		for !extBlock.empty() {
+			var ext tlsExtension
+			var data byteReader
+			if !extBlock.readUint16(&ext.extType) || !extBlock.readUint16LengthPrefixed(&data) {
+				return nil, errors.New("证书项的扩展格式错误")
+			}
+			ext.data = data
+			entry.extensions = append(entry.extensions, ext)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// delegatedCredential 是叶子证书项中 delegated_credentials 扩展的内容（RFC 9345 4）
+type delegatedCredential struct {
+	// 有效期：从叶子证书的 notBefore 起算的秒数
+	validTime uint32
+	// 服务器用委托凭据的私钥签 CertificateVerify 时使用的签名算法
+	dcCertVerifyAlgorithm uint16
+	publicKeyLen          int
+	// 证书私钥签发这个委托凭据时使用的签名算法
+	algorithm    uint16
+	signatureLen int
+}
+
+func parseDelegatedCredential(data []byte) (*delegatedCredential, error) {
+	r := byteReader(data)
+	var dc delegatedCredential
+	var publicKey, signature byteReader
+	if !r.readUint32(&dc.validTime) || !r.readUint16(&dc.dcCertVerifyAlgorithm) || !r.readUint24LengthPrefixed(&publicKey) ||
+		!r.readUint16(&dc.algorithm) || !r.readUint16LengthPrefixed(&signature) || !r.empty() {
+		return nil, errors.New("委托凭据格式错误")
+	}
+	dc.publicKeyLen, dc.signatureLen = len(publicKey), len(signature)
+	return &dc, nil
+}
+
+// logDelegatedCredential 检查解密出的 TLS 1.3 服务器 Certificate 中叶子证书是否附带委托凭据并输出，调用时需持有 mu
+func (s *connState) logDelegatedCredential(fromClient bool, source string, body []byte) {
+	entries, err := parseTLS13Certificate(body)
+	if err != nil || len(entries) == 0 {
+		return
+	}
+	data, ok := findExtension(entries[0].extensions, extDelegatedCredentials)
+	if !ok {
+		if s.clientOfferedDC {
+			s.logf(fromClient, "%s：客户端接受委托凭据，但服务器没有使用，而是直接用证书私钥签名 CertificateVerify", source)
+		}
+		return
+	}
+
+	dc, err := parseDelegatedCredential(data)
+	if err != nil {
+		s.warnf(fromClient, "%s：服务器的委托凭据无法解析：%v", source, err)
+		return
+	}
+	validity := time.Duration(dc.validTime) * time.Second
+	until := ""
+	if cert, err := x509.ParseCertificate(entries[0].cert); err == nil {
+		until = "，到 " + cert.NotBefore.Add(validity).UTC().Format(time.RFC3339) + " 为止"
+	}
+	s.logf(fromClient, "%s：服务器使用了委托凭据（delegated_credentials）：有效期为证书生效后 %s%s，CertificateVerify 的签名算法为 %s，公钥 %d 字节，由证书私钥以 %s 签发",
+		source, validity, until, formatSignatureScheme(dc.dcCertVerifyAlgorithm), dc.publicKeyLen, formatSignatureScheme(dc.algorithm))
+	// RFC 9345 4.1.3：有效期不能超过 7 天
+	if validity > 7*24*time.Hour {
+		s.protocolViolation(fromClient, alertIllegalParameter, "委托凭据的有效期 %s 超过了 7 天", validity)
+	}
+	if !s.clientOfferedDC {
+		s.protocolViolation(fromClient, alertUnexpectedMessage, "客户端没有发送 delegated_credentials 扩展，服务器却使用了委托凭据")
+	}
+}
+
+// describeDelegatedCredentialSchemes 返回 delegated_credentials 扩展中签名算法列表的描述
+func describeDelegatedCredentialSchemes(schemes []uint16) string {
+	var names []string
+	for _, scheme := range schemes {
+		names = append(names, formatSignatureScheme(scheme))
+	}
+	return strings.Join(names, "、")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// connHandlers 统计尚未结束的 handleNewIncomingConn，包括仍在连接后端、还没有登记到 activeConns 的连接
+var connHandlers sync.WaitGroup
+
+// stopListeningOnSignal 在收到 SIGINT 或 SIGTERM 时关闭监听套接字，让接受连接的循环结束并开始排空连接。
+// 排空期间再收到一次信号时，立即强制关闭所有连接
+func stopListeningOnSignal(listener net.Listener, drainTimeout time.Duration) <-chan struct{} {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	force := make(chan struct{})
+	go func() {
+		sig := <-signals
+		logPrintf("收到信号 %s，停止接受新连接，最多等待 %s 让现有连接结束（再次发送信号可立即关闭）\n", sig, drainTimeout)
+		_ = listener.Close()
+		sig = <-signals
+		logPrintf("再次收到信号 %s，不再等待\n", sig)
+		close(force)
+	}()
+	return force
+}
+
+// drainConnections 等待所有连接结束，最长等待 timeout，或者直到 force 被关闭。
+// 到期后强制关闭仍然活跃的连接，并等它们输出连接摘要
+func drainConnections(timeout time.Duration, force <-chan struct{}) {
+	done := make(chan struct{})
+	go func() {
+		connHandlers.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		logPrintf("所有连接均已结束，退出\n")
+		return
+	case <-timer.C:
+	case <-force:
+	}
+
+	activeConns.mu.Lock()
+	conns := make([]*connState, 0, len(activeConns.conns))
+	for _, s := range activeConns.conns {
+		conns = append(conns, s)
+	}
+	activeConns.mu.Unlock()
+
+	logPrintf("排空结束时仍有 %d 条连接活跃，强制关闭\n", len(conns))
+	for _, s := range conns {
+		s.closeBoth()
+	}
+	// 关闭套接字后转发 goroutine 很快就会结束，但连接后端的拨号可能还要等到超时，因此不无限等待
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
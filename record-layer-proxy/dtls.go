@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DTLS 记录层头部：内容类型 (1)、版本 (2)、epoch (2)、序号 (6)、长度 (2)
+	dtlsRecordHeaderLength = 13
+	// DTLS 握手消息头部在 TLS 的 4 字节之后又多了 message_seq (2)、fragment_offset (3)、fragment_length (3)
+	dtlsHandshakeHeaderLength = 12
+	// UDP 会话在这么长时间内双方都没有数据时被清理
+	udpSessionTimeout = 2 * time.Minute
+)
+
+var DTLS_VERSION_TABLE = map[uint16]string{
+	0xFEFF: "DTLS 1.0",
+	0xFEFD: "DTLS 1.2",
+	0xFEFC: "DTLS 1.3",
+}
+
+const (
+	// 重组单条 DTLS 握手消息时允许的最大长度，超过后不再缓存，只输出分片信息
+	maxDTLSReassemblyLength = 1 << 20
+	// 一个方向上同时在重组的消息的总长度上限。消息长度由对端声明，没有这个上限时，
+	// 对端为许多不同的 message_seq 各发一个声明了很大长度的分片，就能让代理分配大量内存
+	maxDTLSInFlightBytes = 1 << 20
+	// 只保留 message_seq 不早于目前见过的最大值减去这个数的消息，更早的消息属于已经过去的 flight，
+	// 它们的重传不再识别，残缺的也不再等待
+	dtlsReassemblyWindow = 16
+)
+
+// byteRange 是消息中已收到的一段字节 [start, end)
+type byteRange struct {
+	start, end int
+}
+
+// dtlsMessage 是正在按 message_seq 重组的一条 DTLS 握手消息
+type dtlsMessage struct {
+	msgType byte
+	length  int
+	data    []byte
+	// 已收到的区间，按 start 排序且互不重叠、互不相邻
+	received  []byteRange
+	remaining int
+	fragments int
+	complete  bool
+}
+
+// addRange 把 fragment 放入 [offset, offset+len(fragment)) 中此前没有收到的部分，返回新收到的字节数
+func (m *dtlsMessage) addRange(offset int, fragment []byte) int {
+	start, end := offset, offset+len(fragment)
+	if start == end {
+		return 0
+	}
+
+	merged := make([]byteRange, 0, len(m.received)+1)
+	newRange := byteRange{start, end}
+	// cursor 之前的部分已经处理过，cursor 与下一个已收到的区间之间就是新收到的字节
+	cursor, newBytes := start, 0
+	fill := func(to int) {
+		if to > cursor {
+			copy(m.data[cursor:to], fragment[cursor-offset:to-offset])
+			newBytes += to - cursor
+		}
+	}
+	for _, r := range m.received {
+		if r.end < start || r.start > end {
+			merged = append(merged, r)
+			continue
+		}
+		// 与新分片重叠或相邻的区间并入 newRange
+		if r.start < end {
+			fill(r.start)
+		}
+		if r.end > cursor {
+			cursor = r.end
+		}
+		if r.start < newRange.start {
+			newRange.start = r.start
+		}
+		if r.end > newRange.end {
+			newRange.end = r.end
+		}
+	}
+	fill(end)
+
+	i := 0
+	for i < len(merged) && merged[i].start < newRange.start {
+		i++
+	}
+	merged = append(merged[:i], append([]byteRange{newRange}, merged[i:]...)...)
+	m.received = merged
+	m.remaining -= newBytes
+	return newBytes
+}
+
+// dtlsFlow 保存一个方向上 DTLS 握手消息的重组状态
+type dtlsFlow struct {
+	from, to net.Addr
+	messages map[uint16]*dtlsMessage
+	// 目前见过的最大 message_seq，以及尚未重组完成的消息占用的总字节数
+	maxSeq   uint16
+	hasSeq   bool
+	inFlight int
+	// 是否已经报告过达到 maxDTLSInFlightBytes，每个方向只报告一次
+	warnedInFlight bool
+}
+
+func newDTLSFlow(from, to net.Addr) *dtlsFlow {
+	return &dtlsFlow{from: from, to: to, messages: make(map[uint16]*dtlsMessage)}
+}
+
+// advanceWindow 在见到 messageSeq 时前移重组窗口，丢弃窗口之前的消息。messageSeq 本身早于窗口时返回 false
+func (f *dtlsFlow) advanceWindow(messageSeq uint16) bool {
+	if f.hasSeq && int(messageSeq)+dtlsReassemblyWindow <= int(f.maxSeq) {
+		return false
+	}
+	if f.hasSeq && messageSeq <= f.maxSeq {
+		return true
+	}
+	f.maxSeq, f.hasSeq = messageSeq, true
+	for seq, msg := range f.messages {
+		if int(seq)+dtlsReassemblyWindow <= int(f.maxSeq) {
+			if !msg.complete {
+				f.inFlight -= msg.length
+			}
+			delete(f.messages, seq)
+		}
+	}
+	return true
+}
+
+// udpSession 表示一个客户端地址与后端之间的 UDP “连接”
+type udpSession struct {
+	clientAddr *net.UDPAddr
+	backend    *net.UDPConn
+	// 客户端→后端、后端→客户端两个方向的 DTLS 解析状态，分别只由一个 goroutine 访问
+	clientFlow  *dtlsFlow
+	backendFlow *dtlsFlow
+
+	mu         sync.Mutex
+	lastActive time.Time
+}
+
+func (s *udpSession) touch() {
+	s.mu.Lock()
+	s.lastActive = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *udpSession) idleFor() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.lastActive)
+}
+
+// runUDPProxy 在 localAddr 上监听 UDP，把每个客户端地址的数据报转发到 remoteAddr，并解析其中的 DTLS 记录
+func runUDPProxy(localAddr, remoteAddr string) {
+	udpLocalAddr, err := net.ResolveUDPAddr("udp", localAddr)
+	panicIfErr(err, "runUDPProxy")
+	udpRemoteAddr, err := net.ResolveUDPAddr("udp", remoteAddr)
+	panicIfErr(err, "runUDPProxy")
+
+	listener, err := net.ListenUDP("udp", udpLocalAddr)
+	panicIfErr(err, "runUDPProxy")
+
+	logPrintf("正在监听 UDP %s……\n", udpLocalAddr)
+
+	var mu sync.Mutex
+	sessions := make(map[string]*udpSession)
+	buf := make([]byte, 65535)
+
+	// 与 TCP 的接受循环相同：除了监听套接字已关闭之外，读取错误（如 ENOBUFS）都只是暂时的，
+	// 记录日志并等待后重试，等待时间从 5ms 开始每次翻倍，最长 1 秒，成功读到数据报后重置
+	var tempDelay time.Duration
+	for {
+		n, clientAddr, err := listener.ReadFromUDP(buf)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if tempDelay > time.Second {
+				tempDelay = time.Second
+			}
+			logPrintf("[runUDPProxy] 读取数据报时出错：%v，%s 后重试\n", err, tempDelay)
+			time.Sleep(tempDelay)
+			continue
+		}
+		panicIfErr(err, "runUDPProxy")
+		tempDelay = 0
+
+		mu.Lock()
+		session, hasSession := sessions[clientAddr.String()]
+		if !hasSession {
+			backend, err := net.DialUDP("udp", nil, udpRemoteAddr)
+			if err != nil {
+				mu.Unlock()
+				logPrintf("[runUDPProxy %s] 无法连接后端 %s：%s\n", clientAddr, udpRemoteAddr, describeDialError(err))
+				continue
+			}
+
+			session = &udpSession{
+				clientAddr:  clientAddr,
+				backend:     backend,
+				clientFlow:  newDTLSFlow(clientAddr, udpRemoteAddr),
+				backendFlow: newDTLSFlow(udpRemoteAddr, clientAddr),
+				lastActive:  time.Now(),
+			}
+			sessions[clientAddr.String()] = session
+			go func() {
+				copyDatagramsFromBackend(listener, session)
+
+				mu.Lock()
+				delete(sessions, session.clientAddr.String())
+				mu.Unlock()
+			}()
+		}
+		mu.Unlock()
+
+		datagram := buf[:n]
+		session.clientFlow.logRecords(datagram)
+
+		session.touch()
+		_, _ = session.backend.Write(datagram)
+	}
+}
+
+// copyDatagramsFromBackend 把后端发回的数据报转发给客户端，会话空闲超时后关闭
+func copyDatagramsFromBackend(listener *net.UDPConn, session *udpSession) {
+	buf := make([]byte, 65535)
+
+	for {
+		_ = session.backend.SetReadDeadline(time.Now().Add(udpSessionTimeout))
+		n, err := session.backend.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && session.idleFor() < udpSessionTimeout {
+				continue
+			}
+			break
+		}
+
+		datagram := buf[:n]
+		session.backendFlow.logRecords(datagram)
+
+		session.touch()
+		_, _ = listener.WriteToUDP(datagram, session.clientAddr)
+	}
+
+	_ = session.backend.Close()
+	logPrintf("[dtls %s --> %s] 会话已结束\n", session.clientAddr, session.backend.RemoteAddr())
+}
+
+// logRecords 解析一个数据报中的所有 DTLS 记录并逐条输出，一个数据报里可以有多条记录
+func (f *dtlsFlow) logRecords(datagram []byte) {
+	from, to := f.from, f.to
+
+	for len(datagram) > 0 {
+		if datagram[0]&0xE0 == 0x20 {
+			// DTLS 1.3 的加密记录使用统一头部（RFC 9147 4），第一个字节的高 3 位固定为 001。
+			// 统一头部中没有内容类型，与 TLS 1.3 的外层类型一样按 application_data 对待 -only
+			if !shouldLogContentType(contentTypeApplicationData) {
+				return
+			}
+			logDetailf(
+				"[dtls %s --> %s] 转发了 DTLS 1.3 加密记录（统一头部，标志位 0x%02X），剩余 %d 字节\n",
+				from,
+				to,
+				datagram[0],
+				len(datagram),
+			)
+			return
+		}
+
+		if len(datagram) < dtlsRecordHeaderLength {
+			logDetailf("[dtls %s --> %s] 数据报末尾有 %d 字节无法构成 DTLS 记录头部\n", from, to, len(datagram))
+			return
+		}
+
+		contentType := datagram[0]
+		version := binary.BigEndian.Uint16(datagram[1:3])
+		epoch := binary.BigEndian.Uint16(datagram[3:5])
+		sequence := uint64(datagram[5])<<40 | uint64(datagram[6])<<32 | uint64(binary.BigEndian.Uint32(datagram[7:11]))
+		length := int(binary.BigEndian.Uint16(datagram[11:13]))
+
+		if len(datagram) < dtlsRecordHeaderLength+length {
+			logDetailf(
+				"[dtls %s --> %s] DTLS 记录长度 %d 超出了数据报的剩余部分（%d 字节）\n",
+				from,
+				to,
+				length,
+				len(datagram)-dtlsRecordHeaderLength,
+			)
+			return
+		}
+		payload := datagram[dtlsRecordHeaderLength : dtlsRecordHeaderLength+length]
+		datagram = datagram[dtlsRecordHeaderLength+length:]
+
+		extraInfo := ""
+		var completed []string
+		if contentType == contentTypeHandshake && epoch == 0 {
+			// epoch 为 0 的握手记录是明文，一条记录里可能有多个握手消息分片
+			extraInfo, completed = f.observeHandshakeFragments(payload)
+		} else if contentType == contentTypeAlert && epoch == 0 && len(payload) == 2 {
+			extraInfo = fmt.Sprintf("，警报：%s", alertSpec{level: payload[0], description: payload[1]})
+		}
+
+		if !shouldLogContentType(contentType) {
+			continue
+		}
+		logDetailf(
+			"[dtls %s --> %s] 转发了 DTLS 记录，内容类型：%s (%d)，版本：%s (0x%04X)，epoch：%d，序号：%d，长度：%d%s\n",
+			from,
+			to,
+			lookupName(CONTENT_TYPE_TABLE, contentType),
+			contentType,
+			lookupName(DTLS_VERSION_TABLE, version),
+			version,
+			epoch,
+			sequence,
+			length,
+			extraInfo,
+		)
+		for _, info := range completed {
+			logDetailf("[dtls %s --> %s] %s\n", from, to, info)
+		}
+	}
+}
+
+// observeHandshakeFragments 解析一条明文握手记录中的所有分片并按 message_seq 重组，
+// 返回该记录的描述，以及因这条记录而重组完成的消息的描述
+func (f *dtlsFlow) observeHandshakeFragments(payload []byte) (string, []string) {
+	extraInfo := ""
+	var completed []string
+
+	for len(payload) > 0 {
+		if len(payload) < dtlsHandshakeHeaderLength {
+			extraInfo += fmt.Sprintf("，末尾 %d 字节无法构成握手消息头部", len(payload))
+			break
+		}
+
+		msgType := payload[0]
+		msgLength := int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+		messageSeq := binary.BigEndian.Uint16(payload[4:6])
+		fragmentOffset := int(payload[6])<<16 | int(payload[7])<<8 | int(payload[8])
+		fragmentLength := int(payload[9])<<16 | int(payload[10])<<8 | int(payload[11])
+
+		if len(payload) < dtlsHandshakeHeaderLength+fragmentLength {
+			extraInfo += fmt.Sprintf("，分片长度 %d 超出了记录的剩余部分", fragmentLength)
+			break
+		}
+		fragment := payload[dtlsHandshakeHeaderLength : dtlsHandshakeHeaderLength+fragmentLength]
+		payload = payload[dtlsHandshakeHeaderLength+fragmentLength:]
+
+		extraInfo += fmt.Sprintf(
+			"，握手类型：%s (%d)，握手长度：%d，message_seq：%d，分片：[%d, %d)",
+			lookupName(HANDSHAKE_TYPE_TABLE, msgType),
+			msgType,
+			msgLength,
+			messageSeq,
+			fragmentOffset,
+			fragmentOffset+fragmentLength,
+		)
+
+		note, done := f.addFragment(msgType, msgLength, messageSeq, fragmentOffset, fragment)
+		extraInfo += note
+		if done != "" {
+			completed = append(completed, done)
+		}
+	}
+	return extraInfo, completed
+}
+
+// addFragment 把一个分片放入对应 message_seq 的消息中。返回附加在记录描述后的说明（如重传），
+// 若这个分片使消息重组完成，第二个返回值为完成时的描述
+func (f *dtlsFlow) addFragment(msgType byte, msgLength int, messageSeq uint16, offset int, fragment []byte) (string, string) {
+	if offset+len(fragment) > msgLength {
+		return "（分片超出了消息长度）", ""
+	}
+
+	if !f.advanceWindow(messageSeq) {
+		return "（message_seq 早于当前的握手窗口，不做重组）", ""
+	}
+
+	msg, hasMsg := f.messages[messageSeq]
+	if !hasMsg {
+		if msgLength > maxDTLSReassemblyLength {
+			return "（消息过长，不做重组）", ""
+		}
+		if f.inFlight+msgLength > maxDTLSInFlightBytes {
+			if !f.warnedInFlight {
+				f.warnedInFlight = true
+				logPrintf(
+					"[dtls %s --> %s] 正在重组的握手消息已占用 %d 字节，加上 message_seq %d 声明的 %d 字节将超过上限 %d 字节，在已有消息完成或过期前不再重组新的消息\n",
+					f.from,
+					f.to,
+					f.inFlight,
+					messageSeq,
+					msgLength,
+					maxDTLSInFlightBytes,
+				)
+			}
+			return "（正在重组的消息总长度已达上限，不做重组）", ""
+		}
+		msg = &dtlsMessage{
+			msgType:   msgType,
+			length:    msgLength,
+			data:      make([]byte, msgLength),
+			remaining: msgLength,
+		}
+		f.messages[messageSeq] = msg
+		f.inFlight += msgLength
+	}
+
+	if msg.msgType != msgType {
+		return "（与此前同一 message_seq 的分片类型不一致）", ""
+	}
+	if msg.complete {
+		return "（重传：该消息此前已完整收到）", ""
+	}
+	if msg.length != msgLength {
+		return "（与此前同一 message_seq 的分片长度不一致）", ""
+	}
+
+	newBytes := msg.addRange(offset, fragment)
+	msg.fragments++
+
+	note := ""
+	if newBytes == 0 && len(fragment) > 0 {
+		note = "（重传：该分片此前已收到）"
+	}
+
+	if msg.remaining > 0 {
+		return note, ""
+	}
+
+	msg.complete = true
+	// 完整收到后只保留状态用来识别重传，不再保留数据
+	msg.data, msg.received = nil, nil
+	f.inFlight -= msg.length
+	if msg.fragments == 1 {
+		return note, ""
+	}
+	return note, fmt.Sprintf(
+		"message_seq %d 的 %s (%d) 已重组完成：共 %d 字节，分 %d 个分片到达",
+		messageSeq,
+		lookupName(HANDSHAKE_TYPE_TABLE, msgType),
+		msgType,
+		msgLength,
+		msg.fragments,
+	)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDTLSMessageAddRange(t *testing.T) {
+	msg := &dtlsMessage{length: 10, data: make([]byte, 10), remaining: 10}
+	full := []byte("0123456789")
+
+	steps := []struct {
+		start, end int
+		newBytes   int
+		received   []byteRange
+	}{
+		{4, 6, 2, []byteRange{{4, 6}}},
+		{0, 2, 2, []byteRange{{0, 2}, {4, 6}}},
+		// 重传
+		{4, 6, 0, []byteRange{{0, 2}, {4, 6}}},
+		// 与前一段相邻
+		{6, 7, 1, []byteRange{{0, 2}, {4, 7}}},
+		// 跨越两段，只有中间的空隙是新的
+		{1, 8, 3, []byteRange{{0, 8}}},
+		{8, 10, 2, []byteRange{{0, 10}}},
+	}
+	for _, step := range steps {
+		if got := msg.addRange(step.start, full[step.start:step.end]); got != step.newBytes {
+			t.Errorf("addRange(%d, %d) = %d，期望 %d", step.start, step.end, got, step.newBytes)
+		}
+		if !reflect.DeepEqual(msg.received, step.received) {
+			t.Errorf("addRange(%d, %d) 之后已收到的区间为 %v，期望 %v", step.start, step.end, msg.received, step.received)
+		}
+	}
+	if msg.remaining != 0 || !bytes.Equal(msg.data, full) {
+		t.Errorf("重组结果为 %q，剩余 %d 字节", msg.data, msg.remaining)
+	}
+}
+
+func TestDTLSFlowReassemblyLimits(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	var out bytes.Buffer
+	old := setLogOutput(&out)
+	t.Cleanup(func() { setLogger(old) })
+
+	t.Run("总长度上限", func(t *testing.T) {
+		f := newDTLSFlow(addr, addr)
+		// 每条消息只发第一个字节，永远不会完成
+		for seq := uint16(0); seq < 4; seq++ {
+			f.addFragment(handshakeTypeCertificate, maxDTLSInFlightBytes/4, seq, 0, []byte{0})
+		}
+		if f.inFlight != maxDTLSInFlightBytes {
+			t.Fatalf("inFlight = %d，期望 %d", f.inFlight, maxDTLSInFlightBytes)
+		}
+		note, _ := f.addFragment(handshakeTypeCertificate, 1, 4, 0, []byte{0})
+		if note == "" || len(f.messages) != 4 || !bytes.Contains(out.Bytes(), []byte("超过上限")) {
+			t.Fatalf("超过上限时应拒绝重组并报告，note = %q，消息数 %d，日志：%s", note, len(f.messages), out.String())
+		}
+	})
+
+	t.Run("过期的消息", func(t *testing.T) {
+		f := newDTLSFlow(addr, addr)
+		f.addFragment(handshakeTypeCertificate, 100, 0, 0, []byte{0})
+		f.addFragment(handshakeTypeClientHello, 1, dtlsReassemblyWindow, 0, []byte{0})
+		if _, ok := f.messages[0]; ok || f.inFlight != 0 {
+			t.Fatalf("窗口之前的消息应被丢弃，inFlight = %d", f.inFlight)
+		}
+		if note, _ := f.addFragment(handshakeTypeCertificate, 100, 0, 1, []byte{0}); note == "" {
+			t.Fatal("早于窗口的分片应不做重组")
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"time"
+)
+
+// runEchoServer 在 addr 上运行一个最简单的 crypto/tls 回显服务器，证书是启动时在内存中生成的自签名证书。
+// 这样无需另外准备服务器，就可以让代理的 -r 指向它，再用客户端连接代理来观察记录层
+func runEchoServer(addr string) {
+	cert, _, err := localhostCertificate(24 * time.Hour)
+	panicIfErr(err, "runEchoServer")
+
+	listener, err := tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+	panicIfErr(err, "runEchoServer")
+	defer listener.Close()
+
+	fingerprint := sha256.Sum256(cert.Leaf.Raw)
+	logPrintf("回显服务器正在监听 %s，自签名证书（localhost，24 小时内有效）的 SHA-256 指纹为 %s\n", listener.Addr(), hex.EncodeToString(fingerprint[:]))
+	logPrintf("用法：另开终端运行代理 -l 127.0.0.1:8443 -r %s，再执行 openssl s_client -connect 127.0.0.1:8443\n", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		panicIfErr(err, "runEchoServer")
+		go echoTLSConn(conn.(*tls.Conn))
+	}
+}
+
+// echoTLSConn 完成握手后原样回显客户端发来的数据
+func echoTLSConn(conn *tls.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr()
+	if err := conn.Handshake(); err != nil {
+		logPrintf("[%s] 握手失败：%v\n", remote, err)
+		return
+	}
+	state := conn.ConnectionState()
+	logPrintf("[%s] 握手完成，版本 %s，密码套件 %s\n", remote, formatVersion(state.Version), formatCipherSuite(state.CipherSuite))
+
+	n, err := io.Copy(conn, conn)
+	logPrintf("[%s] 连接结束，%s，共回显 %d 字节\n", remote, describeConnError(err), n)
+}
+
+// localhostCertificate 生成一张 localhost 的自签名 ECDSA 证书，有效期为 validFor，同时返回只包含它的根证书池
+func localhostCertificate(validFor time.Duration) (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// followDialAttempts 是 -follow 模式下后端断开后最多尝试重新连接的次数，followDialInterval 是两次尝试之间的间隔
+	followDialAttempts = 3
+	followDialInterval = time.Second
+	// followMaxReconnects 是一条连接最多更换多少次后端，避免后端每次都立即断开时无休止地重连
+	followMaxReconnects = 10
+)
+
+// followForward 用于 -follow 模式（只支持 -raw）：后端断开而客户端仍未关闭时，重新连接 remoteAddr，
+// 把客户端此后发送的数据转发给新的后端。这只是尽力而为：TLS 的密钥、序列号和握手状态都只存在于原来的后端，
+// 新的后端收到的是另一个会话中间的加密记录，通常会回应警报或直接断开，只有客户端自己重新握手才能恢复。
+// 因此这个模式适合观察长时间运行的实验中后端重启的情形，而不能让 TLS 会话无缝切换到另一个后端。
+// state.serverConn 始终是最初的后端连接，用于日志和连接摘要，之后重新连接的后端由这里自行关闭
+func followForward(state *connState, remoteAddr string) {
+	client := state.clientConn
+	defer state.closeBoth()
+
+	// 客户端方向在单独的 goroutine 中读取，这样更换后端时不必中断对客户端的读取
+	clientData := make(chan []byte)
+	go func() {
+		defer close(clientData)
+		buf := make([]byte, maxCiphertextLength)
+		for {
+			n, err := client.Read(buf)
+			if n > 0 {
+				clientData <- append([]byte(nil), buf[:n]...)
+			}
+			if err != nil {
+				logDetailf("[follow %s] 客户端方向结束：%s\n", client.RemoteAddr(), describeConnError(err))
+				return
+			}
+		}
+	}()
+
+	backend := state.serverConn
+	for reconnects := 0; ; reconnects++ {
+		backendDone := make(chan error, 1)
+		go func(backend *net.TCPConn) {
+			n, err := rawCopy(backend, client)
+			statBytes.Add(n)
+			state.addBytes(false, n)
+			backendDone <- err
+		}(backend)
+
+	waiting:
+		for {
+			select {
+			case data, ok := <-clientData:
+				if !ok {
+					// 客户端已经关闭写方向，把 FIN 传给后端，等待后端发完剩余的数据
+					_ = backend.CloseWrite()
+					<-backendDone
+					_ = client.CloseWrite()
+					if backend != state.serverConn {
+						_ = backend.Close()
+					}
+					return
+				}
+				if err := writeFull(backend, data); err != nil {
+					logDetailf("[follow %s] 写入后端 %s 失败：%s\n", client.RemoteAddr(), backend.RemoteAddr(), describeConnError(err))
+					_ = backend.Close()
+					continue
+				}
+				statBytes.Add(int64(len(data)))
+				state.addBytes(true, int64(len(data)))
+
+			case err := <-backendDone:
+				logPrintf("[follow %s] 后端 %s 断开：%s，客户端仍未关闭\n", client.RemoteAddr(), backend.RemoteAddr(), describeConnError(err))
+				if backend != state.serverConn {
+					_ = backend.Close()
+				}
+				if reconnects >= followMaxReconnects {
+					logPrintf("[follow %s] 已经更换了 %d 次后端，不再重新连接，关闭客户端连接\n", client.RemoteAddr(), reconnects)
+					return
+				}
+				backend = followRedial(state, remoteAddr)
+				if backend == nil {
+					return
+				}
+				state.addWarning(false, "连接中途断开，-follow 模式下已重新连接后端")
+				break waiting
+			}
+		}
+	}
+}
+
+// followRedial 重新连接 remoteAddr，最多尝试 followDialAttempts 次，全部失败时返回 nil
+func followRedial(state *connState, remoteAddr string) *net.TCPConn {
+	for attempt := 1; attempt <= followDialAttempts; attempt++ {
+		conn, err := backendDialer.Dial("tcp", remoteAddr)
+		if config.backends != nil {
+			config.backends.reportDial(remoteAddr, err)
+		}
+		if err == nil {
+			backend := conn.(*net.TCPConn)
+			logPrintf("[follow %s] 已重新连接后端 %s（新的本地端口 %s）。注意：TLS 会话的状态只存在于原来的后端，"+
+				"新的后端收到的是另一个会话中间的加密记录，无法解密，通常会回应警报或断开，客户端需要重新握手\n",
+				state.clientConn.RemoteAddr(), backend.RemoteAddr(), backend.LocalAddr())
+			return backend
+		}
+		logPrintf("[follow %s] 第 %d/%d 次重新连接后端 %s 失败：%s\n", state.clientConn.RemoteAddr(), attempt, followDialAttempts, remoteAddr, describeDialError(err))
+		if attempt < followDialAttempts {
+			time.Sleep(followDialInterval)
+		}
+	}
+	logPrintf("[follow %s] 无法重新连接后端，关闭客户端连接\n", state.clientConn.RemoteAddr())
+	return nil
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 内容类型
+const (
+	contentTypeChangeCipherSpec byte = 20
+	contentTypeAlert            byte = 21
+	contentTypeHandshake        byte = 22
+	contentTypeApplicationData  byte = 23
+	contentTypeHeartbeat        byte = 24
+)
+
+// 握手消息类型
+const (
+	handshakeTypeClientHello           byte = 1
+	handshakeTypeServerHello           byte = 2
+	handshakeTypeNewSessionTicket      byte = 4
+	handshakeTypeEndOfEarlyData        byte = 5
+	handshakeTypeCertificate           byte = 11
+	handshakeTypeServerKeyExchange     byte = 12
+	handshakeTypeCertificateRequest    byte = 13
+	handshakeTypeServerHelloDone       byte = 14
+	handshakeTypeCertificateVerify     byte = 15
+	handshakeTypeClientKeyExchange     byte = 16
+	handshakeTypeFinished              byte = 20
+	handshakeTypeCertificateStatus     byte = 22
+	handshakeTypeKeyUpdate             byte = 24
+	handshakeTypeCompressedCertificate byte = 25
+)
+
+// 扩展类型
+const (
+	extServerName        uint16 = 0
+	extSupportedVersions uint16 = 43
+	extRecordSizeLimit   uint16 = 28
+	extPadding           uint16 = 21
+	extALPN              uint16 = 16
+	// ALPS（application_settings）不是标准扩展，只有 Chrome 等基于 BoringSSL 的实现支持。
+	// Chrome 后来改用了新的代码 17613，两者的格式相同
+	extApplicationSettings    uint16 = 17513
+	extApplicationSettingsNew uint16 = 17613
+	extPreSharedKey           uint16 = 41
+	extEarlyData              uint16 = 42
+)
+
+const (
+	versionSSL30 uint16 = 0x0300
+	versionTLS10 uint16 = 0x0301
+	versionTLS11 uint16 = 0x0302
+	versionTLS12 uint16 = 0x0303
+	versionTLS13 uint16 = 0x0304
+)
+
+type tlsExtension struct {
+	extType uint16
+	data    []byte
+}
+
+// parseExtensions 解析 Hello 消息末尾的扩展列表。
+// 扩展列表是可选的，若 r 已经读完则返回空列表
+func parseExtensions(r *byteReader) ([]tlsExtension, error) {
+	if r.empty() {
+		return nil, nil
+	}
+
+	var extensionsBlock byteReader
+	if !r.readUint16LengthPrefixed(&extensionsBlock) || !r.empty() {
+		return nil, errors.New("扩展列表长度不正确")
+	}
+
+	var extensions []tlsExtension
+	for !extensionsBlock.empty() {
+		var ext tlsExtension
+		var data byteReader
+		if !extensionsBlock.readUint16(&ext.extType) || !extensionsBlock.readUint16LengthPrefixed(&data) {
+			return nil, errors.New("扩展格式错误")
+		}
+		ext.data = data
+		extensions = append(extensions, ext)
+	}
+	return extensions, nil
+}
+
+func findExtension(extensions []tlsExtension, extType uint16) ([]byte, bool) {
+	for _, ext := range extensions {
+		if ext.extType == extType {
+			return ext.data, true
+		}
+	}
+	return nil, false
+}
+
+// recordSizeLimit 返回 record_size_limit 扩展（RFC 8449）的值，未携带该扩展时返回 0
+func recordSizeLimit(extensions []tlsExtension) uint16 {
+	data, ok := findExtension(extensions, extRecordSizeLimit)
+	if !ok {
+		return 0
+	}
+
+	r := byteReader(data)
+	var limit uint16
+	if !r.readUint16(&limit) {
+		return 0
+	}
+	return limit
+}
+
+// padding 返回 padding 扩展（RFC 7685）的填充长度，以及填充内容是否全为 0（RFC 要求全为 0）
+func padding(extensions []tlsExtension) (length int, allZero bool, ok bool) {
+	data, ok := findExtension(extensions, extPadding)
+	if !ok {
+		return 0, false, false
+	}
+
+	allZero = true
+	for _, b := range data {
+		if b != 0 {
+			allZero = false
+			break
+		}
+	}
+	return len(data), allZero, true
+}
+
+// alpnProtocols 返回 application_layer_protocol_negotiation 扩展（RFC 7301）中的协议列表。
+// ClientHello 中是客户端支持的协议，ServerHello 中只有服务器选中的一个。未携带该扩展或格式错误时返回 nil
+func alpnProtocols(extensions []tlsExtension) []string {
+	data, ok := findExtension(extensions, extALPN)
+	if !ok {
+		return nil
+	}
+
+	r := byteReader(data)
+	var list byteReader
+	if !r.readUint16LengthPrefixed(&list) || !r.empty() {
+		return nil
+	}
+	var protocols []string
+	for !list.empty() {
+		var proto byteReader
+		if !list.readUint8LengthPrefixed(&proto) {
+			return nil
+		}
+		protocols = append(protocols, string(proto))
+	}
+	return protocols
+}
+
+// alpsProtocols 返回 ClientHello 中 application_settings（ALPS，draft-vvv-tls-alps）扩展列出的协议，
+// 即客户端愿意在握手中交换应用层设置（例如 HTTP/2 的 SETTINGS）的 ALPN 协议。格式与 ALPN 扩展相同。
+// 同时返回实际使用的扩展代码，未携带该扩展或格式错误时 ok 为 false
+func alpsProtocols(extensions []tlsExtension) (protocols []string, extType uint16, ok bool) {
+	for _, extType = range []uint16{extApplicationSettings, extApplicationSettingsNew} {
+		data, found := findExtension(extensions, extType)
+		if !found {
+			continue
+		}
+		protocols = alpnProtocols([]tlsExtension{{extALPN, data}})
+		return protocols, extType, protocols != nil
+	}
+	return nil, 0, false
+}
+
+// appendExtensions 按 Hello 消息中的格式写入扩展列表
+func appendExtensions(b []byte, extensions []tlsExtension) []byte {
+	return appendUint16LengthPrefixed(b, func(b []byte) []byte {
+		for _, ext := range extensions {
+			b = appendUint16(b, ext.extType)
+			b = appendUint16LengthPrefixed(b, func(b []byte) []byte {
+				return append(b, ext.data...)
+			})
+		}
+		return b
+	})
+}
+
+// serverName 返回 server_name 扩展中的主机名，未携带该扩展或格式错误时返回空字符串
+func serverName(extensions []tlsExtension) string {
+	data, ok := findExtension(extensions, extServerName)
+	if !ok {
+		return ""
+	}
+
+	r := byteReader(data)
+	var nameList byteReader
+	if !r.readUint16LengthPrefixed(&nameList) {
+		return ""
+	}
+	for !nameList.empty() {
+		var nameType uint8
+		var name byteReader
+		if !nameList.readUint8(&nameType) || !nameList.readUint16LengthPrefixed(&name) {
+			return ""
+		}
+		// RFC 6066 3 中 name_type 只定义了 host_name (0)
+		if nameType == 0 {
+			return string(name)
+		}
+	}
+	return ""
+}
+
+// buildServerNameExtension 构造只含一个 host_name 的 server_name 扩展内容
+func buildServerNameExtension(host string) []byte {
+	return appendUint16LengthPrefixed(nil, func(b []byte) []byte {
+		b = append(b, 0)
+		return appendUint16LengthPrefixed(b, func(b []byte) []byte {
+			return append(b, host...)
+		})
+	})
+}
+
+type clientHello struct {
+	legacyVersion      uint16
+	random             []byte
+	sessionID          []byte
+	cipherSuites       []uint16
+	compressionMethods []byte
+	extensions         []tlsExtension
+}
+
+// parseClientHello 解析 ClientHello 的消息体（不含 4 字节的握手消息头）
+func parseClientHello(body []byte) (*clientHello, error) {
+	r := byteReader(body)
+	hello := &clientHello{}
+
+	var sessionID, cipherSuites, compressionMethods byteReader
+	if !r.readUint16(&hello.legacyVersion) ||
+		!r.readBytes(32, &hello.random) ||
+		!r.readUint8LengthPrefixed(&sessionID) ||
+		!r.readUint16LengthPrefixed(&cipherSuites) ||
+		!r.readUint8LengthPrefixed(&compressionMethods) {
+		return nil, errors.New("ClientHello 格式错误")
+	}
+	hello.sessionID = sessionID
+	hello.compressionMethods = compressionMethods
+
+	for !cipherSuites.empty() {
+		var suite uint16
+		if !cipherSuites.readUint16(&suite) {
+			return nil, errors.New("密码套件列表长度不是偶数")
+		}
+		hello.cipherSuites = append(hello.cipherSuites, suite)
+	}
+
+	var err error
+	hello.extensions, err = parseExtensions(&r)
+	if err != nil {
+		return nil, err
+	}
+	return hello, nil
+}
+
+// removeExtension 移除所有类型为 extType 的扩展，返回是否确实移除了扩展
+func (h *clientHello) removeExtension(extType uint16) bool {
+	kept := h.extensions[:0]
+	for _, ext := range h.extensions {
+		if ext.extType != extType {
+			kept = append(kept, ext)
+		}
+	}
+
+	removed := len(kept) != len(h.extensions)
+	h.extensions = kept
+	return removed
+}
+
+// setServerName 将 SNI 替换为 host。原本没有 server_name 扩展时，把它插入到扩展列表的最前面，
+// 这样不会破坏 TLS 1.3 要求 pre_shared_key 必须是最后一个扩展的规定。返回原来的主机名
+func (h *clientHello) setServerName(host string) (string, bool) {
+	oldHost := serverName(h.extensions)
+	data := buildServerNameExtension(host)
+
+	for i := range h.extensions {
+		if h.extensions[i].extType == extServerName {
+			h.extensions[i].data = data
+			return oldHost, true
+		}
+	}
+
+	h.extensions = append([]tlsExtension{{extType: extServerName, data: data}}, h.extensions...)
+	return "", false
+}
+
+// forceVersion 改写 ClientHello，使其只提供 version 这一个版本。
+// TLS 1.3 只能通过 supported_versions 扩展协商，legacy_version 固定为 TLS 1.2；
+// 更早的版本则通过 legacy_version 协商，并且必须去掉 supported_versions，否则服务器会优先看该扩展
+func (h *clientHello) forceVersion(version uint16) {
+	if version != versionTLS13 {
+		h.legacyVersion = version
+		h.removeExtension(extSupportedVersions)
+		return
+	}
+
+	h.legacyVersion = versionTLS12
+	data := []byte{2, byte(version >> 8), byte(version)}
+	for i := range h.extensions {
+		if h.extensions[i].extType == extSupportedVersions {
+			h.extensions[i].data = data
+			return
+		}
+	}
+	// 和 setServerName 一样插入到最前面，避免破坏 pre_shared_key 必须是最后一个扩展的规定
+	h.extensions = append([]tlsExtension{{extType: extSupportedVersions, data: data}}, h.extensions...)
+}
+
+// marshal 将 ClientHello 编码为完整的握手消息（含 4 字节的握手消息头）
+func (h *clientHello) marshal() []byte {
+	b := []byte{handshakeTypeClientHello}
+	return appendUint24LengthPrefixed(b, func(b []byte) []byte {
+		b = appendUint16(b, h.legacyVersion)
+		b = append(b, h.random...)
+		b = appendUint8LengthPrefixed(b, func(b []byte) []byte {
+			return append(b, h.sessionID...)
+		})
+		b = appendUint16LengthPrefixed(b, func(b []byte) []byte {
+			for _, suite := range h.cipherSuites {
+				b = appendUint16(b, suite)
+			}
+			return b
+		})
+		b = appendUint8LengthPrefixed(b, func(b []byte) []byte {
+			return append(b, h.compressionMethods...)
+		})
+		if h.extensions != nil {
+			b = appendExtensions(b, h.extensions)
+		}
+		return b
+	})
+}
+
+// supportedVersions 返回客户端在 supported_versions 扩展中提供的版本列表，按客户端的偏好排序。
+// ClientHello 中该扩展是 1 字节长度前缀的列表，未携带该扩展或格式错误时返回 nil
+func (h *clientHello) supportedVersions() []uint16 {
+	data, ok := findExtension(h.extensions, extSupportedVersions)
+	if !ok {
+		return nil
+	}
+
+	r := byteReader(data)
+	var list byteReader
+	if !r.readUint8LengthPrefixed(&list) || !r.empty() || len(list)%2 != 0 {
+		return nil
+	}
+	var versions []uint16
+	for !list.empty() {
+		var v uint16
+		list.readUint16(&v)
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+type serverHello struct {
+	legacyVersion     uint16
+	random            []byte
+	sessionID         []byte
+	cipherSuite       uint16
+	compressionMethod byte
+	extensions        []tlsExtension
+}
+
+// parseServerHello 解析 ServerHello 的消息体（不含 4 字节的握手消息头）
+func parseServerHello(body []byte) (*serverHello, error) {
+	r := byteReader(body)
+	hello := &serverHello{}
+
+	var sessionID byteReader
+	if !r.readUint16(&hello.legacyVersion) ||
+		!r.readBytes(32, &hello.random) ||
+		!r.readUint8LengthPrefixed(&sessionID) ||
+		!r.readUint16(&hello.cipherSuite) ||
+		!r.readUint8(&hello.compressionMethod) {
+		return nil, errors.New("ServerHello 格式错误")
+	}
+	hello.sessionID = sessionID
+
+	var err error
+	hello.extensions, err = parseExtensions(&r)
+	if err != nil {
+		return nil, err
+	}
+	return hello, nil
+}
+
+// helloRetryRequestRandom 是 HelloRetryRequest 的 random 字段，即 SHA-256("HelloRetryRequest")（RFC 8446 4.1.3）。
+// HelloRetryRequest 的格式与 ServerHello 完全相同，只能靠这个固定的 random 来区分
+var helloRetryRequestRandom = []byte{
+	0xCF, 0x21, 0xAD, 0x74, 0xE5, 0x9A, 0x61, 0x11, 0xBE, 0x1D, 0x8C, 0x02, 0x1E, 0x65, 0xB8, 0x91,
+	0xC2, 0xA2, 0x11, 0x16, 0x7A, 0xBB, 0x8C, 0x5E, 0x07, 0x9E, 0x09, 0xE2, 0xC8, 0xA8, 0x33, 0x9C,
+}
+
+// isHelloRetryRequest 判断这条 ServerHello 是否其实是 HelloRetryRequest
+func (h *serverHello) isHelloRetryRequest() bool {
+	return bytes.Equal(h.random, helloRetryRequestRandom)
+}
+
+// retryGroup 返回 HelloRetryRequest 的 key_share 扩展中服务器要求客户端改用的组。
+// 与 ServerHello 不同，这里的 key_share 只有 2 字节的组编号，没有公钥
+func (h *serverHello) retryGroup() (uint16, bool) {
+	data, ok := findExtension(h.extensions, extKeyShare)
+	if !ok {
+		return 0, false
+	}
+	r := byteReader(data)
+	var group uint16
+	if !r.readUint16(&group) || !r.empty() {
+		return 0, false
+	}
+	return group, true
+}
+
+// selectedVersion 返回服务器实际选定的版本：
+// TLS 1.3 中真正的版本号放在 supported_versions 扩展里，legacy_version 固定为 0x0303
+func (h *serverHello) selectedVersion() uint16 {
+	data, ok := findExtension(h.extensions, extSupportedVersions)
+	if !ok {
+		return h.legacyVersion
+	}
+
+	// ServerHello 中的 supported_versions 只有一个 2 字节的版本号，不是列表
+	r := byteReader(data)
+	var version uint16
+	if !r.readUint16(&version) || !r.empty() {
+		return h.legacyVersion
+	}
+	return version
+}
+
+// parseCertificateList 解析 TLS 1.2 Certificate 消息的消息体，返回其中每张证书的 DER 编码。
+// TLS 1.3 的 Certificate 消息格式不同（每张证书后面带有扩展），且是加密传输的
+func parseCertificateList(body []byte) ([][]byte, error) {
+	r := byteReader(body)
+
+	var list byteReader
+	if !r.readUint24LengthPrefixed(&list) || !r.empty() {
+		return nil, errors.New("证书列表长度不正确")
+	}
+
+	var certs [][]byte
+	for !list.empty() {
+		var cert byteReader
+		if !list.readUint24LengthPrefixed(&cert) {
+			return nil, errors.New("证书长度不正确")
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// describeServerKeyExchange 根据所选密码套件的密钥交换方式，简要描述 ServerKeyExchange 中的参数
+func describeServerKeyExchange(cipherSuite uint16, body []byte) string {
+	suiteName := CIPHER_SUITE_TABLE[cipherSuite]
+	r := byteReader(body)
+
+	switch {
+	case strings.Contains(suiteName, "_ECDHE_"):
+		// RFC 8422 5.4：ECParameters 中 curve_type 为 named_curve (3) 时后跟 2 字节的曲线编号
+		var curveType uint8
+		var group uint16
+		var publicKey byteReader
+		if !r.readUint8(&curveType) || curveType != 3 || !r.readUint16(&group) || !r.readUint8LengthPrefixed(&publicKey) {
+			return "ECDHE 参数格式无法识别"
+		}
+		return fmt.Sprintf("ECDHE 参数，曲线 %s (%d)，公钥 %d 字节", lookupName(SUPPORTED_GROUP_TABLE, group), group, len(publicKey))
+	case strings.Contains(suiteName, "_DHE_"):
+		var p, g, ys byteReader
+		if !r.readUint16LengthPrefixed(&p) || !r.readUint16LengthPrefixed(&g) || !r.readUint16LengthPrefixed(&ys) {
+			return "DHE 参数格式无法识别"
+		}
+		return fmt.Sprintf("DHE 参数，%d 位素数", len(p)*8)
+	}
+	return fmt.Sprintf("%d 字节", len(body))
+}
+
+// certificateRequest 是 TLS 1.2 及更早版本中 CertificateRequest 消息的内容（RFC 5246 7.4.4）
+type certificateRequest struct {
+	certificateTypes    []byte
+	signatureAlgorithms []uint16
+	// 服务器可接受的 CA 的 DistinguishedName，为 DER 编码
+	authorities [][]byte
+}
+
+// parseCertificateRequest 解析 TLS 1.2 及更早版本的 CertificateRequest 消息体。
+// supported_signature_algorithms 字段是 TLS 1.2 新增的，hasSignatureAlgorithms 为 false 时不解析它
+func parseCertificateRequest(body []byte, hasSignatureAlgorithms bool) (*certificateRequest, error) {
+	r := byteReader(body)
+	req := &certificateRequest{}
+
+	var types byteReader
+	if !r.readUint8LengthPrefixed(&types) {
+		return nil, errors.New("证书类型列表格式错误")
+	}
+	req.certificateTypes = types
+
+	if hasSignatureAlgorithms {
+		var algorithms byteReader
+		if !r.readUint16LengthPrefixed(&algorithms) {
+			return nil, errors.New("签名算法列表格式错误")
+		}
+		for !algorithms.empty() {
+			var algorithm uint16
+			if !algorithms.readUint16(&algorithm) {
+				return nil, errors.New("签名算法列表长度不是偶数")
+			}
+			req.signatureAlgorithms = append(req.signatureAlgorithms, algorithm)
+		}
+	}
+
+	var authorities byteReader
+	if !r.readUint16LengthPrefixed(&authorities) || !r.empty() {
+		return nil, errors.New("CA 列表格式错误")
+	}
+	for !authorities.empty() {
+		var dn byteReader
+		if !authorities.readUint16LengthPrefixed(&dn) {
+			return nil, errors.New("DistinguishedName 长度不正确")
+		}
+		req.authorities = append(req.authorities, dn)
+	}
+	return req, nil
+}
+
+// newSessionTicket 是 TLS 1.2 的 NewSessionTicket 消息（RFC 5077 3.3）。
+// TLS 1.3 的 NewSessionTicket 结构不同，并且在握手完成后加密传输，代理看不到
+type newSessionTicket struct {
+	lifetimeHint uint32
+	ticket       []byte
+}
+
+func parseNewSessionTicket(body []byte) (*newSessionTicket, error) {
+	r := byteReader(body)
+	t := &newSessionTicket{}
+	var ticket byteReader
+	if !r.readUint32(&t.lifetimeHint) || !r.readUint16LengthPrefixed(&ticket) || !r.empty() {
+		return nil, errors.New("NewSessionTicket 格式错误")
+	}
+	t.ticket = ticket
+	return t, nil
+}
+
+// certificateStatus 是 TLS 1.2 中服务器在 Certificate 之后发送的 CertificateStatus 消息（RFC 6066 8），
+// 用来“装订”（stapling）叶子证书的 OCSP 响应。TLS 1.3 不再使用这条消息，
+// OCSP 响应改为放在 Certificate 消息中每张证书的 status_request 扩展里
+type certificateStatus struct {
+	statusType uint8
+	response   []byte
+}
+
+func parseCertificateStatus(body []byte) (*certificateStatus, error) {
+	r := byteReader(body)
+	st := &certificateStatus{}
+	var response byteReader
+	if !r.readUint8(&st.statusType) || !r.readUint24LengthPrefixed(&response) || !r.empty() {
+		return nil, errors.New("CertificateStatus 格式错误")
+	}
+	st.response = response
+	return st, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestClientHelloSupportedVersions(t *testing.T) {
+	tests := []struct {
+		name string
+		// 为 nil 时不携带 supported_versions 扩展
+		data []byte
+		want []uint16
+	}{
+		{"没有该扩展", nil, nil},
+		{"TLS 1.3 和 TLS 1.2", []byte{4, 0x03, 0x04, 0x03, 0x03}, []uint16{versionTLS13, versionTLS12}},
+		{"只有一个版本", []byte{2, 0x03, 0x04}, []uint16{versionTLS13}},
+		{"列表长度为奇数", []byte{3, 0x03, 0x04, 0x03}, nil},
+		{"长度字节超出了扩展末尾", []byte{6, 0x03, 0x04, 0x03, 0x03}, nil},
+		{"列表之后还有多余的字节", []byte{2, 0x03, 0x04, 0x03}, nil},
+		{"空扩展", []byte{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello := &clientHello{}
+			if tt.data != nil {
+				hello.extensions = []tlsExtension{{extSupportedVersions, tt.data}}
+			}
+			if got := hello.supportedVersions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("supportedVersions() = %#v，期望 %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerHelloSelectedVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		// 为 nil 时不携带 supported_versions 扩展
+		data []byte
+		want uint16
+	}{
+		// 没有该扩展、或扩展格式不正确时沿用 legacy_version
+		{"没有该扩展", nil, versionTLS12},
+		{"TLS 1.3", []byte{0x03, 0x04}, versionTLS13},
+		{"客户端形式的列表", []byte{2, 0x03, 0x04}, versionTLS12},
+		{"只有一个字节", []byte{0x03}, versionTLS12},
+		{"版本之后还有多余的字节", []byte{0x03, 0x04, 0x00}, versionTLS12},
+		{"空扩展", []byte{}, versionTLS12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello := &serverHello{legacyVersion: versionTLS12}
+			if tt.data != nil {
+				hello.extensions = []tlsExtension{{extSupportedVersions, tt.data}}
+			}
+			if got := hello.selectedVersion(); got != tt.want {
+				t.Errorf("selectedVersion() = 0x%04X，期望 0x%04X", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRewriteClientHelloRoundTrip 对 ClientHello 做“解析 → 改写 → 编码 → 再解析”的往返测试，
+// 逐层核对记录、握手消息、扩展列表和每个扩展的长度字段，并检查改写之外的内容原样保留
+func TestRewriteClientHelloRoundTrip(t *testing.T) {
+	alpn := extALPN
+	tests := []struct {
+		name string
+		// 为 false 时原 ClientHello 不携带 server_name 扩展
+		withSNI      bool
+		stripExt     *uint16
+		rewriteSNI   string
+		forceVersion uint16
+
+		wantSNI      string
+		wantVersions []uint16
+		wantLegacy   uint16
+		// 改写后的扩展类型，按顺序排列
+		wantExtTypes []uint16
+	}{
+		{
+			name: "移除扩展", withSNI: true, stripExt: &alpn,
+			wantSNI: "example.com", wantVersions: []uint16{versionTLS13, versionTLS12}, wantLegacy: versionTLS12,
+			wantExtTypes: []uint16{extServerName, extSupportedVersions, extKeyShare},
+		},
+		{
+			name: "SNI 变长", withSNI: true, rewriteSNI: "a-much-longer-host-name.example.org",
+			wantSNI: "a-much-longer-host-name.example.org", wantVersions: []uint16{versionTLS13, versionTLS12}, wantLegacy: versionTLS12,
+			wantExtTypes: []uint16{extServerName, extSupportedVersions, extALPN, extKeyShare},
+		},
+		{
+			name: "SNI 变短", withSNI: true, rewriteSNI: "a.io",
+			wantSNI: "a.io", wantVersions: []uint16{versionTLS13, versionTLS12}, wantLegacy: versionTLS12,
+			wantExtTypes: []uint16{extServerName, extSupportedVersions, extALPN, extKeyShare},
+		},
+		{
+			name: "插入原本没有的 SNI", withSNI: false, rewriteSNI: "inserted.example",
+			wantSNI: "inserted.example", wantVersions: []uint16{versionTLS13, versionTLS12}, wantLegacy: versionTLS12,
+			wantExtTypes: []uint16{extServerName, extSupportedVersions, extALPN, extKeyShare},
+		},
+		{
+			name: "强制 TLS 1.3", withSNI: true, forceVersion: versionTLS13,
+			wantSNI: "example.com", wantVersions: []uint16{versionTLS13}, wantLegacy: versionTLS12,
+			wantExtTypes: []uint16{extServerName, extSupportedVersions, extALPN, extKeyShare},
+		},
+		{
+			name: "强制 TLS 1.1", withSNI: true, forceVersion: versionTLS11,
+			wantSNI: "example.com", wantVersions: nil, wantLegacy: versionTLS11,
+			wantExtTypes: []uint16{extServerName, extALPN, extKeyShare},
+		},
+		{
+			name: "同时移除扩展、插入 SNI 并强制版本", withSNI: false, stripExt: &alpn, rewriteSNI: "all.example", forceVersion: versionTLS12,
+			wantSNI: "all.example", wantVersions: nil, wantLegacy: versionTLS12,
+			wantExtTypes: []uint16{extServerName, extKeyShare},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldConfig := config
+			t.Cleanup(func() { config = oldConfig })
+			config.stripExt = tt.stripExt
+			config.rewriteSNI = tt.rewriteSNI
+			config.forceVersion = tt.forceVersion
+
+			original := testClientHello(tt.withSNI)
+			msg := original.marshal()
+			checkClientHelloLengths(t, appendHandshakeRecords(nil, versionTLS10, msg))
+
+			newMsg, _, err := rewriteClientHello(msg)
+			if err != nil {
+				t.Fatalf("rewriteClientHello 出错：%v", err)
+			}
+			checkClientHelloLengths(t, appendHandshakeRecords(nil, versionTLS10, newMsg))
+
+			hello, err := parseClientHello(newMsg[4:])
+			if err != nil {
+				t.Fatalf("无法重新解析改写后的 ClientHello：%v", err)
+			}
+			var extTypes []uint16
+			for _, ext := range hello.extensions {
+				extTypes = append(extTypes, ext.extType)
+			}
+			if !reflect.DeepEqual(extTypes, tt.wantExtTypes) {
+				t.Errorf("扩展类型为 %v，期望 %v", extTypes, tt.wantExtTypes)
+			}
+			if got := serverName(hello.extensions); got != tt.wantSNI {
+				t.Errorf("SNI = %q，期望 %q", got, tt.wantSNI)
+			}
+			if got := hello.supportedVersions(); !reflect.DeepEqual(got, tt.wantVersions) {
+				t.Errorf("supported_versions = %#v，期望 %#v", got, tt.wantVersions)
+			}
+			if hello.legacyVersion != tt.wantLegacy {
+				t.Errorf("legacy_version = 0x%04X，期望 0x%04X", hello.legacyVersion, tt.wantLegacy)
+			}
+
+			// 改写之外的字段和未被改动的扩展必须原样保留
+			if !bytes.Equal(hello.random, original.random) ||
+				!bytes.Equal(hello.sessionID, original.sessionID) ||
+				!reflect.DeepEqual(hello.cipherSuites, original.cipherSuites) ||
+				!bytes.Equal(hello.compressionMethods, original.compressionMethods) {
+				t.Error("random、session_id、密码套件或压缩方法在改写后发生了变化")
+			}
+			for _, extType := range []uint16{extALPN, extKeyShare} {
+				want, _ := findExtension(original.extensions, extType)
+				if got, ok := findExtension(hello.extensions, extType); ok && !bytes.Equal(got, want) {
+					t.Errorf("扩展 %d 的内容在改写后发生了变化", extType)
+				}
+			}
+		})
+	}
+}
+
+// testClientHello 构造一个带有 supported_versions、ALPN 和 key_share 扩展的 ClientHello，withSNI 为 true 时
+// 在最前面加上 SNI 为 example.com 的 server_name 扩展
+func testClientHello(withSNI bool) *clientHello {
+	hello := &clientHello{
+		legacyVersion:      versionTLS12,
+		random:             bytes.Repeat([]byte{0xAA}, 32),
+		sessionID:          bytes.Repeat([]byte{0xBB}, 32),
+		cipherSuites:       []uint16{0x1301, 0x1302, 0xC02F},
+		compressionMethods: []byte{0},
+	}
+	if withSNI {
+		hello.extensions = append(hello.extensions, tlsExtension{extServerName, buildServerNameExtension("example.com")})
+	}
+	hello.extensions = append(hello.extensions,
+		tlsExtension{extSupportedVersions, []byte{4, 0x03, 0x04, 0x03, 0x03}},
+		tlsExtension{extALPN, []byte{0, 3, 2, 'h', '2'}},
+		tlsExtension{extKeyShare, append([]byte{0, 36, 0, 29, 0, 32}, bytes.Repeat([]byte{0xCC}, 32)...)},
+	)
+	return hello
+}
+
+// checkClientHelloLengths 不借助被测的解析代码，直接按字节检查只含一条 ClientHello 的记录中的每个长度字段：
+// 记录长度、握手消息长度、扩展列表长度、每个扩展的长度，以及 server_name 扩展内部的两层长度
+func checkClientHelloLengths(t *testing.T, record []byte) {
+	t.Helper()
+	if len(record) < 9 || record[0] != contentTypeHandshake {
+		t.Fatalf("不是握手记录：% X", record)
+	}
+	if got := int(binary.BigEndian.Uint16(record[3:5])); got != len(record)-5 {
+		t.Fatalf("记录长度字段为 %d，实际内容 %d 字节", got, len(record)-5)
+	}
+	msg := record[5:]
+	if msg[0] != handshakeTypeClientHello {
+		t.Fatalf("握手消息类型为 %d", msg[0])
+	}
+	if got := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]); got != len(msg)-4 {
+		t.Fatalf("握手消息长度字段为 %d，实际内容 %d 字节", got, len(msg)-4)
+	}
+
+	// 跳过 legacy_version、random、session_id、密码套件和压缩方法
+	b := msg[4+2+32:]
+	b = b[1+int(b[0]):]
+	b = b[2+int(binary.BigEndian.Uint16(b)):]
+	b = b[1+int(b[0]):]
+
+	if got := int(binary.BigEndian.Uint16(b)); got != len(b)-2 {
+		t.Fatalf("扩展列表长度字段为 %d，实际内容 %d 字节", got, len(b)-2)
+	}
+	for b = b[2:]; len(b) > 0; {
+		if len(b) < 4 {
+			t.Fatalf("扩展列表末尾多出 %d 字节", len(b))
+		}
+		extType, n := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if n > len(b)-4 {
+			t.Fatalf("扩展 %d 的长度字段为 %d，超出了扩展列表", extType, n)
+		}
+		data := b[4 : 4+n]
+		if extType == extServerName {
+			if listLen := int(binary.BigEndian.Uint16(data)); listLen != len(data)-2 {
+				t.Fatalf("server_name_list 的长度字段为 %d，实际内容 %d 字节", listLen, len(data)-2)
+			}
+			if hostLen := int(binary.BigEndian.Uint16(data[3:])); data[2] != 0 || hostLen != len(data)-5 {
+				t.Fatalf("host_name 的长度字段为 %d，实际内容 %d 字节", hostLen, len(data)-5)
+			}
+		}
+		b = b[4+n:]
+	}
+}
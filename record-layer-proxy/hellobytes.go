@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// printHelloBytes 用于 -print-hello-bytes 和 -hello-bytes-dir：把客户端发出的第一条 ClientHello 重新分装成记录后，
+// 以十六进制文本输出或写入 dir 下的 hello-<编号>.hex 文件。msg 是拼接完整的握手消息（含消息头），
+// 因此即使 ClientHello 跨越了多条记录，输出的也是一条完整的记录（超过 16KB 时才会拆成多条），
+// 可以直接交给子命令 analyze 解析，或附在问题报告中用于复现
+func (s *connState) printHelloBytes(recordVersion uint16, msg []byte) {
+	records := appendHandshakeRecords(nil, recordVersion, msg)
+	text := hex.EncodeToString(records)
+	label := s.directionLabel(true)
+
+	if config.printHelloBytes {
+		logPrintf("[hello-bytes %s] ClientHello 记录（%d 字节）：\n%s\n", label, len(records), text)
+	}
+	if config.helloBytesDir != "" {
+		path := filepath.Join(config.helloBytesDir, fmt.Sprintf("hello-%d.hex", s.id))
+		if err := os.WriteFile(path, []byte(text+"\n"), 0o644); err != nil {
+			logPrintf("[hello-bytes %s] 无法写入 %s：%v\n", label, path, err)
+			return
+		}
+		logDetailf("[hello-bytes %s] ClientHello 记录已写入 %s，可以用子命令 analyze %s 解析\n", label, path, path)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	extSignatureAlgorithms uint16 = 13
+	extPSKKeyExchangeModes uint16 = 45
+	extKeyShare            uint16 = 51
+)
+
+// formatCode 按 “名称 (代码)” 的格式输出 table 中的值，GREASE 值显示为 “GREASE (0x0A0A)”
+func formatCode(table map[uint16]string, v uint16) string {
+	if isGREASE(v) {
+		return fmt.Sprintf("GREASE (0x%04X)", v)
+	}
+	return fmt.Sprintf("%s (%d)", lookupName(table, v), v)
+}
+
+// formatVersionList 以顿号连接各个版本，GREASE 值显示为 “GREASE (0x0A0A)”
+func formatVersionList(versions []uint16) string {
+	var names []string
+	for _, v := range versions {
+		if isGREASE(v) {
+			names = append(names, fmt.Sprintf("GREASE (0x%04X)", v))
+		} else {
+			names = append(names, formatVersion(v))
+		}
+	}
+	return strings.Join(names, "、")
+}
+
+// extensionOrder 返回按出现顺序排列的扩展列表，以及关于 GREASE 扩展位置的说明
+func extensionOrder(extensions []tlsExtension) (string, string) {
+	var names []string
+	var greasePositions []string
+	for i, ext := range extensions {
+		names = append(names, fmt.Sprintf("%d", ext.extType))
+		if isGREASE(ext.extType) {
+			names[i] = fmt.Sprintf("GREASE(0x%04X)", ext.extType)
+			greasePositions = append(greasePositions, fmt.Sprint(i+1))
+		}
+	}
+	order := strings.Join(names, " ")
+
+	switch {
+	case len(greasePositions) == 0:
+		return order, "没有 GREASE 扩展，同一客户端每次连接的扩展顺序通常是固定的"
+	case greasePositions[0] == "1":
+		// BoringSSL 把一个 GREASE 扩展放在最前面，另一个放在最后，Chrome 110 起还会随机打乱中间其余扩展的顺序
+		return order, fmt.Sprintf("第一个扩展是 GREASE（GREASE 位于第 %s 个），这是 Chrome 等基于 BoringSSL 的客户端的惯例，这类客户端还可能每次随机打乱其余扩展的顺序", strings.Join(greasePositions, "、"))
+	}
+	return order, fmt.Sprintf("GREASE 扩展位于第 %s 个", strings.Join(greasePositions, "、"))
+}
+
+// dumpClientHello 返回 ClientHello 的完整内容，包括全部密码套件、全部扩展及其中常见字段的解码结果和 JA3 指纹
+func dumpClientHello(recordVersion uint16, hello *clientHello) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  记录层版本：%s\n", formatVersion(recordVersion))
+	fmt.Fprintf(&b, "  legacy_version：%s\n", formatVersion(hello.legacyVersion))
+	fmt.Fprintf(&b, "  random：%s\n", hex.EncodeToString(hello.random))
+	fmt.Fprintf(&b, "  session_id（%d 字节）：%s\n", len(hello.sessionID), hex.EncodeToString(hello.sessionID))
+
+	fmt.Fprintf(&b, "  密码套件（%d 个）：\n", len(hello.cipherSuites))
+	for _, suite := range hello.cipherSuites {
+		if isGREASE(suite) {
+			fmt.Fprintf(&b, "    GREASE (0x%04X)\n", suite)
+		} else if config.explain {
+			fmt.Fprintf(&b, "    %s：%s\n", formatCipherSuite(suite), explainCipherSuite(suite))
+		} else {
+			fmt.Fprintf(&b, "    %s\n", formatCipherSuite(suite))
+		}
+	}
+
+	var methods []string
+	for _, m := range hello.compressionMethods {
+		if m == 0 {
+			methods = append(methods, "null (0)")
+		} else {
+			methods = append(methods, fmt.Sprintf("未知 (%d)", m))
+		}
+	}
+	fmt.Fprintf(&b, "  压缩方法：%s\n", strings.Join(methods, "、"))
+
+	fmt.Fprintf(&b, "  扩展（%d 个）：\n", len(hello.extensions))
+	for _, ext := range hello.extensions {
+		fmt.Fprintf(&b, "    %s，%d 字节", formatCode(EXTENSION_TYPE_TABLE, ext.extType), len(ext.data))
+		if detail := describeExtension(hello, ext); detail != "" {
+			b.WriteString("：" + detail)
+		}
+		b.WriteString("\n")
+	}
+
+	ja3String, ja3Hash := ja3(hello)
+	fmt.Fprintf(&b, "  JA3：%s\n", ja3String)
+	fmt.Fprintf(&b, "  JA3 哈希：%s\n", ja3Hash)
+	return b.String()
+}
+
+// describeExtension 解码常见扩展的内容，未知或格式错误的扩展返回空字符串
+func describeExtension(hello *clientHello, ext tlsExtension) string {
+	extensions := hello.extensions
+	r := byteReader(ext.data)
+	var list byteReader
+
+	switch ext.extType {
+	case extServerName:
+		return serverName(extensions)
+
+	case extSupportedGroups:
+		var names []string
+		for _, g := range supportedGroups(extensions) {
+			names = append(names, formatCode(SUPPORTED_GROUP_TABLE, g))
+		}
+		return strings.Join(names, "、")
+
+	case extECPointFormats:
+		formatNames := map[uint8]string{0: "uncompressed", 1: "ansiX962_compressed_prime", 2: "ansiX962_compressed_char2"}
+		var names []string
+		for _, f := range ecPointFormats(extensions) {
+			names = append(names, fmt.Sprintf("%s (%d)", lookupName(formatNames, f), f))
+		}
+		return strings.Join(names, "、")
+
+	case extSignatureAlgorithms:
+		if !r.readUint16LengthPrefixed(&list) {
+			return ""
+		}
+		var names []string
+		for !list.empty() {
+			var scheme uint16
+			if !list.readUint16(&scheme) {
+				return ""
+			}
+			names = append(names, formatSignatureScheme(scheme))
+		}
+		return strings.Join(names, "、")
+
+	case extALPN:
+		var protocols []string
+		for _, proto := range alpnProtocols(extensions) {
+			protocols = append(protocols, fmt.Sprintf("%q", proto))
+		}
+		return strings.Join(protocols, "、")
+
+	case extApplicationSettings, extApplicationSettingsNew:
+		protocols, _, ok := alpsProtocols([]tlsExtension{ext})
+		if !ok {
+			return ""
+		}
+		var quoted []string
+		for _, proto := range protocols {
+			quoted = append(quoted, fmt.Sprintf("%q", proto))
+		}
+		return "非标准的实验性扩展，可在握手中交换应用层设置的协议：" + strings.Join(quoted, "、")
+
+	case extDelegatedCredentials:
+		if schemes, ok := delegatedCredentialSchemes([]tlsExtension{ext}); ok {
+			return describeDelegatedCredentialSchemes(schemes)
+		}
+		return ""
+
+	case extQUICTransportParameters:
+		return describeQUICTransportParameters(ext.data)
+
+	case extTrustedCAKeys:
+		if authorities, ok := trustedCAKeys([]tlsExtension{ext}); ok {
+			return describeTrustedCAKeys(authorities)
+		}
+		return ""
+
+	case extCompressCertificate:
+		if algorithms, ok := certCompressionAlgorithms([]tlsExtension{ext}); ok {
+			return describeCertCompressionAlgorithms(algorithms)
+		}
+		return ""
+
+	case extSupportedVersions:
+		return formatVersionList(hello.supportedVersions())
+
+	case extPSKKeyExchangeModes:
+		modeNames := map[uint8]string{0: "psk_ke", 1: "psk_dhe_ke"}
+		if !r.readUint8LengthPrefixed(&list) {
+			return ""
+		}
+		var names []string
+		for _, m := range list {
+			names = append(names, fmt.Sprintf("%s (%d)", lookupName(modeNames, m), m))
+		}
+		return strings.Join(names, "、")
+
+	case extKeyShare:
+		if !r.readUint16LengthPrefixed(&list) {
+			return ""
+		}
+		var shares []string
+		for !list.empty() {
+			var group uint16
+			var key byteReader
+			if !list.readUint16(&group) || !list.readUint16LengthPrefixed(&key) {
+				return ""
+			}
+			shares = append(shares, fmt.Sprintf("%s 公钥 %d 字节", formatCode(SUPPORTED_GROUP_TABLE, group), len(key)))
+		}
+		return strings.Join(shares, "、")
+
+	case extRecordSizeLimit:
+		return fmt.Sprint(recordSizeLimit(extensions))
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	extSupportedGroups uint16 = 10
+	extECPointFormats  uint16 = 11
+)
+
+// isGREASE 判断 v 是否是 RFC 8701 保留的 GREASE 值（0x0A0A、0x1A1A……0xFAFA）。
+// 客户端在密码套件、扩展、分组等列表中随机插入这些值，以检查服务器能否正确忽略未知的值
+func isGREASE(v uint16) bool {
+	return v&0x0F0F == 0x0A0A && v>>8 == v&0xFF
+}
+
+// supportedGroups 返回 supported_groups 扩展中的分组列表，未携带该扩展或格式错误时返回 nil
+func supportedGroups(extensions []tlsExtension) []uint16 {
+	data, ok := findExtension(extensions, extSupportedGroups)
+	if !ok {
+		return nil
+	}
+
+	r := byteReader(data)
+	var list byteReader
+	if !r.readUint16LengthPrefixed(&list) {
+		return nil
+	}
+	var groups []uint16
+	for !list.empty() {
+		var group uint16
+		if !list.readUint16(&group) {
+			return nil
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// ecPointFormats 返回 ec_point_formats 扩展中的点格式列表，未携带该扩展或格式错误时返回 nil
+func ecPointFormats(extensions []tlsExtension) []uint8 {
+	data, ok := findExtension(extensions, extECPointFormats)
+	if !ok {
+		return nil
+	}
+
+	r := byteReader(data)
+	var list byteReader
+	if !r.readUint8LengthPrefixed(&list) {
+		return nil
+	}
+	return list
+}
+
+// ja3 计算 ClientHello 的 JA3 指纹，返回指纹字符串及其 MD5。JA3 字符串的格式为
+// “版本,密码套件,扩展,分组,点格式”，各列表内的值以 - 连接，计算前去掉所有 GREASE 值
+func ja3(hello *clientHello) (string, string) {
+	join := func(values []uint16) string {
+		var parts []string
+		for _, v := range values {
+			if !isGREASE(v) {
+				parts = append(parts, fmt.Sprint(v))
+			}
+		}
+		return strings.Join(parts, "-")
+	}
+
+	var extTypes []uint16
+	for _, ext := range hello.extensions {
+		extTypes = append(extTypes, ext.extType)
+	}
+	var formats []uint16
+	for _, f := range ecPointFormats(hello.extensions) {
+		formats = append(formats, uint16(f))
+	}
+
+	s := strings.Join([]string{
+		fmt.Sprint(hello.legacyVersion),
+		join(hello.cipherSuites),
+		join(extTypes),
+		join(supportedGroups(hello.extensions)),
+		join(formats),
+	}, ",")
+	sum := md5.Sum([]byte(s))
+	return s, hex.EncodeToString(sum[:])
+}
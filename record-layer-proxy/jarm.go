@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ipid/learn-tls/record-layer-proxy/recordproxy"
+)
+
+// jarmTimeout 是 JARM 每次探测等待服务器回应的最长时间
+const jarmTimeout = 10 * time.Second
+
+// jarmProbe 描述 JARM 的一种 ClientHello，各字段的取值与 Salesforce 的参考实现（jarm.py）一一对应
+type jarmProbe struct {
+	name string
+	// ClientHello 的 legacy_version，TLS 1.3 的探测为 TLS 1.2，并通过 supported_versions 提供 TLS 1.3
+	version uint16
+	// 是否去掉 TLS 1.3 的密码套件（对应 jarm.py 的 "NO1.3"）
+	noTLS13Suites bool
+	// 密码套件的排列方式：FORWARD、REVERSE、TOP_HALF、BOTTOM_HALF 或 MIDDLE_OUT
+	cipherOrder string
+	grease      bool
+	// 是否只提供少见的 ALPN 协议（去掉 http/1.1 和 h2）
+	rareALPN bool
+	// supported_versions 扩展："1.2" 表示最高提供 TLS 1.2，"1.3" 表示最高提供 TLS 1.3，空字符串表示不发送该扩展
+	supportedVersions string
+	// ALPN 和 supported_versions 列表的排列方式
+	extensionOrder string
+}
+
+// jarmProbes 是 JARM 依次发送的十种 ClientHello，顺序决定了指纹中各部分的位置
+var jarmProbes = []jarmProbe{
+	{"TLS 1.2 正序", versionTLS12, false, "FORWARD", false, false, "1.2", "REVERSE"},
+	{"TLS 1.2 倒序", versionTLS12, false, "REVERSE", false, false, "1.2", "FORWARD"},
+	{"TLS 1.2 前半", versionTLS12, false, "TOP_HALF", false, false, "", "FORWARD"},
+	{"TLS 1.2 后半", versionTLS12, false, "BOTTOM_HALF", false, true, "", "FORWARD"},
+	{"TLS 1.2 由中间向外", versionTLS12, false, "MIDDLE_OUT", true, true, "", "REVERSE"},
+	{"TLS 1.1 正序", versionTLS11, false, "FORWARD", false, false, "", "FORWARD"},
+	{"TLS 1.3 正序", versionTLS13, false, "FORWARD", false, false, "1.3", "REVERSE"},
+	{"TLS 1.3 倒序", versionTLS13, false, "REVERSE", false, false, "1.3", "FORWARD"},
+	{"TLS 1.3 无 1.3 套件", versionTLS13, true, "FORWARD", false, false, "1.3", "FORWARD"},
+	{"TLS 1.3 由中间向外", versionTLS13, false, "MIDDLE_OUT", true, false, "1.3", "REVERSE"},
+}
+
+// jarmCipherSuites 是 JARM 提供的全部密码套件，按 jarm.py 中的顺序排列
+var jarmCipherSuites = []uint16{
+	0x0016, 0x0033, 0x0067, 0xC09E, 0xC0A2, 0x009E, 0x0039, 0x006B, 0xC09F, 0xC0A3, 0x009F, 0x0045, 0x00BE, 0x0088,
+	0x00C4, 0x009A, 0xC008, 0xC009, 0xC023, 0xC0AC, 0xC0AE, 0xC02B, 0xC00A, 0xC024, 0xC0AD, 0xC0AF, 0xC02C, 0xC072,
+	0xC073, 0xCCA9, 0x1302, 0x1301, 0xCC14, 0xC007, 0xC012, 0xC013, 0xC027, 0xC02F, 0xC014, 0xC028, 0xC030, 0xC060,
+	0xC061, 0xC076, 0xC077, 0xCCA8, 0x1305, 0x1304, 0x1303, 0xCC13, 0xC011, 0x000A, 0x002F, 0x003C, 0xC09C, 0xC0A0,
+	0x009C, 0x0035, 0x003D, 0xC09D, 0xC0A1, 0x009D, 0x0041, 0x00BA, 0x0084, 0x00C0, 0x0007, 0x0004, 0x0005,
+}
+
+// jarmCipherIndex 是计算指纹时给服务器选定的密码套件编号所用的列表，编号为下标加 1，不在列表中时为 len+1。
+// 它与 jarmCipherSuites 包含相同的套件，但顺序不同，不能互相替代
+var jarmCipherIndex = []uint16{
+	0x0004, 0x0005, 0x0007, 0x000A, 0x0016, 0x002F, 0x0033, 0x0035, 0x0039, 0x003C, 0x003D, 0x0041, 0x0045, 0x0067,
+	0x006B, 0x0084, 0x0088, 0x009A, 0x009C, 0x009D, 0x009E, 0x009F, 0x00BA, 0x00BE, 0x00C0, 0x00C4, 0xC007, 0xC008,
+	0xC009, 0xC00A, 0xC011, 0xC012, 0xC013, 0xC014, 0xC023, 0xC024, 0xC027, 0xC028, 0xC02B, 0xC02C, 0xC02F, 0xC030,
+	0xC060, 0xC061, 0xC072, 0xC073, 0xC076, 0xC077, 0xC09C, 0xC09D, 0xC09E, 0xC09F, 0xC0A0, 0xC0A1, 0xC0A2, 0xC0A3,
+	0xC0AC, 0xC0AD, 0xC0AE, 0xC0AF, 0xCC13, 0xCC14, 0xCCA8, 0xCCA9, 0x1301, 0x1302, 0x1303, 0x1304, 0x1305,
+}
+
+var (
+	jarmALPNs     = []string{"http/0.9", "http/1.0", "http/1.1", "spdy/1", "spdy/2", "spdy/3", "h2", "h2c", "hq"}
+	jarmRareALPNs = []string{"http/0.9", "http/1.0", "spdy/1", "spdy/2", "spdy/3", "h2c", "hq"}
+)
+
+// jarmReorder 按 JARM 的规则重新排列列表。TOP_HALF 和 BOTTOM_HALF 在长度为奇数时的处理方式比较特别，
+// 这里照搬 jarm.py 的 cipher_mung，否则算出的指纹无法与其他实现比较
+func jarmReorder[T any](list []T, order string) []T {
+	n := len(list)
+	var out []T
+	switch order {
+	case "FORWARD":
+		out = append(out, list...)
+	case "REVERSE":
+		for i := n - 1; i >= 0; i-- {
+			out = append(out, list[i])
+		}
+	case "BOTTOM_HALF":
+		out = append(out, list[n/2+n%2:]...)
+	case "TOP_HALF":
+		if n%2 == 1 {
+			out = append(out, list[n/2])
+		}
+		out = append(out, jarmReorder(jarmReorder(list, "REVERSE"), "BOTTOM_HALF")...)
+	case "MIDDLE_OUT":
+		middle := n / 2
+		if n%2 == 1 {
+			out = append(out, list[middle])
+			for i := 1; i <= middle; i++ {
+				out = append(out, list[middle+i], list[middle-i])
+			}
+		} else {
+			for i := 1; i <= middle; i++ {
+				out = append(out, list[middle-1+i], list[middle-i])
+			}
+		}
+	}
+	return out
+}
+
+// randomGREASE 随机返回一个 GREASE 值
+func randomGREASE() uint16 {
+	n, err := rand.Int(rand.Reader, big.NewInt(16))
+	panicIfErr(err, "randomGREASE")
+	b := uint16(n.Int64())<<4 | 0x0A
+	return b<<8 | b
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	panicIfErr(err, "randomBytes")
+	return b
+}
+
+// buildJARMClientHello 构造一次探测的 ClientHello 记录（含记录层头部）
+func buildJARMClientHello(probe jarmProbe, host string) []byte {
+	hello := &clientHello{
+		legacyVersion:      probe.version,
+		random:             randomBytes(32),
+		sessionID:          randomBytes(32),
+		compressionMethods: []byte{0},
+	}
+	recordVersion := probe.version
+	if probe.version == versionTLS13 {
+		hello.legacyVersion = versionTLS12
+		recordVersion = versionTLS10
+	}
+
+	for _, suite := range jarmCipherSuites {
+		if probe.noTLS13Suites && suite>>8 == 0x13 {
+			continue
+		}
+		hello.cipherSuites = append(hello.cipherSuites, suite)
+	}
+	hello.cipherSuites = jarmReorder(hello.cipherSuites, probe.cipherOrder)
+	if probe.grease {
+		hello.cipherSuites = append([]uint16{randomGREASE()}, hello.cipherSuites...)
+	}
+
+	if probe.grease {
+		hello.extensions = append(hello.extensions, tlsExtension{extType: randomGREASE()})
+	}
+	alpns := jarmALPNs
+	if probe.rareALPN {
+		alpns = jarmRareALPNs
+	}
+	alpns = jarmReorder(alpns, probe.extensionOrder)
+	hello.extensions = append(hello.extensions,
+		tlsExtension{extServerName, buildServerNameExtension(host)},
+		// extended_master_secret
+		tlsExtension{23, nil},
+		// max_fragment_length：2^9 字节
+		tlsExtension{1, []byte{1}},
+		// renegotiation_info
+		tlsExtension{0xFF01, []byte{0}},
+		// x25519、secp256r1、secp384r1、secp521r1
+		tlsExtension{extSupportedGroups, []byte{0x00, 0x08, 0x00, 0x1D, 0x00, 0x17, 0x00, 0x18, 0x00, 0x19}},
+		tlsExtension{extECPointFormats, []byte{1, 0}},
+		// session_ticket
+		tlsExtension{35, nil},
+		tlsExtension{extALPN, appendUint16LengthPrefixed(nil, func(b []byte) []byte {
+			for _, proto := range alpns {
+				b = appendUint8LengthPrefixed(b, func(b []byte) []byte {
+					return append(b, proto...)
+				})
+			}
+			return b
+		})},
+		tlsExtension{extSignatureAlgorithms, []byte{
+			0x00, 0x12, 0x04, 0x03, 0x08, 0x04, 0x04, 0x01, 0x05, 0x03, 0x08, 0x05, 0x05, 0x01, 0x08, 0x06, 0x06, 0x01, 0x02, 0x01,
+		}},
+		tlsExtension{extKeyShare, appendUint16LengthPrefixed(nil, func(b []byte) []byte {
+			if probe.grease {
+				b = appendUint16(b, randomGREASE())
+				b = append(b, 0x00, 0x01, 0x00)
+			}
+			b = appendUint16(b, 0x001D)
+			return appendUint16LengthPrefixed(b, func(b []byte) []byte {
+				return append(b, randomBytes(32)...)
+			})
+		})},
+		// psk_dhe_ke
+		tlsExtension{extPSKKeyExchangeModes, []byte{1, 1}},
+	)
+
+	if probe.supportedVersions != "" {
+		versions := []uint16{versionTLS10, versionTLS11, versionTLS12}
+		if probe.supportedVersions == "1.3" {
+			versions = append(versions, versionTLS13)
+		}
+		versions = jarmReorder(versions, probe.extensionOrder)
+		if probe.grease {
+			versions = append([]uint16{randomGREASE()}, versions...)
+		}
+		hello.extensions = append(hello.extensions, tlsExtension{extSupportedVersions, appendUint8LengthPrefixed(nil, func(b []byte) []byte {
+			for _, v := range versions {
+				b = appendUint16(b, v)
+			}
+			return b
+		})})
+	}
+
+	return appendHandshakeRecords(nil, recordVersion, hello.marshal())
+}
+
+// runJARMProbe 发送一次探测并读取服务器的第一条记录，按 JARM 的格式返回 “密码套件|版本|ALPN|扩展列表”。
+// 连接失败、服务器回应警报或者第一条消息不是 ServerHello 时返回 “|||”
+func runJARMProbe(addr, host string, probe jarmProbe) (string, error) {
+	conn, err := backendDialer.Dial("tcp", addr)
+	if err != nil {
+		return "|||", err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(jarmTimeout))
+
+	if _, err := conn.Write(buildJARMClientHello(probe, host)); err != nil {
+		return "|||", err
+	}
+
+	var headerBuf [recordproxy.HeaderLength]byte
+	header, _, err := recordproxy.ReadHeader(conn, headerBuf[:])
+	if err != nil {
+		return "|||", err
+	}
+	payload := make([]byte, header.Length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return "|||", err
+	}
+
+	switch {
+	case header.ContentType == contentTypeAlert && len(payload) == 2:
+		return "|||", fmt.Errorf("服务器回应了警报 %s", lookupName(ALERT_DESCRIPTION_TABLE, payload[1]))
+	case header.ContentType != contentTypeHandshake || len(payload) < 4 || payload[0] != handshakeTypeServerHello:
+		return "|||", fmt.Errorf("服务器的第一条记录不是 ServerHello")
+	}
+
+	msgLength := int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+	if len(payload) < 4+msgLength {
+		return "|||", fmt.Errorf("ServerHello 被拆成了多条记录")
+	}
+	hello, err := parseServerHello(payload[4 : 4+msgLength])
+	if err != nil {
+		return "|||", err
+	}
+
+	// 与 jarm.py 一致：版本取 legacy_version 而不是 supported_versions，ALPN 只取第一个协议
+	alpn := ""
+	if protocols := alpnProtocols(hello.extensions); len(protocols) > 0 {
+		alpn = protocols[0]
+	}
+	var types []string
+	for _, ext := range hello.extensions {
+		types = append(types, fmt.Sprintf("%04x", ext.extType))
+	}
+	return fmt.Sprintf("%04x|%04x|%s|%s", hello.cipherSuite, hello.legacyVersion, alpn, strings.Join(types, "-")), nil
+}
+
+// jarmHash 由十次探测的结果计算 62 个十六进制字符的 JARM 指纹：
+// 前 30 个字符依次是每次探测选定的密码套件（2 个字符）和版本（1 个字符），
+// 后 32 个字符是全部 ALPN 和扩展列表拼接后的 SHA-256 的前半部分。所有探测都失败时指纹全为 0
+func jarmHash(results []string) string {
+	allFailed := true
+	for _, result := range results {
+		allFailed = allFailed && result == "|||"
+	}
+	if allFailed {
+		return strings.Repeat("0", 62)
+	}
+
+	var fuzzy, alpnsAndExts strings.Builder
+	for _, result := range results {
+		parts := strings.Split(result, "|")
+		fuzzy.WriteString(jarmCipherByte(parts[0]))
+		fuzzy.WriteString(jarmVersionByte(parts[1]))
+		alpnsAndExts.WriteString(parts[2] + parts[3])
+	}
+	sum := sha256.Sum256([]byte(alpnsAndExts.String()))
+	return fuzzy.String() + hex.EncodeToString(sum[:])[:32]
+}
+
+func jarmCipherByte(cipher string) string {
+	if cipher == "" {
+		return "00"
+	}
+	index := len(jarmCipherIndex) + 1
+	for i, suite := range jarmCipherIndex {
+		if fmt.Sprintf("%04x", suite) == cipher {
+			index = i + 1
+			break
+		}
+	}
+	return fmt.Sprintf("%02x", index)
+}
+
+// jarmVersionByte 把版本的最后一位映射为一个字母：0x0300 为 a，0x0301 为 b……0x0304 为 e
+func jarmVersionByte(version string) string {
+	if len(version) != 4 || version[3] < '0' || version[3] > '5' {
+		return "0"
+	}
+	return string("abcdef"[version[3]-'0'])
+}
+
+// runJARM 依次发送十种 ClientHello，输出每次探测的结果和 addr 的 JARM 指纹
+func runJARM(addr string) {
+	host, _, err := net.SplitHostPort(addr)
+	panicIfErr(err, "runJARM")
+
+	logPrintf("JARM 探测 %s：\n", addr)
+	var results []string
+	for i, probe := range jarmProbes {
+		result, err := runJARMProbe(addr, host, probe)
+		results = append(results, result)
+		if err != nil {
+			logPrintf("  %2d. %s：%s（%v）\n", i+1, probe.name, result, err)
+		} else {
+			logPrintf("  %2d. %s：%s\n", i+1, probe.name, result)
+		}
+	}
+	logPrintf("JARM 指纹：%s\n", jarmHash(results))
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tls13DecryptSuites 是 -decrypt-keylog 能够解密的 TLS 1.3 密码套件。
+// 标准库没有公开 ChaCha20-Poly1305，因此不支持 TLS_CHACHA20_POLY1305_SHA256
+var tls13DecryptSuites = map[uint16]decryptSuite{
+	0x1301: {keyLen: 16, gcm: true, prfHash: sha256.New},    // TLS_AES_128_GCM_SHA256
+	0x1302: {keyLen: 32, gcm: true, prfHash: sha512.New384}, // TLS_AES_256_GCM_SHA384
+}
+
+// keyLog 是 -decrypt-keylog 指定的 NSS 格式密钥日志文件，即浏览器、curl 等按 SSLKEYLOGFILE 环境变量写出的文件。
+// 每行的格式为“标签 client_random 密钥”，后两者均为十六进制，# 开头的行是注释
+type keyLog struct {
+	path string
+
+	mu sync.Mutex
+	// 最近一次读取时文件的大小和修改时间，客户端边握手边追加写入，找不到密钥且文件有变化时才重新读取
+	size    int64
+	modTime time.Time
+	// 以“标签 client_random”为键的密钥
+	secrets map[string][]byte
+}
+
+// loadKeyLog 读取密钥日志文件。文件暂时为空也没有关系，之后找不到密钥时会重新读取
+func loadKeyLog(path string) (*keyLog, error) {
+	k := &keyLog{path: path}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// reload 重新读取整个文件，调用时需持有 mu
+func (k *keyLog) reload() error {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	k.secrets, _ = parseKeyLog(data)
+	k.size, k.modTime = info.Size(), info.ModTime()
+	return nil
+}
+
+// parseKeyLog 解析密钥日志的内容，返回其中的密钥以及格式不正确的行数
+func parseKeyLog(data []byte) (map[string][]byte, int) {
+	secrets := map[string][]byte{}
+	invalid := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			invalid++
+			continue
+		}
+		random, err1 := hex.DecodeString(fields[1])
+		secret, err2 := hex.DecodeString(fields[2])
+		if err1 != nil || err2 != nil || len(random) != 32 {
+			invalid++
+			continue
+		}
+		secrets[fields[0]+" "+hex.EncodeToString(random)] = secret
+	}
+	return secrets, invalid
+}
+
+// lookup 查找某个 client random 对应的密钥，找不到时返回 nil。
+// 找不到时会查看文件是否有变化并重新读取，因此不能在持有 connState.mu 时调用
+func (k *keyLog) lookup(label string, clientRandom []byte) []byte {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key := label + " " + hex.EncodeToString(clientRandom)
+	if secret, ok := k.secrets[key]; ok {
+		return secret
+	}
+	if info, err := os.Stat(k.path); err == nil && (info.Size() != k.size || !info.ModTime().Equal(k.modTime)) {
+		if err := k.reload(); err != nil {
+			logPrintf("-decrypt-keylog：无法重新读取 %s：%v\n", k.path, err)
+		}
+	}
+	return k.secrets[key]
+}
+
+// checkKeyLogFile 检查 -decrypt-keylog 指定的文件能否读取，其中的每一行格式是否正确
+func checkKeyLogFile(path string) error {
+	if path == "" {
+		return errNotSet
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, invalid := parseKeyLog(data); invalid > 0 {
+		return fmt.Errorf("有 %d 行不是“标签 client_random 密钥”的格式", invalid)
+	}
+	return nil
+}
+
+// hkdfExpandLabel 是 TLS 1.3 的 HKDF-Expand-Label（RFC 8446 7.1）
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, context []byte, n int) []byte {
+	info := appendUint16(nil, uint16(n))
+	info = appendUint8LengthPrefixed(info, func(b []byte) []byte {
+		return append(append(b, "tls13 "...), label...)
+	})
+	info = appendUint8LengthPrefixed(info, func(b []byte) []byte {
+		return append(b, context...)
+	})
+
+	// HKDF-Expand（RFC 5869 2.3）：T(i) = HMAC(secret, T(i-1) | info | i)
+	mac := hmac.New(h, secret)
+	var out, t []byte
+	for i := byte(1); len(out) < n; i++ {
+		mac.Reset()
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:n]
+}
+
+// newTLS13Decryptor 由一个流量密钥算出记录密钥和 IV（RFC 8446 7.3），序列号从 0 开始
+func newTLS13Decryptor(suite decryptSuite, trafficSecret []byte) (*recordDecryptor, error) {
+	d := &recordDecryptor{suite: suite, source: "-decrypt-keylog", trafficSecret: trafficSecret}
+	d.keys.key = hkdfExpandLabel(suite.prfHash, trafficSecret, "key", nil, suite.keyLen)
+	d.keys.iv = hkdfExpandLabel(suite.prfHash, trafficSecret, "iv", nil, 12)
+	var err error
+	if d.block, err = aes.NewCipher(d.keys.key); err != nil {
+		return nil, err
+	}
+	if d.aead, err = cipher.NewGCM(d.block); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// nextGeneration 返回 KeyUpdate 之后使用下一代流量密钥的解密器（RFC 8446 7.2）
+func (d *recordDecryptor) nextGeneration() *recordDecryptor {
+	secret := hkdfExpandLabel(d.suite.prfHash, d.trafficSecret, "traffic upd", nil, d.suite.prfHash().Size())
+	next, err := newTLS13Decryptor(d.suite, secret)
+	if err != nil {
+		return nil
+	}
+	return next
+}
+
+// decrypt13 解密一条 TLS 1.3 记录。nonce 是 IV 与序列号的异或，AAD 是记录层头部，
+// 明文末尾是真正的内容类型和可选的全零填充（RFC 8446 5.2、5.3）
+func (d *recordDecryptor) decrypt13(contentType byte, version uint16, payload []byte) (byte, []byte, error) {
+	if contentType != contentTypeApplicationData {
+		return 0, nil, fmt.Errorf("TLS 1.3 加密记录的外层内容类型应为 application_data，实际为 %d", contentType)
+	}
+	nonce := append([]byte(nil), d.keys.iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(d.seqNum >> (8 * i))
+	}
+	aad := appendUint16(appendUint16([]byte{contentType}, version), uint16(len(payload)))
+	plain, err := d.aead.Open(nil, nonce, payload, aad)
+	if err != nil {
+		return 0, nil, err
+	}
+	end := len(plain)
+	for end > 0 && plain[end-1] == 0 {
+		end--
+	}
+	if end == 0 {
+		return 0, nil, errors.New("明文中只有填充，没有内容类型")
+	}
+	return plain[end-1], plain[:end-1], nil
+}
+
+// explainKeyLogDecryptability 在看到 ServerHello 后说明 -decrypt-keylog 能否解密这条连接，调用时需持有 mu
+func (s *connState) explainKeyLogDecryptability(fromClient bool) {
+	name := formatCipherSuite(s.cipherSuite)
+	switch s.version {
+	case versionTLS13:
+		if _, ok := tls13DecryptSuites[s.cipherSuite]; !ok {
+			s.logf(fromClient, "-decrypt-keylog：暂不支持解密密码套件 %s，TLS 1.3 只支持 AES-GCM 的套件", name)
+		} else if s.offeredEarlyData {
+			s.logf(fromClient, "-decrypt-keylog：不会解密 0-RTT 早期数据，服务器接受早期数据时，客户端在 EndOfEarlyData 之前的记录将无法解密")
+		}
+	case versionTLS12:
+		if _, ok := tls12DecryptSuites[s.cipherSuite]; !ok {
+			s.logf(fromClient, "-decrypt-keylog：暂不支持解密密码套件 %s，TLS 1.2 只支持 AES-CBC 和 AES-GCM 的套件", name)
+		}
+	default:
+		s.logf(fromClient, "-decrypt-keylog：只支持解密 TLS 1.2 和 TLS 1.3，本连接为 %s", formatVersion(s.version))
+	}
+}
+
+// supportsKeyLogDecryption 判断 -decrypt-keylog 是否支持这条连接的版本和密码套件
+func (s *connState) supportsKeyLogDecryption() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.version {
+	case versionTLS13:
+		_, ok := tls13DecryptSuites[s.cipherSuite]
+		return ok
+	case versionTLS12:
+		_, ok := tls12DecryptSuites[s.cipherSuite]
+		return ok
+	}
+	return false
+}
+
+// keyLogLabel 返回为该方向创建解密器需要在密钥日志中查找的标签：TLS 1.2 为主密钥的 CLIENT_RANDOM，
+// TLS 1.3 为握手流量密钥（application 为 false）或第一代应用流量密钥。
+// 没有指定 -decrypt-keylog、已经有了 TLS 1.2 的记录密钥或不支持该版本和密码套件时返回空字符串，调用时需持有 mu
+func (s *connState) keyLogLabel(fromClient, application bool) string {
+	if config.keyLog == nil {
+		return ""
+	}
+	switch s.version {
+	case versionTLS12:
+		if _, ok := tls12DecryptSuites[s.cipherSuite]; !ok || s.keys != nil {
+			return ""
+		}
+		return "CLIENT_RANDOM"
+	case versionTLS13:
+		if _, ok := tls13DecryptSuites[s.cipherSuite]; !ok {
+			return ""
+		}
+		label := "HANDSHAKE_TRAFFIC_SECRET"
+		if application {
+			label = "TRAFFIC_SECRET_0"
+		}
+		if fromClient {
+			return "CLIENT_" + label
+		}
+		return "SERVER_" + label
+	}
+	return ""
+}
+
+// deriveKeysFromKeyLog 用在密钥日志中找到的主密钥为 TLS 1.2 连接展开出记录密钥，调用时需持有 mu
+func (s *connState) deriveKeysFromKeyLog(fromClient bool, master []byte) {
+	suite, ok := tls12DecryptSuites[s.cipherSuite]
+	if !ok || s.version != versionTLS12 {
+		return
+	}
+	if len(master) != 48 {
+		s.logf(fromClient, "-decrypt-keylog：主密钥的长度为 %d 字节，应为 48 字节", len(master))
+		return
+	}
+	s.logf(fromClient, "-decrypt-keylog：在密钥日志中找到了主密钥")
+	s.keys = newSessionKeys12(suite, master, s.clientRandom, s.serverRandom, s.encryptThenMAC, "-decrypt-keylog")
+}
+
+// tls13Decryptor 用在密钥日志中找到的流量密钥 secret 创建某个方向的解密器，
+// secret 为 nil（可能是对端还没有写入）或不支持该密码套件时返回 nil，调用时需持有 mu
+func (s *connState) tls13Decryptor(fromClient bool, secret []byte) *recordDecryptor {
+	suite, ok := tls13DecryptSuites[s.cipherSuite]
+	if !ok || secret == nil {
+		return nil
+	}
+	d, err := newTLS13Decryptor(suite, secret)
+	if err != nil {
+		s.logf(fromClient, "-decrypt-keylog：%v", err)
+		return nil
+	}
+	return d
+}
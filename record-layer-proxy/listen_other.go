@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// listenTCPWithBacklog 在 addr 上监听。非 Unix 平台不支持指定监听队列的长度，backlog 大于 0 时返回错误
+func listenTCPWithBacklog(addr *net.TCPAddr, backlog int) (*net.TCPListener, error) {
+	if backlog > 0 {
+		return nil, errors.New("当前平台不支持 -listen-backlog")
+	}
+	return net.ListenTCP("tcp4", addr)
+}
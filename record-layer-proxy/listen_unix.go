@@ -0,0 +1,61 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenTCPWithBacklog 在 addr 上监听，并把监听队列（已完成三次握手、等待 Accept 的连接）的长度设为 backlog。
+// net.ListenTCP 总是使用系统的最大值（Linux 上读取 net.core.somaxconn），无法指定，
+// 而且 net.ListenConfig 的 Control 函数在 listen(2) 之前调用，在其中设置也会被覆盖，
+// 因此这里直接用系统调用创建套接字，再通过 net.FileListener 包装成 *net.TCPListener。
+// backlog 不大于 0 时等同于 net.ListenTCP
+func listenTCPWithBacklog(addr *net.TCPAddr, backlog int) (*net.TCPListener, error) {
+	if backlog <= 0 {
+		return net.ListenTCP("tcp4", addr)
+	}
+
+	ip := net.IPv4zero.To4()
+	if addr.IP != nil {
+		ip = addr.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("%s 不是 IPv4 地址", addr.IP)
+		}
+	}
+	sockaddr := &syscall.SockaddrInet4{Port: addr.Port}
+	copy(sockaddr.Addr[:], ip)
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	syscall.CloseOnExec(fd)
+
+	// 与 net.ListenTCP 一样设置 SO_REUSEADDR，代理重启时不必等待旧连接的 TIME_WAIT 结束
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.Bind(fd, sockaddr); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	// 内核会把过大的 backlog 截断为 net.core.somaxconn
+	if err := syscall.Listen(fd, backlog); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("listen", err)
+	}
+
+	// FileListener 会复制一份文件描述符，原来的需要关闭
+	file := os.NewFile(uintptr(fd), "listener")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, err
+	}
+	return listener.(*net.TCPListener), nil
+}
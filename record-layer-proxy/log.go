@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Logger 是代理输出日志的目标，每次调用 Log 传入一段完整的日志（一行或多行，以换行结尾）。
+// 标准输出、-log-file 的滚动日志文件和测试中收集日志的缓冲区都通过它接入。
+// Log 总是在持有 logMu 时被调用，实现无需自行加锁，但也不能在其中再输出代理的日志
+type Logger interface {
+	Log(s string)
+}
+
+// writerLogger 把日志原样写入一个 io.Writer
+type writerLogger struct {
+	w io.Writer
+}
+
+func (l writerLogger) Log(s string) {
+	_, _ = io.WriteString(l.w, s)
+}
+
+// logMu 保护 config.logger 本身以及对它的调用，以免两个方向的 goroutine 输出的行交错在一起
+var logMu sync.Mutex
+
+func logPrintf(format string, args ...any) {
+	logPrint(fmt.Sprintf(format, args...))
+}
+
+func logPrint(s string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	config.logger.Log(s)
+}
+
+// logDetailf 输出逐条记录、逐条握手消息的详细信息，-summary-only 模式下不输出
+func logDetailf(format string, args ...any) {
+	if config.summaryOnly {
+		return
+	}
+	logPrintf(format, args...)
+}
+
+// shouldLogContentType 判断 -only 是否允许输出该内容类型的记录
+func shouldLogContentType(contentType byte) bool {
+	return config.onlyContentTypes == nil || config.onlyContentTypes[contentType]
+}
+
+// setLogger 替换日志输出目标，并返回原来的输出目标
+func setLogger(l Logger) Logger {
+	logMu.Lock()
+	defer logMu.Unlock()
+	old := config.logger
+	config.logger = l
+	return old
+}
+
+// setLogOutput 把日志输出目标替换为 w，并返回原来的输出目标
+func setLogOutput(w io.Writer) Logger {
+	return setLogger(writerLogger{w})
+}
+
+// truncateForLog 截取 data 的前 limit 字节用于输出，返回截取后的数据，以及应附加在输出末尾的说明（如 “…(+100 字节)”）。
+// limit 不大于 0 或 data 不超过 limit 时不截取，说明为空
+func truncateForLog(data []byte, limit int) ([]byte, string) {
+	if limit <= 0 || len(data) <= limit {
+		return data, ""
+	}
+	return data[:limit], fmt.Sprintf("…(+%d 字节)", len(data)-limit)
+}
@@ -1,77 +1,28 @@
 package main
 
 import (
-	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ipid/learn-tls/record-layer-proxy/recordproxy"
 )
 
-var CONTENT_TYPE_TABLE = map[byte]string{
-	0:  "Invalid",
-	20: "Change Cipher Spec",
-	21: "Alert",
-	22: "Handshake",
-	23: "Application Data",
-}
-
-var HANDSHAKE_TYPE_TABLE = map[byte]string{
-	0:   "Hello Request",
-	1:   "Client Hello",
-	2:   "Server Hello",
-	4:   "New Session Ticket",
-	5:   "End Of Early Data",
-	8:   "Encrypted Extensions",
-	11:  "Certificate",
-	12:  "Server Key Exchange",
-	13:  "Certificate Request",
-	14:  "Server Hello Done",
-	15:  "Certificate Verify",
-	16:  "Client Key Exchange",
-	20:  "Finished",
-	24:  "Key Update",
-	254: "Message Hash",
-}
-
-var ALERT_LEVEL_TABLE = map[byte]string{
-	1: "Warning",
-	2: "Fatal",
-}
-
-var ALERT_DESCRIPTION_TABLE = map[byte]string{
-	0:   "Close Notify",
-	10:  "Unexpected Message",
-	20:  "Bad Record MAC",
-	22:  "Record Overflow",
-	30:  "Decompression Failure",
-	40:  "Handshake Failure",
-	41:  "No Certificate",
-	42:  "Bad Certificate",
-	43:  "Unsupported Certificate",
-	44:  "Certificate Revoked",
-	45:  "Certificate Expired",
-	46:  "Certificate Unknown",
-	47:  "Illegal Parameter",
-	48:  "Unknown CA",
-	49:  "Access Denied",
-	50:  "Decode Error",
-	51:  "Decrypt Error",
-	60:  "Export Restriction",
-	70:  "Protocol Version",
-	71:  "Insufficient Security",
-	80:  "Internal Error",
-	86:  "Inappropriate Fallback",
-	90:  "User Canceled",
-	100: "No Renegotiation",
-	109: "Missing Extension",
-	110: "Unsupported Extension",
-	112: "Unrecognized Name",
-	113: "Bad Certificate Status Response",
-	115: "Unknown PSK Identity",
-	116: "Certificate Required",
-	120: "No Application Protocol",
-}
+const (
+	// RFC 8446 5.1 规定明文记录的长度最大为 16384
+	maxPlaintextLength = 16384
+	// RFC 5246 6.2.3 规定加密后的记录最多比明文长 2048 字节
+	maxCiphertextLength = maxPlaintextLength + 2048
+)
 
 func panicIfErr(err error, funcName string) {
 	if err != nil {
@@ -79,49 +30,286 @@ func panicIfErr(err error, funcName string) {
 	}
 }
 
-func copyDataFromConnToConn(from, to *net.TCPConn) {
+// writeFull 将 data 完整写入 to。
+// net.TCPConn 的 Write 在没有出错时不会少写，但这里仍然显式检查写入的字节数，
+// 这样即使以后换成别的 io.Writer，也不会在不知情的情况下转发出残缺的记录
+func writeFull(to io.Writer, data []byte) error {
+	n, err := to.Write(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return fmt.Errorf("只写入了 %d/%d 字节：%w", n, len(data), io.ErrShortWrite)
+	}
+	return nil
+}
+
+func copyDataFromConnToConn(from, to *net.TCPConn, state *connState, fromClient bool) {
 	recordLayerHeader := make([]byte, 5)
-	buf := make([]byte, 16384+5)
+	buf := make([]byte, maxCiphertextLength)
+	label := state.directionLabel(fromClient)
+	dir := newDirectionState(state, fromClient)
+
+	var helloBuffer *clientHelloBuffer
+	if fromClient && config.needsHelloBuffer() {
+		helloBuffer = &clientHelloBuffer{}
+		// 客户端迟迟不发完 ClientHello 时，读取会超时，避免连接一直占用在缓存阶段
+		_ = from.SetReadDeadline(time.Now().Add(config.helloTimeout))
+	}
+	if fromClient {
+		state.startHandshakeWatchdog()
+		defer state.stopHandshakeWatchdog()
+	}
+
+	// 导致循环结束的读写错误，连接关闭时据此说明原因
+	var readErr, writeErr error
+
+	// 已经读到的记录数，以及结束时是否一个字节都没有读到
+	records := 0
+	closedBeforeAnyRecord := false
 
 	for {
-		_, err := io.ReadFull(from, recordLayerHeader)
+		header, n, err := recordproxy.ReadHeader(from, recordLayerHeader)
 		if err != nil {
+			if helloBuffer != nil && !helloBuffer.done && errors.Is(err, os.ErrDeadlineExceeded) {
+				logPrintf(
+					"[copyDataFromConnToConn %s] %s 内没有收到完整的 ClientHello，放弃该连接\n",
+					label,
+					config.helloTimeout,
+				)
+				state.closeBoth()
+			}
+			readErr = err
+			closedBeforeAnyRecord = records == 0 && n == 0
 			break
 		}
+		records++
+		dir.gapStats.observe(time.Now())
 
 		// 读取 record layer 的长度
-		currentRecordLength := binary.BigEndian.Uint16(recordLayerHeader[3:5])
-		if currentRecordLength > 16384 {
-			// RFC 8446 5.1 规定 record layer 的长度最大为 16384
-			break
+		currentRecordLength := header.Length
+		if config.maxHandshakeSize > 0 {
+			// 在读取载荷之前判断，对端一点点地发送无穷无尽的握手消息时，代理不会为此读取和缓存更多数据
+			if total := state.addHandshakeBytes(header.ContentType, currentRecordLength); total > config.maxHandshakeSize {
+				logPrintf(
+					"[copyDataFromConnToConn %s] 握手完成前的握手数据累计 %d 字节，超过了 -max-handshake-size 的限制（%d），关闭连接\n",
+					label,
+					total,
+					config.maxHandshakeSize,
+				)
+				state.addWarning(fromClient, fmt.Sprintf("握手数据超过了 -max-handshake-size 的限制（%d 字节）", config.maxHandshakeSize))
+				state.closeBoth()
+				break
+			}
+		}
+		if maxLength := state.maxRecordLength(header.ContentType); currentRecordLength > maxLength {
+			if config.strict {
+				state.abortStrict(from, to, fromClient, alertRecordOverflow, fmt.Sprintf("记录层长度超限：%d > %d", currentRecordLength, maxLength))
+				break
+			}
+			// 超长的记录不转发，但要把它完整读掉，这样后续的记录仍然能够正确分帧
+			logDetailf(
+				"[copyDataFromConnToConn %s] 协议违规：记录层长度超限：%d > %d，已丢弃该记录\n",
+				label,
+				currentRecordLength,
+				maxLength,
+			)
+			state.addWarning(fromClient, fmt.Sprintf("协议违规：记录层长度超限：%d > %d", currentRecordLength, maxLength))
+			_, err = io.CopyN(io.Discard, from, int64(currentRecordLength))
+			if err != nil {
+				readErr = err
+				break
+			}
+			continue
 		}
 
 		_, err = io.ReadFull(from, buf[:currentRecordLength])
 		if err != nil {
+			readErr = err
+			break
+		}
+
+		if limit := state.peerRecordSizeLimit(fromClient); limit != 0 && currentRecordLength > limit+256 {
+			// record_size_limit 限制的是明文长度，受保护的记录最多再多出 256 字节，这里按此宽松判断
+			logDetailf(
+				"[copyDataFromConnToConn %s] 协议违规：记录层长度 %d 超出了对端通告的 record_size_limit（%d）\n",
+				label,
+				currentRecordLength,
+				limit,
+			)
+			state.addWarning(fromClient, fmt.Sprintf("协议违规：记录层长度 %d 超出了对端通告的 record_size_limit（%d）", currentRecordLength, limit))
+			if config.strict {
+				state.abortStrict(from, to, fromClient, alertRecordOverflow, fmt.Sprintf("记录层长度 %d 超出了对端通告的 record_size_limit（%d）", currentRecordLength, limit))
+				break
+			}
+		}
+
+		if state.tapSink != nil {
+			if err := state.writeTapSink(recordLayerHeader, buf[:currentRecordLength]); err != nil {
+				logPrintf("[copyDataFromConnToConn %s] 写入 -tap-sink 失败，此后不再镜像该连接：%v\n", label, err)
+			}
+		}
+
+		if state.capture != nil {
+			record := append(append([]byte(nil), recordLayerHeader...), buf[:currentRecordLength]...)
+			if err := state.capture.writeRecord(fromClient, record); err != nil {
+				logPrintf("[copyDataFromConnToConn %s] 写入捕获文件失败：%v\n", label, err)
+			}
+		}
+
+		version := header.Version
+		// 必须在 observeRecord 拼接握手数据之前描述，才能知道记录开头是否是上一条消息的后续部分
+		handshakeInfo := ""
+		if header.ContentType == contentTypeHandshake {
+			handshakeInfo = dir.describeHandshakeRecord(buf[:currentRecordLength])
+		}
+		if config.hexdump && shouldLogContentType(header.ContentType) {
+			record := append(append([]byte(nil), recordLayerHeader...), buf[:currentRecordLength]...)
+			shown, more := truncateForLog(record, config.maxRecordLogBytes)
+			if more != "" {
+				more += "\n"
+			}
+			logDetailf(
+				"[hexdump %s] %d 字节\n%s%s",
+				label,
+				len(record),
+				hex.Dump(shown),
+				more,
+			)
+		}
+		if config.tree && shouldLogContentType(header.ContentType) {
+			// 要在 observeRecord 之前判断，因为 ChangeCipherSpec 之后的握手记录才是加密的
+			logDetailf(
+				"[tree %s]\n%s",
+				label,
+				recordTree(header.ContentType, version, buf[:currentRecordLength], dir.encrypted),
+			)
+		}
+		if config.maxIdleRecords > 0 {
+			steps := state.handshakeSteps()
+			dir.observeRecord(header.ContentType, version, buf[:currentRecordLength])
+			state.checkIdleRecord(steps)
+		} else {
+			dir.observeRecord(header.ContentType, version, buf[:currentRecordLength])
+		}
+		if config.strict {
+			if v := state.takeStrictViolation(); v != nil {
+				state.abortStrict(from, to, v.fromClient, v.alert.description, v.reason)
+				break
+			}
+		}
+
+		if config.versionPolicy != nil && !fromClient && header.ContentType == contentTypeHandshake {
+			state.mu.Lock()
+			chosen := state.version
+			state.mu.Unlock()
+			if allowed, reason := config.versionPolicy.checkServer(chosen); chosen != 0 && !allowed {
+				// ServerHello 不再转发给客户端，代理代替客户端向服务器、代替服务器向客户端各发送一条警报
+				alert := config.versionPolicy.alert
+				logPrintf("[copyDataFromConnToConn %s] 版本策略拒绝了该连接：%s，向双方发送警报 %s 并断开\n", label, reason, alert)
+				state.addWarning(fromClient, "版本策略拒绝了该连接："+reason)
+				_ = state.writeTo(from, alert.record())
+				_ = state.writeTo(to, alert.record())
+				state.closeBoth()
+				break
+			}
+		}
+
+		if helloBuffer != nil && !helloBuffer.done && len(helloBuffer.buf) > 0 && header.ContentType != contentTypeHandshake {
+			// RFC 8446 5.1：握手消息的分片之间不能夹杂其他类型的记录。缓存的 ClientHello 尚未转发，
+			// 这条记录若先转发出去，服务器收到的顺序就与客户端发送的不同，因此直接拒绝该连接
+			reject := alertSpec{alertLevelFatal, alertUnexpectedMessage}
+			logPrintf(
+				"[copyDataFromConnToConn %s] ClientHello 尚不完整时收到了 %s (%d) 记录，向客户端发送警报 %s 并断开，ClientHello 未转发\n",
+				label,
+				lookupName(CONTENT_TYPE_TABLE, header.ContentType),
+				header.ContentType,
+				reject,
+			)
+			state.addWarning(fromClient, fmt.Sprintf("协议违规：ClientHello 尚不完整时收到了 %s 记录", lookupName(CONTENT_TYPE_TABLE, header.ContentType)))
+			_ = state.writeTo(from, reject.record())
+			state.closeBoth()
 			break
 		}
 
-		_, err = to.Write(recordLayerHeader)
-		_, err1 := to.Write(buf[:currentRecordLength])
+		// 日志中的长度，转发缓存的 ClientHello 时是实际转发的（可能改写过的）各条记录的长度
+		lengthInfo := strconv.Itoa(currentRecordLength)
+		// 不为 nil 时这条记录使缓存的 ClientHello 完整，转发的是其中的全部记录
+		var flushedHello []recordproxy.Record
+		if helloBuffer != nil && !helloBuffer.done && header.ContentType == contentTypeHandshake {
+			decision, pushErr := helloBuffer.push(version, buf[:currentRecordLength], config.maxHelloSize)
+			if pushErr != nil {
+				logPrintf("[copyDataFromConnToConn %s] 放弃缓存 ClientHello 并断开连接：%v\n", label, pushErr)
+				state.closeBoth()
+				break
+			}
+			if decision == nil {
+				logDetailf(
+					"[copyDataFromConnToConn %s] ClientHello 尚不完整，已缓存 %d 字节，等待后续记录\n",
+					label,
+					len(helloBuffer.buf),
+				)
+				continue
+			}
+			_ = from.SetReadDeadline(time.Time{})
+			for _, note := range decision.notes {
+				logDetailf("[copyDataFromConnToConn %s] 处理 ClientHello：%s\n", label, note)
+			}
 
-		if err != nil || err1 != nil {
+			if decision.reject != nil {
+				if err := state.writeTo(from, decision.reject.record()); err != nil {
+					logPrintf("[copyDataFromConnToConn %s] 向客户端发送警报失败：%v\n", label, err)
+				}
+				logPrintf("[copyDataFromConnToConn %s] 已拒绝该连接，ClientHello 未转发\n", label)
+				state.closeBoth()
+				break
+			}
+			err = state.writeTo(to, decision.records)
+			flushedHello, _ = recordproxy.SplitRecords(decision.records)
+			// 记录开头的描述针对的是客户端发来的最后一条记录，这里改为描述实际转发的（可能改写过的）消息
+			if msg := flushedHello[0].Payload; len(msg) >= 4 {
+				handshakeInfo = fmt.Sprintf("，握手消息：%s (%d)，长度 %d", lookupName(HANDSHAKE_TYPE_TABLE, msg[0]), msg[0], int(msg[1])<<16|int(msg[2])<<8|int(msg[3]))
+			}
+			lengthInfo = describeForwardedLengths(flushedHello, helloBuffer.recordLengths)
+		} else {
+			err = state.writeTo(to, recordLayerHeader, buf[:currentRecordLength])
+		}
+
+		if err != nil {
+			if errors.Is(err, io.ErrShortWrite) {
+				logPrintf(
+					"[copyDataFromConnToConn %s] 写入不完整，放弃该连接：%v\n",
+					label,
+					err,
+				)
+			}
+			writeErr = err
 			break
 		}
 
-		version := binary.BigEndian.Uint16(recordLayerHeader[1:3])
-		contentType, hasType := CONTENT_TYPE_TABLE[recordLayerHeader[0]]
+		if flushedHello != nil {
+			// 缓存期间的记录都没有计入统计，这里按实际转发的各条记录补上
+			for _, record := range flushedHello {
+				countForwardedRecord(state, dir, record.ContentType, recordproxy.HeaderLength+record.Length)
+			}
+		} else {
+			countForwardedRecord(state, dir, header.ContentType, len(recordLayerHeader)+currentRecordLength)
+		}
+
+		contentType, hasType := CONTENT_TYPE_TABLE[header.ContentType]
 		if !hasType {
 			contentType = "未知"
 		}
 
 		extraInfo := ""
 		if contentType == "Handshake" {
-			handshakeType, hasType := HANDSHAKE_TYPE_TABLE[buf[0]]
-			if !hasType {
-				handshakeType = "未知"
+			extraInfo = handshakeInfo
+		} else if contentType == "Alert" && dir.encrypted {
+			// 与握手记录相同，ChangeCipherSpec 之后的警报是加密的，前两个字节不是级别和描述
+			extraInfo = "，加密的警报"
+			if config.inspectAfterCCS {
+				extraInfo += "；" + inspectEncryptedRecord(header.ContentType, buf[:currentRecordLength])
 			}
-			handshakeLength := uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
-			extraInfo = fmt.Sprintf("，握手类型：%s (%d)，握手长度：%d", handshakeType, buf[0], handshakeLength)
 		} else if contentType == "Alert" {
 			alertLevel, hasType := ALERT_LEVEL_TABLE[buf[0]]
 			if !hasType {
@@ -134,64 +322,923 @@ func copyDataFromConnToConn(from, to *net.TCPConn) {
 			extraInfo = fmt.Sprintf("，警报级别：%s (%d)，警报描述：%s (%d)", alertLevel, buf[0], alertDescription, buf[1])
 		}
 
-		fmt.Printf(
-			"[copyDataFromConnToConn %s --> %s] 转发了记录层数据，内容类型：%s (%d)，版本：0x%04X，长度：%d%s\n",
-			from.RemoteAddr(),
-			to.RemoteAddr(),
-			contentType,
-			recordLayerHeader[0],
-			version,
-			currentRecordLength,
-			extraInfo,
-		)
+		if shouldLogContentType(header.ContentType) {
+			logDetailf(
+				"[copyDataFromConnToConn %s] 转发了记录层数据，内容类型：%s (%d)，版本：%s，长度：%s%s\n",
+				label,
+				contentType,
+				header.ContentType,
+				formatVersion(version),
+				lengthInfo,
+				extraInfo,
+			)
+		}
+
+		if config.noForward && state.isHandshakeComplete() {
+			logDetailf(
+				"[copyDataFromConnToConn %s] 握手已完成，-no-forward 模式下不再转发应用数据，关闭连接\n",
+				label,
+			)
+			state.closeBoth()
+			break
+		}
+
+		if config.limitRecords > 0 && records >= config.limitRecords {
+			if config.limitRecordsClose {
+				logDetailf("[copyDataFromConnToConn %s] 已转发 %d 条记录，达到 -limit-records 的限制，关闭连接\n", label, records)
+				state.closeBoth()
+				break
+			}
+			logDetailf("[copyDataFromConnToConn %s] 已转发 %d 条记录，达到 -limit-records 的限制，此后不再解析，原样转发\n", label, records)
+			rawForward(from, to, state, fromClient)
+			return
+		}
+	}
+
+	_ = from.CloseRead()
+	_ = to.CloseWrite()
+
+	reason := "代理主动结束了转发"
+	if closedBeforeAnyRecord {
+		// 常见于端口扫描和负载均衡器的健康检查：建立 TCP 连接后什么都不发就关闭
+		reason = "连接在发送任何 TLS 记录前关闭，" + describeConnError(readErr)
+	} else if readErr != nil {
+		reason = "读取时" + describeConnError(readErr)
+	} else if writeErr != nil {
+		reason = "写入时" + describeConnError(writeErr)
+	}
+	if errors.Is(readErr, syscall.ECONNRESET) {
+		// 正常关闭是发送 FIN，RST 通常意味着对端进程崩溃、主动中止连接，或者收到了它无法处理的数据
+		state.addWarning(fromClient, "重置了连接（RST），而不是正常关闭（FIN）")
+	}
+	logDetailf(
+		"[copyDataFromConnToConn %s] 连接已关闭：%s\n",
+		label,
+		reason,
+	)
+}
+
+// describeConnError 说明读写连接时遇到的错误属于哪种情况。
+// err 为 nil 表示对端正常关闭（io.Copy 读到 EOF 时返回 nil）
+func describeConnError(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+		return "对端正常关闭了连接（收到 FIN）"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "对端在一条记录传输到一半时关闭了连接"
+	case errors.Is(err, net.ErrClosed):
+		return "连接已在本地关闭（另一方向先结束或代理主动断开）"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "连接被对端重置（RST）"
+	case errors.Is(err, syscall.EPIPE):
+		return "对端已不再接收数据（broken pipe）"
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Sprintf("超时：%v", err)
+	}
+	return fmt.Sprintf("出现错误：%v", err)
+}
+
+// countForwardedRecord 把一条已转发的记录（length 含记录层头部）计入 -stats、连接摘要和流量统计
+func countForwardedRecord(state *connState, dir *directionState, contentType byte, length int) {
+	statRecordsByType[contentType].Add(1)
+	dir.countRecord(contentType, length)
+	statBytes.Add(int64(length))
+	state.addBytes(dir.fromClient, int64(length))
+}
+
+// describeDialError 把连接后端时的错误转换成易读的原因，区分被拒绝、超时和不可达等情况
+func describeDialError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("域名解析失败：%v", err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Sprintf("后端拒绝连接（端口没有程序监听，对方回复了 RST）：%v", err)
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return fmt.Sprintf("后端主机或网络不可达：%v", err)
+	case errors.Is(err, syscall.ECONNRESET):
+		return fmt.Sprintf("连接在建立过程中被重置（RST）：%v", err)
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Sprintf("连接超时（%s 内没有收到 SYN-ACK，可能被防火墙丢弃）：%v", backendDialer.Timeout, err)
 	}
+	return fmt.Sprintf("出现错误：%v", err)
+}
+
+// backendDialer 用于连接后端。后端主机名同时解析出 IPv4 和 IPv6 地址时，net.Dialer 会按
+// RFC 8305（Happy Eyeballs）的做法先连接首选地址族，若 FallbackDelay 内还没连上，
+// 就同时尝试另一地址族，先建立的连接胜出，其余的连接会被取消
+var backendDialer = net.Dialer{
+	Timeout: 10 * time.Second,
+	// RFC 8305 5 建议的 Connection Attempt Delay 为 250ms
+	FallbackDelay: 250 * time.Millisecond,
+}
+
+// rawForward 不解析记录，直接把 from 的数据原样转发给 to
+func rawForward(from, to *net.TCPConn, state *connState, fromClient bool) {
+	n, err := rawCopy(from, to)
+	statBytes.Add(n)
+	state.addBytes(fromClient, n)
 
 	_ = from.CloseRead()
 	_ = to.CloseWrite()
-	fmt.Printf(
-		"[copyDataFromConnToConn %s --> %s] 连接已关闭\n",
-		from.RemoteAddr(),
-		to.RemoteAddr(),
+	logDetailf(
+		"[rawForward %s] 连接已关闭：%s，共转发 %d 字节\n",
+		state.directionLabel(fromClient),
+		describeConnError(err),
+		n,
 	)
 }
 
-func handleNewIncomingConn(inConn *net.TCPConn, remoteAddr *net.TCPAddr) {
-	outConn, err := net.DialTCP("tcp4", nil, remoteAddr)
+// countForward 用于 -count-only 模式：只按记录层头部分帧并计数，不解析记录内容，也不输出逐条记录的信息。
+// 开销介于 rawForward 与 copyDataFromConnToConn 之间，连接关闭时由 printCountTally 输出计数
+func countForward(from, to *net.TCPConn, state *connState, fromClient bool) {
+	headerBuf := make([]byte, recordproxy.HeaderLength)
+	buf := make([]byte, maxCiphertextLength)
+	dir := newDirectionState(state, fromClient)
+
+	var err error
+	for {
+		var header recordproxy.Header
+		if header, _, err = recordproxy.ReadHeader(from, headerBuf); err != nil {
+			break
+		}
+		dir.gapStats.observe(time.Now())
+		length := header.Length
+		if length > maxCiphertextLength {
+			// 分帧已经无法继续，剩余的数据原样转发，以免中断连接
+			_ = state.writeTo(to, headerBuf)
+			rawForward(from, to, state, fromClient)
+			return
+		}
+		if _, err = io.ReadFull(from, buf[:length]); err != nil {
+			break
+		}
+		if err = state.writeTo(to, headerBuf, buf[:length]); err != nil {
+			break
+		}
+		countForwardedRecord(state, dir, header.ContentType, recordproxy.HeaderLength+length)
+	}
+	_ = from.CloseRead()
+	_ = to.CloseWrite()
+}
+
+// firstHelloForward 用于 -first-record-only 模式：逐条转发客户端的记录，直到拼出完整的第一条 ClientHello，
+// 详细输出它的内容后，把连接的剩余部分交给 rawForward 原样转发。服务器方向直接原样转发
+func firstHelloForward(from, to *net.TCPConn, state *connState, fromClient bool) {
+	if !fromClient {
+		rawForward(from, to, state, fromClient)
+		return
+	}
+	label := state.directionLabel(fromClient)
+
+	var recordLayerHeader [5]byte
+	var msg []byte
+	for {
+		header, _, err := recordproxy.ReadHeader(from, recordLayerHeader[:])
+		if err != nil {
+			break
+		}
+		length := header.Length
+		if length > maxCiphertextLength {
+			logPrintf("[firstHelloForward %s] 记录层长度超限：%d，不是 TLS 流量？\n", label, length)
+			_ = state.writeTo(to, recordLayerHeader[:])
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(from, payload); err != nil {
+			break
+		}
+		if state.writeTo(to, recordLayerHeader[:], payload) != nil {
+			break
+		}
+		state.addBytes(true, int64(len(recordLayerHeader)+length))
+
+		if header.ContentType != contentTypeHandshake {
+			logPrintf("[firstHelloForward %s] 第一条记录不是握手记录（内容类型 %d）\n", label, header.ContentType)
+			break
+		}
+		msg = append(msg, payload...)
+		if len(msg) < 4 || len(msg) < 4+(int(msg[1])<<16|int(msg[2])<<8|int(msg[3])) {
+			continue
+		}
+
+		msgLength := 4 + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]))
+		if msg[0] != handshakeTypeClientHello {
+			logPrintf("[firstHelloForward %s] 第一条握手消息不是 ClientHello（握手类型 %d）\n", label, msg[0])
+			break
+		}
+		hello, err := parseClientHello(msg[4:msgLength])
+		if err != nil {
+			logPrintf("[firstHelloForward %s] 无法解析 ClientHello：%v\n", label, err)
+			break
+		}
+		state.mu.Lock()
+		state.sni = serverName(hello.extensions)
+		state.mu.Unlock()
+		if config.needsHelloBytes() {
+			state.printHelloBytes(header.Version, msg[:msgLength])
+		}
+		logPrintf(
+			"[firstHelloForward %s] ClientHello（%d 字节）：\n%s",
+			label,
+			msgLength,
+			dumpClientHello(header.Version, hello),
+		)
+		break
+	}
+
+	rawForward(from, to, state, fromClient)
+}
+
+// forwardMode 是标准模式下转发记录的方式，命令行中由 -raw、-first-record-only 和 -count-only 选择
+type forwardMode int
+
+const (
+	// 逐条解析记录（copyDataFromConnToConn）
+	forwardParse forwardMode = iota
+	// 原样转发（-raw）
+	forwardRaw
+	// 只解析第一条 ClientHello（-first-record-only）
+	forwardFirstHello
+	// 只分帧计数（-count-only）
+	forwardCount
+)
+
+// configForwardMode 返回命令行参数选择的 forwardMode
+func configForwardMode() forwardMode {
+	switch {
+	case config.raw:
+		return forwardRaw
+	case config.firstRecordOnly:
+		return forwardFirstHello
+	case config.countOnly:
+		return forwardCount
+	}
+	return forwardParse
+}
+
+func handleNewIncomingConn(inConn *net.TCPConn, remoteAddr string) {
+	handleIncomingConnWithMode(inConn, remoteAddr, configForwardMode())
+}
+
+// handleIncomingConnWithMode 与 handleNewIncomingConn 相同，但标准模式下按 mode 而不是命令行参数转发记录，
+// 供基准测试在同一进程中比较几种转发方式
+func handleIncomingConnWithMode(inConn *net.TCPConn, remoteAddr string, mode forwardMode) {
+	conn, err := backendDialer.Dial("tcp", remoteAddr)
+	if config.backends != nil {
+		config.backends.reportDial(remoteAddr, err)
+	}
 	if err != nil {
+		logPrintf(
+			"[handleNewIncomingConn %s] 无法连接后端 %s：%s\n",
+			inConn.RemoteAddr(),
+			remoteAddr,
+			describeDialError(err),
+		)
 		_ = inConn.Close()
 		return
 	}
+	outConn := conn.(*net.TCPConn)
+
+	statConnOpened.Add(1)
+	state := newConnState(inConn, outConn)
+	state.countOnly = mode == forwardCount
+
+	registerConn(state)
+	defer unregisterConn(state)
+
+	if config.captureDir != "" {
+		var capture recordCapture
+		if config.capturePcapng {
+			capture, err = newPcapngCapture(config.captureDir, state)
+		} else {
+			capture, err = newConnCapture(config.captureDir, state.id)
+		}
+		if err != nil {
+			logPrintf("[handleNewIncomingConn] 无法创建捕获文件：%v\n", err)
+		} else {
+			state.capture = capture
+			defer capture.Close()
+		}
+	}
 
-	go copyDataFromConnToConn(inConn, outConn)
-	go copyDataFromConnToConn(outConn, inConn)
+	if config.tapSink != "" {
+		sink, err := net.DialTimeout("tcp", config.tapSink, backendDialer.Timeout)
+		if err != nil {
+			logPrintf("[handleNewIncomingConn] 无法连接 -tap-sink %s：%v\n", config.tapSink, err)
+		} else {
+			state.tapSink = sink
+			defer sink.Close()
+		}
+	}
+
+	if config.mitmCA != nil {
+		mitmForward(state, remoteAddr)
+		state.onClose()
+		statConnClosed.Add(1)
+		return
+	}
+
+	if config.upstreamTLS != nil {
+		// -mitm 模式已在上面处理，这里只剩 -upstream-tls 的隧道模式
+		upstreamForward(state, remoteAddr)
+		state.onClose()
+		statConnClosed.Add(1)
+		return
+	}
+
+	if config.follow {
+		followForward(state, remoteAddr)
+		state.onClose()
+		statConnClosed.Add(1)
+		return
+	}
+
+	forward := copyDataFromConnToConn
+	switch mode {
+	case forwardRaw:
+		forward = rawForward
+	case forwardFirstHello:
+		forward = firstHelloForward
+	case forwardCount:
+		forward = countForward
+	}
+
+	// 后端端口配置为明文时，两个方向都不解析，也就不会因为数据不像 TLS 记录而输出警告
+	if port := outConn.RemoteAddr().(*net.TCPAddr).Port; config.plaintextPorts[port] {
+		logDetailf("[handleNewIncomingConn %s --> %s] 后端端口 %d 配置为明文，不解析 TLS 记录\n", inConn.RemoteAddr(), outConn.RemoteAddr(), port)
+		forward = rawForward
+	}
+
+	// -tap 模式下只解析选定的方向，另一个方向不解析，直接原样转发
+	clientForward, serverForward := forward, forward
+	switch config.tap {
+	case "client":
+		serverForward = rawForward
+	case "server":
+		clientForward = rawForward
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		clientForward(inConn, outConn, state, true)
+	}()
+	go func() {
+		defer wg.Done()
+		serverForward(outConn, inConn, state, false)
+	}()
+	wg.Wait()
+	state.onClose()
+
+	statConnClosed.Add(1)
 }
 
 func main() {
-	var argRemoteAddr, argLocalAddr string
+	ran, args := runSubcommand(os.Args[1:])
+	if ran {
+		return
+	}
+
+	// 监听地址与后端
+	var (
+		argRemoteAddr, argLocalAddr, argAdminAddr string
+		argBackendsFile, argBackendSelect         string
+		argBackendFailTimeout                     time.Duration
+		argListenBacklog                          int
+		argUDP                                    bool
+	)
 
-	flag.StringVar(&argRemoteAddr, "r", "", "远程地址")
-	flag.StringVar(&argLocalAddr, "l", "", "本地地址")
-	flag.Parse()
+	// 日志与输出格式
+	var (
+		argLogFile, argColor                        string
+		argLogMaxSize, argLogMaxFiles               int
+		argLogRotateInterval, argStatsInterval      time.Duration
+		argSummaryOnly, argJSONSummary              bool
+		argNarrate, argExplain, argTree, argHexdump bool
+		argMaxRecordLogBytes                        int
+		argPrintHelloBytes                          bool
+		argHelloBytesDir                            string
+	)
+
+	// 转发方式
+	var (
+		argRaw, argFirstRecordOnly, argCountOnly bool
+		argFollow, argNoForward                  bool
+		argStrict, argInspectAfterCCS            bool
+		argLimitRecords                          int
+		argRewriteSNI                            string
+	)
+
+	// 证书与握手的检查
+	var (
+		argVerifyCerts, argCertFingerprint, argCipherPreference bool
+		argDetectRandomReuse                                    int
+	)
+
+	// 资源限制与超时
+	var (
+		argMaxHelloSize, argMaxHelloExtensions, argMaxHelloCipherSuites int
+		argMaxHandshakeSize, argMaxIdleRecords                          int
+		argHelloTimeout, argDrainTimeout, argHandshakeStallTimeout      time.Duration
+	)
+
+	// 捕获与重放
+	var (
+		argCaptureDir, argReplay string
+		argReplaySpeed           float64
+	)
+
+	// 中间人、上游 TLS 与解密
+	var (
+		argMITM, argMITMInsecure, argUpstreamTLS, argUpstreamInsecure bool
+		argMITMCA, argGenerateCA                                      string
+		argUpstreamSNI, argUpstreamALPN, argUpstreamMinVersion        string
+		argUpstreamCert, argUpstreamKey                               string
+		argDecryptKey, argDecryptKeyLog                               string
+	)
+
+	// 一次性的工具，运行后退出，不启动代理。analyze、client、echo-server 和 jarm 是独立的子命令，
+	// 见 subcommands.go
+	var (
+		argBench, argCheck bool
+		argBenchDuration   time.Duration
+		argBenchRecordSize int
+	)
+
+	// 需要校验格式的参数，由 parseConfigFlags 统一解析
+	var rawFlags rawFlagValues
+
+	// proxy 的参数使用独立的 FlagSet，与其他子命令一样不依赖全局的 flag.CommandLine
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+
+	fs.StringVar(&argRemoteAddr, "r", "", "远程地址，未指定时读取环境变量 PROXY_REMOTE")
+	fs.StringVar(&argLocalAddr, "l", "", "本地地址，未指定时读取环境变量 PROXY_LISTEN")
+	fs.StringVar(&argBackendsFile, "backends-from-file", "", "从该文件读取多个后端地址（每行一个 host:port），每条连接选择其中一个，代替 -r；收到 SIGHUP 时重新读取")
+	fs.StringVar(&argBackendSelect, "backend-select", "round-robin", "使用 -backends-from-file 时选择后端的方式：round-robin（轮询）或 random（随机）")
+	fs.DurationVar(&argBackendFailTimeout, "backend-fail-timeout", 30*time.Second, "使用 -backends-from-file 时，连接某个后端失败后多长时间内不再选择它")
+	fs.StringVar(&argLogFile, "log-file", "", "把日志写入该文件（而不是标准输出），并按大小或时间滚动")
+	fs.IntVar(&argLogMaxSize, "log-max-size", defaultLogMaxSizeMB, "日志文件超过多少 MB 时滚动，为 0 时不按大小滚动")
+	fs.DurationVar(&argLogRotateInterval, "log-rotate-interval", 0, "日志文件每隔多长时间滚动一次（如 24h），为 0 时不按时间滚动")
+	fs.IntVar(&argLogMaxFiles, "log-max-files", defaultLogMaxFiles, "最多保留多少个滚动后的旧日志文件")
+	fs.StringVar(&argAdminAddr, "admin", "", "在该地址上提供 HTTP 页面，列出当前活跃的连接（如 127.0.0.1:8080）")
+	fs.DurationVar(&argStatsInterval, "stats-interval", 0, "定期输出统计信息的间隔（如 10s），为 0 时不输出")
+	fs.StringVar(&rawFlags.injectAlert, "inject-alert", "", "看到 ClientHello 后向客户端注入指定警报并断开，格式为 <级别>:<描述>，如 fatal:handshake_failure")
+	fs.StringVar(&rawFlags.stripExt, "strip-ext", "", "转发前从 ClientHello 中移除指定扩展，可填写扩展编号或名称，如 21 或 padding")
+	fs.StringVar(&argRewriteSNI, "rewrite-sni", "", "转发前将 ClientHello 中的 SNI 改写为指定主机名，没有 SNI 时插入一个")
+	fs.StringVar(&rawFlags.minVersion, "min-version", "", "拒绝低于该版本（1.0、1.1、1.2 或 1.3）的连接：客户端提供的最高版本或服务器选定的版本低于它时，发送 protocol_version 警报并断开")
+	fs.StringVar(&rawFlags.forceVersion, "force-version", "", "（实验性）转发前改写 ClientHello，只提供指定的版本（1.0、1.1、1.2 或 1.3），用于观察不同版本的握手")
+	fs.BoolVar(&argNarrate, "narrate", false, "以叙述的方式输出每条连接的握手过程")
+	fs.BoolVar(&argUDP, "udp", false, "以 UDP 方式转发，并解析其中的 DTLS 记录")
+	fs.StringVar(&rawFlags.allowSNI, "allow-sni", "", "只放行 SNI 与其中某项匹配的连接，以逗号分隔，支持 * 通配符")
+	fs.StringVar(&rawFlags.denySNI, "deny-sni", "", "拒绝 SNI 与其中某项匹配的连接，以逗号分隔，支持 * 通配符，优先于 -allow-sni")
+	fs.StringVar(&rawFlags.sniPolicyAlert, "sni-policy-alert", "fatal:unrecognized_name", "SNI 策略拒绝连接时发送的警报，格式同 -inject-alert")
+	fs.StringVar(&rawFlags.ja3Allowlist, "ja3-allowlist", "", "只放行 JA3 哈希在该文件中的客户端（每行一个哈希，# 之后为注释），其余连接发送 -ja3-policy-alert 指定的警报后断开")
+	fs.StringVar(&rawFlags.clientFingerprints, "client-fingerprints", "", "补充推测客户端 TLS 实现所用的指纹库，每行为“JA3 哈希 客户端名称”，# 之后为注释")
+	fs.StringVar(&rawFlags.ja3PolicyAlert, "ja3-policy-alert", "fatal:handshake_failure", "JA3 策略拒绝连接时发送的警报，格式同 -inject-alert")
+	fs.BoolVar(&argRaw, "raw", false, "不解析记录，直接原样转发（Linux 上使用 splice 零拷贝）")
+	fs.BoolVar(&argFirstRecordOnly, "first-record-only", false, "只详细输出客户端的第一条 ClientHello（全部密码套件、扩展和 JA3），之后原样转发")
+	fs.BoolVar(&argPrintHelloBytes, "print-hello-bytes", false, "以十六进制文本输出每条连接的第一条 ClientHello 记录（跨越多条记录时拼接成一条），可以交给子命令 analyze 解析或附在问题报告中用于复现")
+	fs.StringVar(&argHelloBytesDir, "hello-bytes-dir", "", "把每条连接的第一条 ClientHello 记录以十六进制文本写入该目录下的 hello-<编号>.hex 文件")
+	fs.StringVar(&argCaptureDir, "capture-dir", "", "把每条连接的记录连同时间写入该目录下的 conn-<编号>.tlscap 文件")
+	fs.StringVar(&rawFlags.captureFormat, "capture-format", "tlscap", "-capture-dir 中捕获文件的格式：tlscap 可用于 -replay；pcapng 可直接用 Wireshark 打开，文件的注释中附有连接编号、SNI 和连接摘要")
+	fs.StringVar(&argReplay, "replay", "", "按原来的时间间隔把捕获文件中客户端发出的记录重新发给 -r 指定的服务器")
+	fs.Float64Var(&argReplaySpeed, "replay-speed", 1, "重放速度的倍数，2 表示以两倍速重放")
+	fs.BoolVar(&argCountOnly, "count-only", false, "不解析也不输出逐条记录，只按内容类型计数，连接关闭时输出每条连接的记录数和字节数，用于以较小的开销统计负载产生了多少记录")
+	fs.BoolVar(&argFollow, "follow", false, "与 -raw 一起使用：后端中途断开而客户端仍连接时，重新连接后端并继续转发客户端的数据。只是尽力而为，TLS 会话无法迁移到新的后端，客户端需要重新握手")
+	fs.BoolVar(&argSummaryOnly, "summary-only", false, "不输出逐条记录的信息，只在连接关闭时输出连接摘要")
+	fs.BoolVar(&argJSONSummary, "json-summary", false, "连接关闭时以一行 JSON 输出连接摘要（与 -summary-only 一起使用时，输出可以直接交给 jq 处理）")
+	fs.BoolVar(&argStrict, "strict", false, "把协议违规（记录超长、按阶段不应出现的内容类型、close_notify 之后的记录、重复的扩展、长度不一致而无法解析的 Hello 等）当作错误：不再转发违规的记录，向双方发送相应的致命警报并中止连接。只是不常见的行为仍然只输出提示")
+	fs.BoolVar(&argNoForward, "no-forward", false, "只观察握手：握手一完成就关闭连接，不转发应用数据")
+	fs.BoolVar(&argVerifyCerts, "verify-certs", false, "解析服务器的证书链并用系统根证书验证（仅 TLS 1.2 及更早版本，TLS 1.3 的证书是加密的）")
+	fs.BoolVar(&argCertFingerprint, "cert-fingerprint", false, "输出服务器叶子证书的 SHA-256 指纹和公钥的 pin-sha256（仅 TLS 1.2 及更早版本）")
+	fs.BoolVar(&argExplain, "explain", false, "把密码套件拆成密钥交换、身份验证、对称加密和 MAC/PRF 四部分加以解释")
+	fs.BoolVar(&argCipherPreference, "pretty-cipher-preference", false, "根据服务器选定的密码套件在客户端列表中的位置，说明服务器遵循的是客户端还是自己的偏好顺序")
+	fs.StringVar(&argDecryptKey, "decrypt-key", "", "用该 PEM 文件中的服务器 RSA 私钥解密 TLS 1.2 RSA 密钥交换的连接，输出解密出的应用数据（(EC)DHE 和 TLS 1.3 无法这样解密）")
+	fs.StringVar(&argDecryptKeyLog, "decrypt-keylog", "", "从该 NSS 格式的密钥日志（浏览器、curl 等按 SSLKEYLOGFILE 环境变量写出的文件）中按 client random 查找密钥，解密 TLS 1.2 和 TLS 1.3 的连接，(EC)DHE 套件同样适用")
+	fs.StringVar(&argColor, "color", "auto", "警告是否着色：always 总是、never 从不；auto 时遵循环境变量 NO_COLOR（非空则不着色）和 CLICOLOR_FORCE（非空且不为 0 则着色），都未设置时只在输出到终端时着色")
+	fs.BoolVar(&argTree, "tree", false, "仿照 Wireshark 的方式，逐层缩进输出每条记录的解析树（记录 → 握手消息 → 扩展 → 字段）")
+	fs.BoolVar(&argInspectAfterCCS, "inspect-after-ccs", false, "（教学用）ChangeCipherSpec 之后仍把加密的握手和警报记录当作明文解析，输出的结果没有意义，只是为了说明加密数据无法这样解析")
+	fs.BoolVar(&argHexdump, "hexdump", false, "以十六进制输出每条记录（含记录层头部）的原始字节")
+	fs.IntVar(&argMaxRecordLogBytes, "max-record-log-bytes", 256, "-hexdump 和 -tree 中每条记录最多输出的原始字节数，超出部分以 …(+N 字节) 表示，0 表示不限制")
+	fs.IntVar(&argMaxHelloSize, "max-hello-size", maxPlaintextLength, "需要缓存 ClientHello 时（SNI 策略、改写、注入警报）最多缓存的字节数，超过后断开连接")
+	fs.IntVar(&argMaxHandshakeSize, "max-handshake-size", 0, "每条连接在握手完成前两个方向的握手数据最多多少字节，超过后关闭连接，0 表示不限制")
+	fs.IntVar(&argMaxHelloExtensions, "max-hello-extensions", 64, "ClientHello 携带的扩展多于这个数量时发出警告（常见客户端不超过 30 个，过多可能是攻击或模糊测试），0 表示不检查")
+	fs.IntVar(&argMaxHelloCipherSuites, "max-hello-cipher-suites", 256, "ClientHello 提供的密码套件多于这个数量时发出警告，0 表示不检查")
+	fs.DurationVar(&argDrainTimeout, "drain-timeout", 0, "收到 SIGINT 或 SIGTERM 后停止接受新连接，最多等待这么久让现有连接结束，之后强制关闭；0 表示收到信号立即退出")
+	fs.DurationVar(&argHandshakeStallTimeout, "handshake-stall-timeout", 0, "握手完成前超过这么久没有任何推进握手的记录（完整的握手消息、ChangeCipherSpec 或加密的握手消息）时发出警告，即使期间一直有其他数据；0 表示不检查，不会断开连接")
+	fs.IntVar(&argMaxIdleRecords, "max-idle-records", 0, "握手完成前连续这么多条记录（如警报、心跳、零长度记录）都没有推进握手时发出警告，0 表示不检查，不会断开连接")
+	fs.DurationVar(&argHelloTimeout, "hello-timeout", 10*time.Second, "需要缓存 ClientHello 时，等待 ClientHello 完整到达的最长时间")
+	fs.IntVar(&argListenBacklog, "listen-backlog", 0, "监听队列（已完成三次握手、等待代理接受的连接）的长度，0 表示使用系统默认值")
+	fs.StringVar(&rawFlags.plaintextPorts, "plaintext-ports", "", "以逗号分隔的后端端口列表，连接到这些端口时视为明文流量，原样转发而不解析 TLS 记录，例如 80,8080")
+	fs.IntVar(&argLimitRecords, "limit-records", 0, "每条连接的每个方向只解析前 N 条记录，之后按 -limit-records-action 处理，0 表示不限制")
+	fs.StringVar(&rawFlags.limitRecordsAction, "limit-records-action", "raw", "达到 -limit-records 的限制后：raw 表示不再解析、原样转发，close 表示关闭连接")
+	fs.IntVar(&argDetectRandomReuse, "detect-random-reuse", 0, "记住最近 N 条连接的 ClientHello random，新连接的 random 与其中之一相同时发出警告（可能是重放或随机数生成器有问题），0 表示不检测")
+	fs.StringVar(&rawFlags.only, "only", "", "以逗号分隔的内容类型列表（名称或编号，例如 handshake,alert），只输出这些类型的记录，默认输出全部")
+	fs.StringVar(&rawFlags.tap, "tap", "", "只解析一个方向的记录（client 表示客户端到服务器，server 表示服务器到客户端），另一个方向原样转发")
+	fs.StringVar(&rawFlags.tapSink, "tap-sink", "", "把解析的记录（含记录层头部）原样镜像到该 TCP 地址，每条被代理的连接各建立一条镜像连接")
+	fs.BoolVar(&argCheck, "check", false, "检查参数、地址、文件和目录是否可用，输出检查报告后退出，不启动代理")
+	fs.StringVar(&argGenerateCA, "generate-ca", "", "在该目录下生成 MITM 使用的根证书 ca.pem 和私钥 ca-key.pem（已存在时不覆盖）后退出，无需 -l 和 -r")
+	fs.BoolVar(&argMITM, "mitm", false, "终结客户端的 TLS（用 -mitm-ca 的根证书按 SNI 签发证书），再与后端另建 TLS 连接，输出两个方向解密后的应用数据")
+	fs.StringVar(&argMITMCA, "mitm-ca", "", "-mitm 使用的根证书目录，即 -generate-ca 生成的 ca.pem 和 ca-key.pem 所在的目录")
+	fs.BoolVar(&argMITMInsecure, "mitm-insecure", false, "-mitm 模式下不验证后端的证书（仅用于实验环境）")
+	fs.BoolVar(&argUpstreamTLS, "upstream-tls", false, "与后端之间先建立一条 TLS 连接，把客户端的数据原样放在其中转发（类似 stunnel 的客户端模式），隧道中的数据不再解析")
+	fs.StringVar(&argUpstreamSNI, "upstream-sni", "", "与后端 TLS 握手时使用的 SNI，同时用于验证后端的证书，默认为后端地址中的主机名（-mitm 模式下为客户端的 SNI）")
+	fs.StringVar(&argUpstreamALPN, "upstream-alpn", "", "与后端 TLS 握手时提供的 ALPN 协议，以逗号分隔，默认不提供（-mitm 模式下沿用客户端的 ALPN）")
+	fs.StringVar(&argUpstreamMinVersion, "upstream-min-version", "", "与后端 TLS 握手时允许的最低版本，如 1.2，默认为 Go 的默认值")
+	fs.StringVar(&argUpstreamCert, "upstream-cert", "", "后端要求客户端证书时出示的证书文件（PEM），需与 -upstream-key 一起指定")
+	fs.StringVar(&argUpstreamKey, "upstream-key", "", "-upstream-cert 对应的私钥文件（PEM）")
+	fs.BoolVar(&argUpstreamInsecure, "upstream-insecure", false, "与后端 TLS 握手时不验证后端的证书（仅用于实验环境）")
+	fs.BoolVar(&argBench, "bench", false, "运行本机吞吐量基准测试后退出，无需 -l 和 -r")
+	fs.DurationVar(&argBenchDuration, "bench-duration", 3*time.Second, "基准测试中每种转发方式的运行时间")
+	fs.IntVar(&argBenchRecordSize, "bench-record-size", maxPlaintextLength, "基准测试中每条记录的长度")
+	fs.Usage = func() { printUsage(fs) }
+	_ = fs.Parse(args)
+
+	// 在容器中传递命令行参数不太方便，因此也支持用环境变量指定地址，命令行参数优先
+	if argLocalAddr == "" {
+		argLocalAddr = os.Getenv("PROXY_LISTEN")
+	}
+	if argRemoteAddr == "" {
+		argRemoteAddr = os.Getenv("PROXY_REMOTE")
+	}
 
-	if argRemoteAddr == "" || argLocalAddr == "" {
-		panic("请填写必要的参数 -l 和 -r")
+	if argCheck {
+		runCheck([]checkItem{
+			{"配置参数", parseConfigFlags(rawFlags)},
+			{"ClientHello 缓存限制", checkHelloLimits(argMaxHelloSize, argHelloTimeout)},
+			{"本地地址 -l", checkListenAddr(argLocalAddr, argUDP)},
+			{"远程地址 -r", checkRemoteOrBackends(argRemoteAddr, argBackendsFile)},
+			{"后端列表 -backends-from-file", checkBackendsFile(argBackendsFile)},
+			{"日志文件 -log-file", checkWritableFile(argLogFile)},
+			{"捕获目录 -capture-dir", checkWritableDir(argCaptureDir)},
+			{"管理页面地址 -admin", checkOptionalTCPAddr(argAdminAddr)},
+			{"镜像地址 -tap-sink", checkOptionalTCPAddr(rawFlags.tapSink)},
+			{"重放文件 -replay", checkCaptureFile(argReplay)},
+			{"解密私钥 -decrypt-key", checkDecryptKey(argDecryptKey)},
+			{"密钥日志 -decrypt-keylog", checkKeyLogFile(argDecryptKeyLog)},
+			{"MITM 根证书 -mitm-ca", checkMITMCA(argMITMCA)},
+		})
+		return
 	}
 
-	tcpRemoteAddr, err := net.ResolveTCPAddr("tcp4", argRemoteAddr)
+	if argLogFile != "" {
+		w, err := newRotatingWriter(argLogFile, int64(argLogMaxSize)*1024*1024, argLogRotateInterval, argLogMaxFiles)
+		panicIfErr(err, "main")
+		setLogOutput(w)
+	}
+	color, err := decideColor(argColor, argLogFile == "" && isTerminal(os.Stdout))
 	panicIfErr(err, "main")
+	config.color = color
+
+	if argGenerateCA != "" {
+		runGenerateCA(argGenerateCA)
+		return
+	}
+
+	if argBench {
+		runBenchmark(argBenchDuration, argBenchRecordSize)
+		return
+	}
+
+	if argReplay != "" {
+		if argRemoteAddr == "" {
+			panic("重放时请填写参数 -r")
+		}
+		runReplay(argReplay, argRemoteAddr, argReplaySpeed)
+		return
+	}
+
+	if (argRemoteAddr == "" && argBackendsFile == "") || argLocalAddr == "" {
+		panic("请填写必要的参数 -l 和 -r（或设置环境变量 PROXY_LISTEN 和 PROXY_REMOTE）")
+	}
+	if argRemoteAddr != "" && argBackendsFile != "" {
+		panic("-r 与 -backends-from-file 只能指定一个")
+	}
+	if argCountOnly && (argRaw || argFirstRecordOnly || argUDP) {
+		panic("-count-only 不能与 -raw、-first-record-only 或 -udp 同时使用")
+	}
+	if (argPrintHelloBytes || argHelloBytesDir != "") && (argRaw || argCountOnly || argUDP) {
+		panic("-print-hello-bytes 和 -hello-bytes-dir 需要解析 ClientHello，不能与 -raw、-count-only 或 -udp 同时使用")
+	}
+	if (argHandshakeStallTimeout > 0 || argMaxIdleRecords > 0) && (argRaw || argFirstRecordOnly || argCountOnly || argUDP || rawFlags.tap != "") {
+		panic("-handshake-stall-timeout 和 -max-idle-records 需要解析两个方向的记录，不能与 -raw、-first-record-only、-count-only、-udp 或 -tap 同时使用")
+	}
+	if argStrict && (argRaw || argFirstRecordOnly || argCountOnly || argUDP || argMITM || argUpstreamTLS || rawFlags.tap != "") {
+		panic("-strict 需要解析两个方向的记录，不能与 -raw、-first-record-only、-count-only、-udp、-mitm、-upstream-tls 或 -tap 同时使用")
+	}
+	if argFollow && (!argRaw || argUDP) {
+		panic("-follow 只能与 -raw 一起使用，且不支持 -udp：解析记录的模式中，TLS 状态与原来的后端绑定，换一个后端后无法继续解析")
+	}
+
+	panicIfErr(parseConfigFlags(rawFlags), "main")
+
+	config.rewriteSNI = argRewriteSNI
+	config.maxHelloSize = argMaxHelloSize
+	config.helloTimeout = argHelloTimeout
+	config.narrate = argNarrate
+	config.raw = argRaw
+	config.firstRecordOnly = argFirstRecordOnly
+	config.countOnly = argCountOnly
+	config.follow = argFollow
+	config.strict = argStrict
+	config.printHelloBytes = argPrintHelloBytes
+	config.helloBytesDir = argHelloBytesDir
+	config.captureDir = argCaptureDir
+	config.summaryOnly = argSummaryOnly
+	config.jsonSummary = argJSONSummary
+	config.noForward = argNoForward
+	config.verifyCerts = argVerifyCerts
+	config.certFingerprint = argCertFingerprint
+	config.explain = argExplain
+	config.tree = argTree
+	config.inspectAfterCCS = argInspectAfterCCS
+	config.cipherPreference = argCipherPreference
+	config.hexdump = argHexdump
+	config.maxRecordLogBytes = argMaxRecordLogBytes
+	config.limitRecords = argLimitRecords
+	config.maxHandshakeSize = argMaxHandshakeSize
+	config.maxHelloExtensions = argMaxHelloExtensions
+	config.maxHelloCipherSuites = argMaxHelloCipherSuites
+	config.handshakeStallTimeout = argHandshakeStallTimeout
+	config.maxIdleRecords = argMaxIdleRecords
+	if argDetectRandomReuse > 0 {
+		config.seenRandoms = newRandomLRU(argDetectRandomReuse)
+	}
+	if argDecryptKey != "" {
+		key, err := loadRSAPrivateKey(argDecryptKey)
+		panicIfErr(err, "main")
+		config.decryptKey = key
+	}
+	if argDecryptKeyLog != "" {
+		keyLog, err := loadKeyLog(argDecryptKeyLog)
+		panicIfErr(err, "main")
+		config.keyLog = keyLog
+	}
+	if argMITM {
+		if argMITMCA == "" {
+			panic("-mitm 需要用 -mitm-ca 指定根证书目录（可以先用 -generate-ca 生成）")
+		}
+		if argRaw || argUDP || argFirstRecordOnly || argCountOnly {
+			panic("-mitm 不能与 -raw、-udp、-first-record-only 或 -count-only 同时使用")
+		}
+		ca, err := loadMITMCA(argMITMCA)
+		panicIfErr(err, "main")
+		config.mitmCA = ca
+		config.mitmInsecure = argMITMInsecure
+	}
+	if argUpstreamTLS && (argRaw || argUDP || argFirstRecordOnly || argCountOnly || argFollow) {
+		panic("-upstream-tls 不能与 -raw、-udp、-first-record-only、-count-only 或 -follow 同时使用")
+	}
+	upstreamOptions := argUpstreamSNI != "" || argUpstreamALPN != "" || argUpstreamMinVersion != "" || argUpstreamCert != "" || argUpstreamKey != "" || argUpstreamInsecure
+	if upstreamOptions && !argUpstreamTLS && !argMITM {
+		panic("-upstream-sni 等参数只能与 -upstream-tls 或 -mitm 一起使用")
+	}
+	if argUpstreamTLS || argMITM {
+		upstream, err := newUpstreamTLSConfig(argUpstreamSNI, argUpstreamALPN, argUpstreamMinVersion, argUpstreamCert, argUpstreamKey, argUpstreamInsecure)
+		panicIfErr(err, "main")
+		config.upstreamTLS = upstream
+	}
+
+	if argUDP {
+		if argBackendsFile != "" {
+			panic("-udp 模式不支持 -backends-from-file")
+		}
+		runUDPProxy(argLocalAddr, argRemoteAddr)
+		return
+	}
+
+	if argBackendsFile != "" {
+		backends, err := newBackendPool(argBackendsFile, argBackendSelect, argBackendFailTimeout)
+		panicIfErr(err, "main")
+		config.backends = backends
+		logPrintf("从 %s 读取了 %d 个后端：%s\n", argBackendsFile, len(backends.addrs), strings.Join(backends.addrs, "、"))
+		go backends.reloadOnSIGHUP()
+	} else {
+		// 远程地址保留主机名，每次连接时重新解析，以便同时使用 IPv4 和 IPv6 地址
+		_, _, err := net.SplitHostPort(argRemoteAddr)
+		panicIfErr(err, "main")
+	}
 
 	tcpLocalAddr, err := net.ResolveTCPAddr("tcp4", argLocalAddr)
 	panicIfErr(err, "main")
 
-	listener, err := net.ListenTCP("tcp4", tcpLocalAddr)
+	listener, err := listenTCPWithBacklog(tcpLocalAddr, argListenBacklog)
 	panicIfErr(err, "main")
 
-	fmt.Printf("正在监听 %s……\n", tcpLocalAddr)
+	if argListenBacklog > 0 {
+		logPrintf("正在监听 %s（监听队列长度 %d）……\n", tcpLocalAddr, argListenBacklog)
+	} else {
+		logPrintf("正在监听 %s……\n", tcpLocalAddr)
+	}
 
+	if argAdminAddr != "" {
+		go runAdminServer(argAdminAddr)
+	}
+	if argStatsInterval > 0 {
+		go runStatsTicker(argStatsInterval)
+	}
+
+	var forceClose <-chan struct{}
+	if argDrainTimeout > 0 {
+		forceClose = stopListeningOnSignal(listener, argDrainTimeout)
+	}
+
+	// 与 http.Server.Serve 相同：遇到临时错误时等待一段时间再重试，等待时间从 5ms 开始每次翻倍，最长 1 秒，
+	// 成功接受连接后重置
+	var tempDelay time.Duration
 	for {
 		inConn, err := listener.AcceptTCP()
+		if err != nil && isTemporaryAcceptError(err) {
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if tempDelay > time.Second {
+				tempDelay = time.Second
+			}
+			logPrintf("接受连接时出现临时错误：%v，%s 后重试\n", err, tempDelay)
+			time.Sleep(tempDelay)
+			continue
+		}
+		if err != nil && argDrainTimeout > 0 && errors.Is(err, net.ErrClosed) {
+			// 监听套接字是收到信号后关闭的
+			drainConnections(argDrainTimeout, forceClose)
+			return
+		}
 		panicIfErr(err, "main")
+		tempDelay = 0
 
-		go handleNewIncomingConn(inConn, tcpRemoteAddr)
+		remoteAddr := argRemoteAddr
+		if config.backends != nil {
+			remoteAddr = config.backends.pick()
+		}
+		connHandlers.Add(1)
+		go func() {
+			defer connHandlers.Done()
+			handleNewIncomingConn(inConn, remoteAddr)
+		}()
+	}
+}
+
+// rawFlagValues 是需要校验格式的参数的原始字符串，在 main 中由 flag 包填写，交给 parseConfigFlags 解析
+type rawFlagValues struct {
+	// -inject-alert、-strip-ext 和 -force-version
+	injectAlert, stripExt, forceVersion string
+	// -allow-sni、-deny-sni 和 -sni-policy-alert
+	allowSNI, denySNI, sniPolicyAlert string
+	// -tap 和 -tap-sink
+	tap, tapSink string
+	// -plaintext-ports
+	plaintextPorts string
+	// -limit-records-action
+	limitRecordsAction string
+	// -only
+	only string
+	// -ja3-allowlist 和 -ja3-policy-alert
+	ja3Allowlist, ja3PolicyAlert string
+	// -capture-format
+	captureFormat string
+	// -client-fingerprints
+	clientFingerprints string
+	// -min-version
+	minVersion string
+}
+
+// parseConfigFlags 解析需要校验格式的参数并写入 config。出错时返回错误而不是 panic，以便 -check 报告
+func parseConfigFlags(f rawFlagValues) error {
+	if f.injectAlert != "" {
+		alert, err := parseAlertSpec(f.injectAlert)
+		if err != nil {
+			return fmt.Errorf("-inject-alert：%w", err)
+		}
+		config.injectAlert = alert
+	}
+
+	if f.stripExt != "" {
+		extType, ok := lookupCode(EXTENSION_TYPE_TABLE, f.stripExt)
+		if !ok {
+			return fmt.Errorf("-strip-ext：未知的扩展类型 %q", f.stripExt)
+		}
+		config.stripExt = &extType
+	}
+
+	if f.forceVersion != "" {
+		version, err := parseForceVersion(f.forceVersion)
+		if err != nil {
+			return fmt.Errorf("-force-version：%w", err)
+		}
+		config.forceVersion = version
+	}
+
+	if f.minVersion != "" {
+		version, err := parseForceVersion(f.minVersion)
+		if err != nil {
+			return fmt.Errorf("-min-version：%w", err)
+		}
+		config.versionPolicy = &versionPolicy{min: version, alert: alertSpec{level: alertLevelFatal, description: alertProtocolVersion}}
+	}
+
+	switch f.tap {
+	case "", "client", "server":
+		config.tap = f.tap
+		config.tapSink = f.tapSink
+	default:
+		return fmt.Errorf("-tap：应为 client 或 server，而不是 %q", f.tap)
+	}
+	if f.tapSink != "" && f.tap == "" {
+		// 不区分方向地镜像两个方向的记录，接收方无法把它们重新分开
+		return errors.New("-tap-sink 需要同时用 -tap 指定镜像哪个方向")
+	}
+
+	switch f.limitRecordsAction {
+	case "raw":
+	case "close":
+		config.limitRecordsClose = true
+	default:
+		return fmt.Errorf("-limit-records-action：应为 raw 或 close，而不是 %q", f.limitRecordsAction)
+	}
+
+	switch f.captureFormat {
+	case "tlscap":
+	case "pcapng":
+		config.capturePcapng = true
+	default:
+		return fmt.Errorf("-capture-format：应为 tlscap 或 pcapng，而不是 %q", f.captureFormat)
+	}
+
+	if f.plaintextPorts != "" {
+		config.plaintextPorts = map[int]bool{}
+		for _, field := range strings.Split(f.plaintextPorts, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || port <= 0 || port > 65535 {
+				return fmt.Errorf("-plaintext-ports：%q 不是有效的端口号", field)
+			}
+			config.plaintextPorts[port] = true
+		}
+	}
+
+	if f.only != "" {
+		config.onlyContentTypes = map[byte]bool{}
+		for _, field := range strings.Split(f.only, ",") {
+			contentType, ok := lookupCode(CONTENT_TYPE_TABLE, strings.TrimSpace(field))
+			if !ok {
+				return fmt.Errorf("-only：未知的内容类型 %q", field)
+			}
+			config.onlyContentTypes[contentType] = true
+		}
+	}
+
+	if f.allowSNI != "" || f.denySNI != "" {
+		allow, err := parseSNIPatterns(f.allowSNI)
+		if err != nil {
+			return fmt.Errorf("-allow-sni：%w", err)
+		}
+		deny, err := parseSNIPatterns(f.denySNI)
+		if err != nil {
+			return fmt.Errorf("-deny-sni：%w", err)
+		}
+		alert, err := parseAlertSpec(f.sniPolicyAlert)
+		if err != nil {
+			return fmt.Errorf("-sni-policy-alert：%w", err)
+		}
+		config.sniPolicy = &sniPolicy{allow: allow, deny: deny, alert: *alert}
+	}
+
+	if f.ja3Allowlist != "" {
+		allow, err := loadJA3Allowlist(f.ja3Allowlist)
+		if err != nil {
+			return fmt.Errorf("-ja3-allowlist：%w", err)
+		}
+		alert, err := parseAlertSpec(f.ja3PolicyAlert)
+		if err != nil {
+			return fmt.Errorf("-ja3-policy-alert：%w", err)
+		}
+		config.ja3Policy = &ja3Policy{allow: allow, alert: *alert}
+	}
+
+	if f.clientFingerprints != "" {
+		fingerprints, err := loadClientFingerprints(f.clientFingerprints)
+		if err != nil {
+			return fmt.Errorf("-client-fingerprints：%w", err)
+		}
+		config.clientFingerprints = fingerprints
+	}
+	return nil
+}
+
+// isTemporaryAcceptError 判断 Accept 返回的错误是否是暂时的：连接在接受前被客户端中止，
+// 或者文件描述符、内存等资源暂时耗尽。这些情况下监听套接字本身仍然可用
+func isTemporaryAcceptError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.ECONNABORTED, syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM} {
+		if errors.Is(err, errno) {
+			return true
+		}
 	}
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
 }
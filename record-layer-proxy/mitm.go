@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// mitmForward 是 -mitm 模式下一条连接的转发：代理用根证书为客户端请求的 SNI 签发的证书终结客户端的 TLS，
+// 再以客户端的身份与后端另建一条 TLS 连接，两条连接之间转发明文，因此两个方向的应用数据都能完整输出。
+// 为了让 ALPN 两端一致，先与后端完成握手，再把后端选中的协议作为唯一的选项回应客户端
+func mitmForward(state *connState, remoteAddr string) {
+	defer state.closeBoth()
+
+	var backend *tls.Conn
+	client := tls.Server(state.clientConn, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			var err error
+			backend, err = mitmDialBackend(state, hello, remoteAddr)
+			if err != nil {
+				return nil, err
+			}
+			host := hello.ServerName
+			if host == "" {
+				host = mitmBackendHost(remoteAddr)
+			}
+			leaf, err := config.mitmCA.leafFor(host)
+			if err != nil {
+				logPrintf("[mitm %s] 无法为 %s 签发证书：%v\n", state.clientConn.RemoteAddr(), host, err)
+				return nil, err
+			}
+			serverConfig := &tls.Config{Certificates: []tls.Certificate{*leaf}}
+			if proto := backend.ConnectionState().NegotiatedProtocol; proto != "" {
+				serverConfig.NextProtos = []string{proto}
+			}
+			return serverConfig, nil
+		},
+	})
+	_ = client.SetDeadline(time.Now().Add(config.helloTimeout))
+	if err := client.Handshake(); err != nil {
+		logPrintf("[mitm %s] 与客户端的 TLS 握手失败：%v\n", state.clientConn.RemoteAddr(), err)
+		return
+	}
+	_ = client.SetDeadline(time.Time{})
+
+	clientState, backendState := client.ConnectionState(), backend.ConnectionState()
+	state.mu.Lock()
+	state.version, state.cipherSuite = clientState.Version, clientState.CipherSuite
+	state.serverALPN = clientState.NegotiatedProtocol
+	state.resumed = clientState.DidResume
+	state.setFinished(true)
+	state.setFinished(false)
+	state.mu.Unlock()
+
+	leafInfo := ""
+	if len(backendState.PeerCertificates) > 0 {
+		fingerprint := sha256.Sum256(backendState.PeerCertificates[0].Raw)
+		leafInfo = fmt.Sprintf("，后端证书为 %s（SHA-256 %s）", backendState.PeerCertificates[0].Subject, hex.EncodeToString(fingerprint[:]))
+	}
+	logPrintf("[mitm %s --> %s] 已终结 TLS：客户端一侧为 %s、%s、ALPN %s；后端一侧为 %s、%s、ALPN %s%s\n",
+		state.clientConn.RemoteAddr(), state.serverConn.RemoteAddr(),
+		formatVersion(clientState.Version), formatCipherSuite(clientState.CipherSuite), describeALPN(clientState.NegotiatedProtocol),
+		formatVersion(backendState.Version), formatCipherSuite(backendState.CipherSuite), describeALPN(backendState.NegotiatedProtocol), leafInfo)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		mitmCopy(state, backend, client, true)
+	}()
+	go func() {
+		defer wg.Done()
+		mitmCopy(state, client, backend, false)
+	}()
+	wg.Wait()
+}
+
+// mitmDialBackend 在看到客户端的 ClientHello 后，以相同的 SNI 和 ALPN（可以用 -upstream-sni 和 -upstream-alpn 改为别的值）
+// 与后端完成 TLS 握手，-upstream-* 的其余参数同样适用于这条连接
+func mitmDialBackend(state *connState, hello *tls.ClientHelloInfo, remoteAddr string) (*tls.Conn, error) {
+	state.mu.Lock()
+	state.sni = hello.ServerName
+	state.clientALPN = hello.SupportedProtos
+	state.clientCipherSuites = hello.CipherSuites
+	state.clientVersions = hello.SupportedVersions
+	state.mu.Unlock()
+
+	return upstreamHandshake(hello.Context(), state, remoteAddr, hello)
+}
+
+// mitmBackendHost 返回后端地址中的主机部分，客户端没有发送 SNI 时用它签发证书和验证后端
+func mitmBackendHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// describeALPN 返回 ALPN 协议的描述，没有协商 ALPN 时返回“无”
+func describeALPN(proto string) string {
+	if proto == "" {
+		return "无"
+	}
+	return proto
+}
+
+// mitmCopy 把 from 解密出的明文转发给 to 并输出。from 关闭后向 to 发送 close_notify，另一个方向仍可继续传输
+func mitmCopy(state *connState, to, from *tls.Conn, fromClient bool) {
+	buf := make([]byte, maxPlaintextLength)
+	for {
+		n, err := from.Read(buf)
+		if n > 0 {
+			state.addBytes(fromClient, int64(n))
+			if shouldLogContentType(contentTypeApplicationData) {
+				state.logf(fromClient, "-mitm：解密出 %d 字节的应用数据：%s", n, formatPlaintext(buf[:n]))
+			}
+			if _, werr := to.Write(buf[:n]); werr != nil {
+				state.logf(fromClient, "-mitm：转发时出错：%s", describeConnError(werr))
+				state.closeBoth()
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				state.logf(fromClient, "-mitm：%s", describeConnError(err))
+				state.closeBoth()
+				return
+			}
+			_ = to.CloseWrite()
+			return
+		}
+	}
+}
+
+// checkMITMCA 检查 -mitm-ca 目录下的根证书和私钥能否读取并配对
+func checkMITMCA(dir string) error {
+	if dir == "" {
+		return errNotSet
+	}
+	_, err := loadMITMCA(dir)
+	return err
+}
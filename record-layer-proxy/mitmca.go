@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MITM 根证书在目录中的文件名
+const (
+	mitmCACertFile = "ca.pem"
+	mitmCAKeyFile  = "ca-key.pem"
+)
+
+const (
+	// 根证书的有效期
+	mitmCAValidity = 10 * 365 * 24 * time.Hour
+	// 签发的叶子证书的有效期。浏览器不接受有效期过长的服务器证书（如 Apple 要求不超过 825 天），这里取得很短
+	mitmLeafValidity = 30 * 24 * time.Hour
+	// 叶子证书缓存最多保存多少个主机名
+	maxMITMLeafCache = 1024
+)
+
+// generateCA 生成用于 MITM 的根证书和私钥，写入 dir 下的 ca.pem 和 ca-key.pem。
+// 文件已存在时不覆盖，以免已经导入浏览器的根证书失效
+func generateCA(dir string) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "learn-tls MITM CA", Organization: []string{"learn-tls"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(mitmCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		// 只能直接签发叶子证书，不能再签发中间证书
+		MaxPathLenZero: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := writeNewPEMFile(filepath.Join(dir, mitmCAKeyFile), "PRIVATE KEY", keyDER, 0o600); err != nil {
+		return nil, err
+	}
+	if err := writeNewPEMFile(filepath.Join(dir, mitmCACertFile), "CERTIFICATE", der, 0o644); err != nil {
+		_ = os.Remove(filepath.Join(dir, mitmCAKeyFile))
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// writeNewPEMFile 以 PEM 格式把 der 写入一个新文件，文件已存在时返回错误
+func writeNewPEMFile(path, blockType string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// randomSerialNumber 生成 128 位的随机证书序列号。每张证书的序列号都不同，浏览器才不会认为两张证书冲突
+func randomSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// runGenerateCA 是 -generate-ca：生成根证书后说明如何导入浏览器，然后退出
+func runGenerateCA(dir string) {
+	cert, err := generateCA(dir)
+	panicIfErr(err, "runGenerateCA")
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	logPrintf("已生成 MITM 根证书 %s 和私钥 %s\n", filepath.Join(dir, mitmCACertFile), filepath.Join(dir, mitmCAKeyFile))
+	logPrintf("根证书的 SHA-256 指纹为 %s，有效期至 %s\n", hex.EncodeToString(fingerprint[:]), cert.NotAfter.UTC().Format(time.RFC3339))
+	logPrintf("把 %s 导入浏览器或系统的受信任根证书后，代理为客户端签发的证书才会被信任。\n", mitmCACertFile)
+	logPrint("私钥可以为任何网站签发证书，请妥善保管，实验结束后记得从信任列表中删除这张根证书。\n")
+}
+
+// mitmCA 是从磁盘读取的 MITM 根证书，按客户端请求的 SNI 现场签发叶子证书
+type mitmCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	// 所有叶子证书共用同一把私钥，省去每个主机名生成一次密钥的开销
+	leafKey *ecdsa.PrivateKey
+
+	mu sync.Mutex
+	// 以主机名为键的叶子证书缓存
+	leaves map[string]*tls.Certificate
+}
+
+// loadMITMCA 读取 dir 下由 -generate-ca 生成的根证书和私钥
+func loadMITMCA(dir string) (*mitmCA, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, mitmCACertFile))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, mitmCAKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s 不是 CA 证书", filepath.Join(dir, mitmCACertFile))
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("不支持的私钥类型 %T", pair.PrivateKey)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &mitmCA{cert: cert, key: key, leafKey: leafKey, leaves: map[string]*tls.Certificate{}}, nil
+}
+
+// leafFor 返回 host 的叶子证书，缓存中没有或即将过期时现场签发一张
+func (ca *mitmCA) leafFor(host string) (*tls.Certificate, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return nil, errors.New("没有主机名，无法签发证书")
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if leaf, ok := ca.leaves[host]; ok && time.Until(leaf.Leaf.NotAfter) > time.Hour {
+		return leaf, nil
+	}
+	leaf, err := ca.mint(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ca.leaves) >= maxMITMLeafCache {
+		// 缓存满了就随便淘汰一个，被淘汰的主机名下次连接时重新签发即可
+		for name := range ca.leaves {
+			delete(ca.leaves, name)
+			break
+		}
+	}
+	ca.leaves[host] = leaf
+	return leaf, nil
+}
+
+// mint 用根证书为 host 签发一张叶子证书。浏览器只看 SAN 而不看 CN，
+// 因此主机名放进 DNSNames，IP 地址放进 IPAddresses（RFC 6125），CN 只是为了方便人阅读
+func (ca *mitmCA) mint(host string) (*tls.Certificate, error) {
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	notAfter := time.Now().Add(mitmLeafValidity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &ca.leafKey.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		// 附上根证书，方便在抓包中看到完整的证书链
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  ca.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
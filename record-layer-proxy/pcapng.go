@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pcapng 块类型和选项（draft-ietf-opsawg-pcapng）
+const (
+	pcapngSectionHeader        uint32 = 0x0A0D0D0A
+	pcapngInterfaceDescription uint32 = 1
+	pcapngNameResolution       uint32 = 4
+	pcapngEnhancedPacket       uint32 = 6
+
+	pcapngOptEnd      uint16 = 0
+	pcapngOptComment  uint16 = 1
+	pcapngShbUserAppl uint16 = 4
+	pcapngIfName      uint16 = 2
+	pcapngIfDesc      uint16 = 3
+	pcapngIfTsresol   uint16 = 9
+
+	pcapngNrbIPv4 uint16 = 1
+	pcapngNrbIPv6 uint16 = 2
+
+	// LINKTYPE_RAW：每个包直接以 IPv4 或 IPv6 头部开始
+	linkTypeRaw uint16 = 101
+)
+
+// TCP 标志位
+const (
+	tcpFIN byte = 0x01
+	tcpSYN byte = 0x02
+	tcpPSH byte = 0x08
+	tcpACK byte = 0x10
+)
+
+// pcapngWriter 把一条连接的记录写成 pcapng 文件，可以直接用 Wireshark 打开。
+// 代理看到的是两条 TCP 连接，这里把它们合成一条“客户端 ↔ 后端”的 TCP 流：开头补上三次握手，
+// 每条记录一个数据包，结束时补上 FIN，并把连接编号、SNI 和连接摘要写进节、接口和数据包的注释中
+type pcapngWriter struct {
+	state *connState
+
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	// 合成的 TCP 流两端的地址和下一个序列号
+	client, server       *net.TCPAddr
+	clientSeq, serverSeq uint32
+}
+
+// newPcapngCapture 在 dir 下为连接创建 conn-<编号>.pcapng
+func newPcapngCapture(dir string, state *connState) (*pcapngWriter, error) {
+	file, err := os.Create(filepath.Join(dir, fmt.Sprintf("conn-%d.pcapng", state.id)))
+	if err != nil {
+		return nil, err
+	}
+	c := &pcapngWriter{
+		state:  state,
+		file:   file,
+		w:      bufio.NewWriter(file),
+		client: state.clientConn.RemoteAddr().(*net.TCPAddr),
+		server: state.serverConn.RemoteAddr().(*net.TCPAddr),
+	}
+
+	c.writeBlock(pcapngSectionHeader, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, 0x1A2B3C4D) // 字节序标记
+		b = binary.LittleEndian.AppendUint16(b, 1)
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		b = binary.LittleEndian.AppendUint64(b, ^uint64(0)) // 节的长度未知
+		b = appendPcapngOption(b, pcapngOptComment, fmt.Sprintf("连接 #%d：%s --> %s（经 record-layer-proxy 转发）", state.id, c.client, c.server))
+		b = appendPcapngOption(b, pcapngShbUserAppl, "learn-tls record-layer-proxy")
+		return appendPcapngOption(b, pcapngOptEnd, "")
+	})
+	c.writeBlock(pcapngInterfaceDescription, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint16(b, linkTypeRaw)
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, 0) // 不截断
+		b = appendPcapngOption(b, pcapngIfName, fmt.Sprintf("conn-%d", state.id))
+		b = appendPcapngOption(b, pcapngIfDesc, "代理两侧的 TCP 连接合成的一条 TCP 流，数据包的边界即记录的边界")
+		b = appendPcapngOption(b, pcapngIfTsresol, "\x09") // 时间戳单位为纳秒
+		return appendPcapngOption(b, pcapngOptEnd, "")
+	})
+
+	c.writePacket(true, tcpSYN, nil, "")
+	c.writePacket(false, tcpSYN|tcpACK, nil, "")
+	c.writePacket(true, tcpACK, nil, "")
+	if err := c.w.Flush(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// writeRecord 把一条记录（含记录层头部）写成一个 TCP 数据包
+func (c *pcapngWriter) writeRecord(fromClient bool, record []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writePacket(fromClient, tcpPSH|tcpACK, record, "")
+	return c.w.Flush()
+}
+
+// Close 写入后端主机名的名称解析块和结束连接的 FIN，FIN 的注释中附上连接摘要
+func (c *pcapngWriter) Close() error {
+	c.state.mu.Lock()
+	sni, comment := c.state.sni, c.state.describeForCapture()
+	c.state.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sni != "" {
+		c.writeBlock(pcapngNameResolution, func(b []byte) []byte {
+			recordType, ip := pcapngNrbIPv4, []byte(c.server.IP.To4())
+			if c.server.IP.To4() == nil || c.client.IP.To4() == nil {
+				recordType, ip = pcapngNrbIPv6, []byte(c.server.IP.To16())
+			}
+			value := append(append(append([]byte(nil), ip...), sni...), 0)
+			b = appendPcapngOption(b, recordType, string(value))
+			b = appendPcapngOption(b, 0, "") // nrb_record_end
+			return appendPcapngOption(b, pcapngOptEnd, "")
+		})
+	}
+	c.writePacket(true, tcpFIN|tcpACK, nil, comment)
+	c.writePacket(false, tcpFIN|tcpACK, nil, "")
+
+	err := c.w.Flush()
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// describeForCapture 返回写入 pcapng 注释的连接摘要，调用时需持有 mu
+func (s *connState) describeForCapture() string {
+	lines := []string{fmt.Sprintf("连接 #%d，持续 %s", s.id, time.Since(s.startTime).Round(time.Millisecond))}
+	if s.sni != "" {
+		lines = append(lines, "SNI："+s.sni)
+	}
+	if s.version != 0 {
+		lines = append(lines, "版本："+formatVersion(s.version), "密码套件："+formatCipherSuite(s.cipherSuite), "ALPN："+s.describeALPN())
+	}
+	if s.ja3Hash != "" {
+		lines = append(lines, "JA3："+s.ja3Hash)
+	}
+	for _, w := range s.warnings {
+		lines = append(lines, "警告："+w)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writePacket 写入一个合成的 TCP 包，并推进发送方的序列号。调用时需持有 c.mu
+func (c *pcapngWriter) writePacket(fromClient bool, flags byte, payload []byte, comment string) {
+	src, dst := c.client, c.server
+	seq, ack := &c.clientSeq, &c.serverSeq
+	if !fromClient {
+		src, dst = dst, src
+		seq, ack = ack, seq
+	}
+	tcp := buildTCPSegment(src, dst, *seq, *ack, flags, payload)
+	*seq += uint32(len(payload))
+	if flags&(tcpSYN|tcpFIN) != 0 {
+		*seq++
+	}
+	packet := buildIPPacket(src.IP, dst.IP, tcp)
+
+	now := uint64(time.Now().UnixNano())
+	c.writeBlock(pcapngEnhancedPacket, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, 0) // 接口编号
+		b = binary.LittleEndian.AppendUint32(b, uint32(now>>32))
+		b = binary.LittleEndian.AppendUint32(b, uint32(now))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(packet)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(packet)))
+		b = appendPadded(b, packet)
+		if comment != "" {
+			b = appendPcapngOption(b, pcapngOptComment, comment)
+			b = appendPcapngOption(b, pcapngOptEnd, "")
+		}
+		return b
+	})
+}
+
+// writeBlock 写入一个 pcapng 块：类型、总长度、块内容、再一次总长度。写入错误由之后的 Flush 返回
+func (c *pcapngWriter) writeBlock(blockType uint32, body func([]byte) []byte) {
+	content := body(nil)
+	total := uint32(12 + len(content))
+	b := binary.LittleEndian.AppendUint32(nil, blockType)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	b = append(b, content...)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	_, _ = c.w.Write(b)
+}
+
+// appendPcapngOption 写入一个选项：代码、长度和补齐到 4 字节的值
+func appendPcapngOption(b []byte, code uint16, value string) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return appendPadded(b, []byte(value))
+}
+
+// appendPadded 写入 data 并用 0 补齐到 4 字节的整数倍
+func appendPadded(b, data []byte) []byte {
+	b = append(b, data...)
+	for n := len(data); n%4 != 0; n++ {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// buildTCPSegment 构造一个不带选项的 TCP 头部加载荷，并计算校验和
+func buildTCPSegment(src, dst *net.TCPAddr, seq, ack uint32, flags byte, payload []byte) []byte {
+	seg := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(seg[0:2], uint16(src.Port))
+	binary.BigEndian.PutUint16(seg[2:4], uint16(dst.Port))
+	binary.BigEndian.PutUint32(seg[4:8], seq)
+	if flags&tcpACK != 0 {
+		binary.BigEndian.PutUint32(seg[8:12], ack)
+	}
+	seg[12] = 5 << 4 // 头部长度 20 字节
+	seg[13] = flags
+	binary.BigEndian.PutUint16(seg[14:16], 65535) // 窗口
+	seg = append(seg, payload...)
+
+	// 校验和覆盖伪首部：源地址、目的地址、协议号和 TCP 长度
+	var pseudo []byte
+	pseudo = append(pseudo, ipBytes(src.IP, dst.IP)...)
+	pseudo = append(pseudo, ipBytes(dst.IP, src.IP)...)
+	pseudo = append(pseudo, 0, 6)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(seg)))
+	binary.BigEndian.PutUint16(seg[16:18], internetChecksum(append(pseudo, seg...)))
+	return seg
+}
+
+// buildIPPacket 给 TCP 段加上 IPv4 或 IPv6 头部
+func buildIPPacket(src, dst net.IP, tcp []byte) []byte {
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		h := make([]byte, 20, 20+len(tcp))
+		h[0] = 0x45 // 版本 4，头部长度 20 字节
+		binary.BigEndian.PutUint16(h[2:4], uint16(20+len(tcp)))
+		h[6] = 0x40 // 不分片
+		h[8] = 64   // TTL
+		h[9] = 6    // TCP
+		copy(h[12:16], src4)
+		copy(h[16:20], dst4)
+		binary.BigEndian.PutUint16(h[10:12], internetChecksum(h))
+		return append(h, tcp...)
+	}
+	h := make([]byte, 40, 40+len(tcp))
+	h[0] = 0x60 // 版本 6
+	binary.BigEndian.PutUint16(h[4:6], uint16(len(tcp)))
+	h[6] = 6  // 下一个头部为 TCP
+	h[7] = 64 // 跳数限制
+	copy(h[8:24], src.To16())
+	copy(h[24:40], dst.To16())
+	return append(h, tcp...)
+}
+
+// ipBytes 返回 ip 在伪首部中的字节：两端都是 IPv4 时为 4 字节，否则为 16 字节
+func ipBytes(ip, other net.IP) []byte {
+	if ip4, other4 := ip.To4(), other.To4(); ip4 != nil && other4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// internetChecksum 计算 RFC 1071 的校验和
+func internetChecksum(data []byte) uint16 {
+	var sum uint32
+	for ; len(data) >= 2; data = data[2:] {
+		sum += uint32(data[0])<<8 | uint32(data[1])
+	}
+	if len(data) == 1 {
+		sum += uint32(data[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// sniPolicy 根据 ClientHello 中的 SNI 决定是否放行连接
+type sniPolicy struct {
+	// 为空表示不限制，否则只放行与其中某一项匹配的 SNI
+	allow []string
+	// 与其中任意一项匹配的 SNI 都会被拒绝，优先于 allow
+	deny []string
+	// 拒绝连接时发送给客户端的警报
+	alert alertSpec
+}
+
+// parseSNIPatterns 解析以逗号分隔的主机名列表，每一项可以是精确的主机名或 path.Match 风格的通配符
+func parseSNIPatterns(s string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(s, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("无效的 SNI 通配符 %q：%w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func matchSNI(patterns []string, sni string) (string, bool) {
+	for _, pattern := range patterns {
+		// 通配符已在解析时校验过，这里不会出错
+		if matched, _ := path.Match(pattern, sni); matched {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// check 判断 sni 是否放行，不放行时同时返回原因。sni 为空表示 ClientHello 中没有 SNI
+func (p *sniPolicy) check(sni string) (bool, string) {
+	sni = strings.ToLower(sni)
+	display := sni
+	if display == "" {
+		display = "（无 SNI）"
+	}
+
+	if pattern, matched := matchSNI(p.deny, sni); matched {
+		return false, fmt.Sprintf("%s 匹配拒绝列表中的 %q", display, pattern)
+	}
+	if len(p.allow) > 0 {
+		if _, matched := matchSNI(p.allow, sni); !matched {
+			return false, fmt.Sprintf("%s 不在允许列表中", display)
+		}
+	}
+	return true, ""
+}
+
+// ja3Policy 只放行 JA3 哈希在允许列表中的客户端
+type ja3Policy struct {
+	allow map[string]bool
+	// 拒绝连接时发送给客户端的警报
+	alert alertSpec
+}
+
+// loadJA3Allowlist 读取 JA3 允许列表文件：每行一个 32 位十六进制的 JA3 哈希，# 之后的内容是注释
+func loadJA3Allowlist(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	allow := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" {
+			continue
+		}
+		if b, err := hex.DecodeString(line); err != nil || len(b) != 16 {
+			return nil, fmt.Errorf("%s 第 %d 行：%q 不是 JA3 哈希（32 位十六进制的 MD5）", path, lineNum, line)
+		}
+		allow[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(allow) == 0 {
+		return nil, fmt.Errorf("%s 中没有任何 JA3 哈希", path)
+	}
+	return allow, nil
+}
+
+// check 判断 ClientHello 的 JA3 哈希是否放行，不放行时同时返回原因。hello 为 nil 表示 ClientHello 无法解析，一律拒绝
+func (p *ja3Policy) check(hello *clientHello) (bool, string) {
+	if hello == nil {
+		return false, "ClientHello 无法解析，算不出 JA3"
+	}
+	if _, hash := ja3(hello); !p.allow[hash] {
+		return false, fmt.Sprintf("JA3 哈希 %s 不在允许列表中", hash)
+	}
+	return true, ""
+}
+
+// versionPolicy 要求连接至少使用 min 版本：客户端提供的最高版本或服务器选定的版本低于它时，发送 protocol_version 警报并断开
+type versionPolicy struct {
+	min   uint16
+	alert alertSpec
+}
+
+// maxOfferedVersion 返回客户端提供的最高版本：携带 supported_versions 时取其中（GREASE 除外）最高的，否则为 legacy_version
+func maxOfferedVersion(hello *clientHello) uint16 {
+	versions := hello.supportedVersions()
+	if versions == nil {
+		return hello.legacyVersion
+	}
+	var max uint16
+	for _, v := range versions {
+		if !isGREASE(v) && v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// check 判断 ClientHello 提供的最高版本是否达到下限，不放行时同时返回原因。hello 为 nil 表示 ClientHello 无法解析，一律拒绝
+func (p *versionPolicy) check(hello *clientHello) (bool, string) {
+	if hello == nil {
+		return false, "ClientHello 无法解析，看不出客户端提供的版本"
+	}
+	offered := formatVersion(hello.legacyVersion) + "（legacy_version，没有 supported_versions 扩展）"
+	if versions := hello.supportedVersions(); versions != nil {
+		offered = formatVersionList(versions) + "（supported_versions）"
+	}
+	if max := maxOfferedVersion(hello); max < p.min {
+		return false, fmt.Sprintf("客户端提供的版本为 %s，最高只到 %s，低于 -min-version 要求的 %s", offered, formatVersion(max), formatVersion(p.min))
+	}
+	return true, ""
+}
+
+// checkServer 判断服务器在 ServerHello 中选定的版本是否达到下限，不放行时同时返回原因。
+// 客户端同时提供了高低两个版本时，服务器仍可能选择较低的那个，因此只检查 ClientHello 是不够的
+func (p *versionPolicy) checkServer(version uint16) (bool, string) {
+	if version < p.min {
+		return false, fmt.Sprintf("服务器选择了 %s，低于 -min-version 要求的 %s", formatVersion(version), formatVersion(p.min))
+	}
+	return true, ""
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestProxyRealTLS 在本机用 crypto/tls 搭建“客户端 → 代理 → 服务器”的链路，分别以 TLS 1.2 和 TLS 1.3 完成一次握手并回显数据，
+// 检查代理没有破坏真实的 TLS 流量，并检查解析器从标准库产生的流量中认出了预期的记录和握手消息
+func TestProxyRealTLS(t *testing.T) {
+	cert, pool, err := localhostCertificate(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version uint16
+		// 日志中必须出现的内容
+		want []string
+	}{
+		{versionTLS12, []string{
+			"转发了记录层数据，内容类型：Handshake (22)，版本：TLS 1.0 (0x0301)",
+			"握手消息：Client Hello (1)，长度",
+			"握手消息：Server Hello (2)，长度",
+			"握手消息：Certificate (11)，长度",
+			"内容类型：Change Cipher Spec (20)",
+			"加密的 Finished",
+			"内容类型：Application Data (23)",
+			"SNI=localhost",
+			"版本 TLS 1.2 (0x0303)",
+			"握手耗时",
+		}},
+		{versionTLS13, []string{
+			"转发了记录层数据，内容类型：Handshake (22)，版本：TLS 1.0 (0x0301)",
+			"握手消息：Client Hello (1)，长度",
+			"握手消息：Server Hello (2)，长度",
+			"内容类型：Application Data (23)",
+			"SNI=localhost",
+			"版本 TLS 1.3 (0x0304)",
+			"握手耗时",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(formatVersion(tt.version), func(t *testing.T) {
+			log := proxyOneTLSConn(t, tt.version, cert, pool)
+			for _, want := range tt.want {
+				if !strings.Contains(log, want) {
+					t.Errorf("日志中没有 %q，日志：\n%s", want, log)
+				}
+			}
+		})
+	}
+}
+
+// proxyOneTLSConn 以指定版本经过 handleNewIncomingConn 完成一条连接，返回代理在这期间输出的日志
+func proxyOneTLSConn(t *testing.T, version uint16, cert tls.Certificate, pool *x509.CertPool) string {
+	serverListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+		MaxVersion:   version,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverListener.Close()
+
+	go func() {
+		conn, err := serverListener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	proxyListener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyListener.Close()
+
+	// 代理的日志写入 buf，连接关闭、摘要输出后再检查其中的内容
+	var buf bytes.Buffer
+	oldLogger := setLogOutput(&buf)
+	defer setLogger(oldLogger)
+
+	proxyDone := make(chan struct{})
+	go func() {
+		defer close(proxyDone)
+		conn, err := proxyListener.AcceptTCP()
+		if err != nil {
+			return
+		}
+		handleNewIncomingConn(conn, serverListener.Addr().String())
+	}()
+
+	if err := echoThroughProxy(proxyListener.Addr().String(), version, pool); err != nil {
+		t.Errorf("经过代理的 TLS 连接失败：%v", err)
+	}
+	select {
+	case <-proxyDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("客户端关闭连接 5 秒后代理仍未结束该连接")
+	}
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	return buf.String()
+}
+
+// echoThroughProxy 经过代理连接服务器，发送一段数据并检查回显的内容是否一致
+func echoThroughProxy(proxyAddr string, version uint16, pool *x509.CertPool) error {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", proxyAddr, &tls.Config{
+		ServerName: "localhost",
+		RootCAs:    pool,
+		MinVersion: version,
+		MaxVersion: version,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if got := conn.ConnectionState().Version; got != version {
+		return fmt.Errorf("协商出的版本是 %s", formatVersion(got))
+	}
+
+	// 超过一条记录的最大长度，确保至少有一条消息被拆成多条记录
+	message := bytes.Repeat([]byte("learn-tls-with-go "), maxPlaintextLength/8)
+	if _, err := conn.Write(message); err != nil {
+		return err
+	}
+	echo := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, echo); err != nil {
+		return err
+	}
+	if !bytes.Equal(echo, message) {
+		return fmt.Errorf("回显的数据与发送的不一致")
+	}
+	return nil
+}
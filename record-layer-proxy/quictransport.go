@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// extQUICTransportParameters 是 quic_transport_parameters 扩展（RFC 9001 8.2）。QUIC 的握手就是 TLS 1.3，
+// 但握手消息放在 QUIC 的 CRYPTO 帧中传输，不使用 TLS 记录层；QUIC 的传输参数（流量控制窗口、空闲超时等）
+// 则借这个扩展随 ClientHello 和 EncryptedExtensions 交换。本代理只转发 TCP 上的 TLS，并不代理 QUIC，
+// 在这里看到它通常是因为 QUIC 协议栈的 TLS 实现在 TCP 连接上也带上了这个扩展
+const extQUICTransportParameters uint16 = 57
+
+// quicTransportParameter 是一个传输参数，value 的含义取决于 id，大多数是一个 QUIC 变长整数
+type quicTransportParameter struct {
+	id    uint64
+	value []byte
+}
+
+// quicTransportParameters 解析 quic_transport_parameters 扩展的内容：一串 id、长度、值，id 和长度都是 QUIC 变长整数。
+// 格式错误时 ok 为 false
+func quicTransportParameters(data []byte) (params []quicTransportParameter, ok bool) {
+	r := byteReader(data)
+	for !r.empty() {
+		var param quicTransportParameter
+		var length uint64
+		if !r.readQUICVarint(&param.id) || !r.readQUICVarint(&length) || length > uint64(len(r)) {
+			return nil, false
+		}
+		if !r.readBytes(int(length), &param.value) {
+			return nil, false
+		}
+		params = append(params, param)
+	}
+	return params, true
+}
+
+// String 返回形如 “initial_max_data (0x04) = 1048576” 的描述，值不是单个变长整数时只给出长度
+func (p quicTransportParameter) String() string {
+	name := lookupName(QUIC_TRANSPORT_PARAMETER_TABLE, p.id)
+	// 保留的 GREASE 参数编号为 31 * N + 27（RFC 9000 18.1）
+	if p.id%31 == 27 {
+		name = "GREASE"
+	}
+	r := byteReader(p.value)
+	var v uint64
+	if len(p.value) > 0 && r.readQUICVarint(&v) && r.empty() {
+		return fmt.Sprintf("%s (0x%02x) = %d", name, p.id, v)
+	}
+	return fmt.Sprintf("%s (0x%02x)，%d 字节", name, p.id, len(p.value))
+}
+
+// describeQUICTransportParameters 返回扩展的描述，格式错误时只给出长度
+func describeQUICTransportParameters(data []byte) string {
+	params, ok := quicTransportParameters(data)
+	if !ok {
+		return fmt.Sprintf("%d 字节，格式错误，无法解析", len(data))
+	}
+	var parts []string
+	for _, param := range params {
+		parts = append(parts, param.String())
+	}
+	return fmt.Sprintf("%d 字节，%d 个传输参数：%s", len(data), len(params), strings.Join(parts, "、"))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// randomLRU 记住最近见过的 ClientHello random，用于 -detect-random-reuse 发现不同连接间重复的 random。
+// random 本应由 32 个随机字节组成，两条连接出现相同的值几乎不可能是巧合，
+// 通常意味着有人重放了整条 ClientHello，或者客户端的随机数生成器有问题
+type randomLRU struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List // 元素为 *randomSighting，最近见过的在前
+	entries map[[32]byte]*list.Element
+}
+
+// randomSighting 是某个 random 第一次出现时的连接信息
+type randomSighting struct {
+	random     [32]byte
+	connID     uint64
+	clientAddr string
+	seenAt     time.Time
+}
+
+func newRandomLRU(capacity int) *randomLRU {
+	return &randomLRU{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[[32]byte]*list.Element, capacity),
+	}
+}
+
+// observe 记下一个 random。该 random 之前出现过时返回之前那次的信息，否则返回 nil。
+// 超出容量时淘汰最久未见的 random，因此内存占用有上限
+func (l *randomLRU) observe(random []byte, connID uint64, clientAddr string) *randomSighting {
+	if len(random) != 32 {
+		return nil
+	}
+	var key [32]byte
+	copy(key[:], random)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.entries[key]; ok {
+		l.order.MoveToFront(elem)
+		previous := *elem.Value.(*randomSighting)
+		return &previous
+	}
+
+	l.entries[key] = l.order.PushFront(&randomSighting{random: key, connID: connID, clientAddr: clientAddr, seenAt: time.Now()})
+	if l.order.Len() > l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*randomSighting).random)
+	}
+	return nil
+}
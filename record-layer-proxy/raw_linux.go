@@ -0,0 +1,11 @@
+//go:build linux
+
+package main
+
+import "net"
+
+// rawCopy 把 from 读到的数据原样写入 to，直到 from 读完或出错。
+// 两端都是 *net.TCPConn 时，TCPConn.ReadFrom 会使用 splice(2) 在内核中直接搬运数据，不经过用户态缓冲区
+func rawCopy(from, to *net.TCPConn) (int64, error) {
+	return to.ReadFrom(from)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"io"
+	"net"
+)
+
+// rawCopy 把 from 读到的数据原样写入 to，直到 from 读完或出错。
+// 非 Linux 平台没有 splice(2)，这里使用普通的缓冲复制
+func rawCopy(from, to *net.TCPConn) (int64, error) {
+	buf := make([]byte, 32*1024)
+	// 包装一层以隐藏 ReadFrom/WriteTo，确保走的是下面这个缓冲区
+	return io.CopyBuffer(struct{ io.Writer }{to}, struct{ io.Reader }{from}, buf)
+}
@@ -0,0 +1,102 @@
+package main
+
+// byteReader 是一个简单的大端字节读取器，用来解析 TLS 中大量带长度前缀的结构。
+// 每个读取方法在数据不足时返回 false 且不消耗任何数据，用法类似 x/crypto/cryptobyte
+type byteReader []byte
+
+func (r *byteReader) readBytes(n int, out *[]byte) bool {
+	if n < 0 || len(*r) < n {
+		return false
+	}
+	*out = (*r)[:n:n]
+	*r = (*r)[n:]
+	return true
+}
+
+func (r *byteReader) readUint8(out *uint8) bool {
+	var v []byte
+	if !r.readBytes(1, &v) {
+		return false
+	}
+	*out = v[0]
+	return true
+}
+
+func (r *byteReader) readUint16(out *uint16) bool {
+	var v []byte
+	if !r.readBytes(2, &v) {
+		return false
+	}
+	*out = uint16(v[0])<<8 | uint16(v[1])
+	return true
+}
+
+func (r *byteReader) readUint24(out *uint32) bool {
+	var v []byte
+	if !r.readBytes(3, &v) {
+		return false
+	}
+	*out = uint32(v[0])<<16 | uint32(v[1])<<8 | uint32(v[2])
+	return true
+}
+
+func (r *byteReader) readUint32(out *uint32) bool {
+	var v []byte
+	if !r.readBytes(4, &v) {
+		return false
+	}
+	*out = uint32(v[0])<<24 | uint32(v[1])<<16 | uint32(v[2])<<8 | uint32(v[3])
+	return true
+}
+
+// readLengthPrefixed 读取一个 lenBytes 字节长度前缀的变长字段，字段内容存入 out
+func (r *byteReader) readLengthPrefixed(lenBytes int, out *byteReader) bool {
+	if len(*r) < lenBytes {
+		return false
+	}
+
+	length := 0
+	for _, b := range (*r)[:lenBytes] {
+		length = length<<8 | int(b)
+	}
+	if len(*r) < lenBytes+length {
+		return false
+	}
+
+	*out = (*r)[lenBytes : lenBytes+length : lenBytes+length]
+	*r = (*r)[lenBytes+length:]
+	return true
+}
+
+func (r *byteReader) readUint8LengthPrefixed(out *byteReader) bool {
+	return r.readLengthPrefixed(1, out)
+}
+
+func (r *byteReader) readUint16LengthPrefixed(out *byteReader) bool {
+	return r.readLengthPrefixed(2, out)
+}
+
+func (r *byteReader) readUint24LengthPrefixed(out *byteReader) bool {
+	return r.readLengthPrefixed(3, out)
+}
+
+func (r *byteReader) empty() bool {
+	return len(*r) == 0
+}
+
+// readQUICVarint 读取一个 QUIC 变长整数（RFC 9000 16）：首字节的高两位决定总长度为 1、2、4 或 8 字节
+func (r *byteReader) readQUICVarint(out *uint64) bool {
+	if len(*r) == 0 {
+		return false
+	}
+	var v []byte
+	if !r.readBytes(1<<((*r)[0]>>6), &v) {
+		return false
+	}
+	n := uint64(v[0] & 0x3f)
+	for _, b := range v[1:] {
+		n = n<<8 | uint64(b)
+	}
+	*out = n
+	return true
+}
@@ -0,0 +1,148 @@
+package recordproxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event 是 Proxy 转发的一条记录。Payload 是记录载荷的副本，订阅者可以随意保留或修改
+type Event struct {
+	// 连接编号，同一个 Proxy 中从 1 开始递增
+	ConnID uint64
+	Time   time.Time
+	// 是否由客户端发出
+	FromClient bool
+	Header
+	Payload []byte
+	// 载荷是否是加密的：Application Data 记录，以及该方向的 ChangeCipherSpec 之后的所有记录。
+	// TLS 1.3 中加密的记录外层都是 Application Data，因此不需要知道协商出的版本
+	Encrypted bool
+	// 明文握手记录中的握手消息头，按出现的顺序排列。跨越多条记录的消息只在它的消息头完整出现的那条记录中出现一次，
+	// 因此只含上一条消息后续部分的记录没有握手消息头
+	Handshakes []HandshakeHeader
+	// 载荷恰好为 2 字节的明文警报记录解码出的警报，其他记录为 nil
+	Alert *Alert
+}
+
+// HandshakeHeader 是一条握手消息的消息头
+type HandshakeHeader struct {
+	Type byte
+	// 消息体的长度，不含 4 字节的消息头
+	Length int
+}
+
+// Alert 是一条明文警报
+type Alert struct {
+	Level       byte
+	Description byte
+}
+
+// Subscription 是一个记录事件的订阅。发布事件时从不阻塞转发：订阅者的缓冲区已满时，
+// 这条事件对该订阅者直接丢弃并计入 Dropped，因此处理较慢的订阅者会漏掉事件，但不会拖慢代理
+type Subscription struct {
+	owner   *subscribers
+	events  chan Event
+	dropped atomic.Uint64
+	once    sync.Once
+}
+
+// subscribers 是一个 Proxy 当前所有的订阅，零值即可使用。count 让没有订阅者时的转发路径不必加锁
+type subscribers struct {
+	mu    sync.RWMutex
+	subs  map[*Subscription]struct{}
+	count atomic.Int32
+}
+
+func (s *subscribers) subscribe(buffer int) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	sub := &Subscription{owner: s, events: make(chan Event, buffer)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = map[*Subscription]struct{}{}
+	}
+	s.subs[sub] = struct{}{}
+	s.count.Add(1)
+	return sub
+}
+
+func (s *subscribers) has() bool {
+	return s.count.Load() > 0
+}
+
+// publish 把一条事件发给所有订阅者，从不阻塞。每个订阅者收到的 Payload 都是 e.Payload 的一份副本
+func (s *subscribers) publish(e Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	payload := e.Payload
+	for sub := range s.subs {
+		e.Payload = append([]byte(nil), payload...)
+		select {
+		case sub.events <- e:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Events 返回事件通道，Close 之后通道会被关闭
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped 返回因缓冲区已满而丢弃的事件数
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close 取消订阅并关闭事件通道，可以重复调用
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.owner.mu.Lock()
+		defer s.owner.mu.Unlock()
+		delete(s.owner.subs, s)
+		s.owner.count.Add(-1)
+		// 发布时持有读锁，这里持有写锁，因此不会有人向已关闭的通道发送
+		close(s.events)
+	})
+}
+
+// handshakeFramer 跟踪一个方向上明文握手消息的边界。握手消息可以跨越多条记录，消息头本身也可能被记录边界截断
+type handshakeFramer struct {
+	// 当前消息还有多少字节的消息体在后续记录中
+	remaining int
+	// 被记录边界截断的消息头
+	partial  [4]byte
+	nPartial int
+}
+
+// feed 处理一条明文握手记录的载荷，返回其中完整出现的握手消息头
+func (f *handshakeFramer) feed(payload []byte) []HandshakeHeader {
+	var headers []HandshakeHeader
+	for len(payload) > 0 {
+		if f.remaining > 0 {
+			n := f.remaining
+			if n > len(payload) {
+				n = len(payload)
+			}
+			f.remaining -= n
+			payload = payload[n:]
+			continue
+		}
+
+		n := copy(f.partial[f.nPartial:], payload)
+		f.nPartial += n
+		payload = payload[n:]
+		if f.nPartial < len(f.partial) {
+			break
+		}
+		f.nPartial = 0
+		h := HandshakeHeader{Type: f.partial[0], Length: int(f.partial[1])<<16 | int(f.partial[2])<<8 | int(f.partial[3])}
+		headers = append(headers, h)
+		f.remaining = h.Length
+	}
+	return headers
+}
@@ -0,0 +1,93 @@
+package recordproxy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPublishDropsWhenBufferIsFull(t *testing.T) {
+	var p Proxy
+	slow := p.Subscribe(2)
+	defer slow.Close()
+	fast := p.Subscribe(10)
+	defer fast.Close()
+
+	// 没有人从 slow 读取事件，发布仍然不能阻塞
+	const total = 5
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < total; i++ {
+			p.subs.publish(Event{ConnID: uint64(i), Payload: []byte{byte(i)}})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("订阅者的缓冲区已满时 publish 被阻塞")
+	}
+
+	if got := slow.Dropped(); got != total-2 {
+		t.Errorf("slow.Dropped() = %d，期望 %d", got, total-2)
+	}
+	if got := len(slow.Events()); got != 2 {
+		t.Errorf("slow 的缓冲区中有 %d 条事件，期望 2 条", got)
+	}
+	if got := fast.Dropped(); got != 0 {
+		t.Errorf("fast.Dropped() = %d，期望 0", got)
+	}
+
+	// 缓冲区中保留的是最早的事件，每个订阅者的载荷互不影响
+	first := <-slow.Events()
+	first.Payload[0] = 0xFF
+	if e := <-fast.Events(); e.ConnID != 0 || e.Payload[0] != 0 {
+		t.Errorf("fast 收到的第一条事件为 %+v，期望 ConnID 0、载荷 [0]", e)
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	var p, other Proxy
+	sub := p.Subscribe(1)
+	if !p.subs.has() {
+		t.Fatal("订阅之后应有订阅者")
+	}
+	if other.subs.has() {
+		t.Fatal("订阅一个 Proxy 不应影响另一个 Proxy")
+	}
+	sub.Close()
+	sub.Close()
+	if p.subs.has() {
+		t.Fatal("取消订阅之后不应再有订阅者")
+	}
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("Close 之后事件通道应被关闭")
+	}
+	// 没有订阅者时发布不会出错
+	p.subs.publish(Event{})
+}
+
+func TestHandshakeFramer(t *testing.T) {
+	var f handshakeFramer
+	records := []struct {
+		payload []byte
+		want    []HandshakeHeader
+	}{
+		// 一条记录中有两条完整的消息
+		{[]byte{1, 0, 0, 2, 0xAA, 0xBB, 2, 0, 0, 0}, []HandshakeHeader{{1, 2}, {2, 0}}},
+		// 消息体跨越了三条记录
+		{[]byte{11, 0, 0, 5, 0xAA}, []HandshakeHeader{{11, 5}}},
+		{[]byte{0xBB, 0xCC}, nil},
+		// 上一条消息的最后两个字节之后，下一条消息的消息头被截断
+		{[]byte{0xDD, 0xEE, 14, 0}, nil},
+		{[]byte{0, 0}, []HandshakeHeader{{14, 0}}},
+	}
+	for i, r := range records {
+		if got := f.feed(r.payload); !reflect.DeepEqual(got, r.want) {
+			t.Errorf("第 %d 条记录的握手消息头为 %+v，期望 %+v", i+1, got, r.want)
+		}
+	}
+	if f.remaining != 0 || f.nPartial != 0 {
+		t.Errorf("所有消息都已结束，remaining = %d，nPartial = %d", f.remaining, f.nPartial)
+	}
+}
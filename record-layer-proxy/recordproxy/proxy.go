@@ -0,0 +1,175 @@
+package recordproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// MaxRecordLength 是 TLS 1.2 允许的最大记录载荷长度（RFC 5246 6.2.3：明文 2^14 字节，加密后最多再多 2048 字节）。
+// Proxy 遇到更长的记录时认为流量不是 TLS 或已被破坏
+const MaxRecordLength = 16384 + 2048
+
+const (
+	contentTypeChangeCipherSpec = 20
+	contentTypeAlert            = 21
+	contentTypeHandshake        = 22
+	contentTypeApplicationData  = 23
+)
+
+// Proxy 是一个可以嵌入其他程序的 TLS 记录层代理：把每条客户端连接转发到 Backend，两个方向都逐条记录转发，
+// 并把每条记录作为 Event 发给该 Proxy 的订阅者。零值在设置 Backend 后即可使用，不同的 Proxy 之间互不影响。
+//
+// Proxy 只做分帧和头部的解码（握手消息头、明文警报）。record-layer-proxy 命令行工具中的解析、改写、
+// -mitm、-raw、-count-only 等功能都不在这里，工具本身也不发布事件，只使用本包的分帧函数
+type Proxy struct {
+	// 后端地址（host:port）
+	Backend string
+	// 连接后端所用的 Dialer，为 nil 时使用零值的 net.Dialer
+	Dialer *net.Dialer
+
+	subs   subscribers
+	nextID atomic.Uint64
+}
+
+// Subscribe 订阅此后该 Proxy 转发的所有记录，buffer 是事件通道的缓冲区大小（至少为 1）。
+// 不再需要时必须调用 Close，之后事件通道会被关闭
+func (p *Proxy) Subscribe(buffer int) *Subscription {
+	return p.subs.subscribe(buffer)
+}
+
+// Serve 接受 l 上的连接，为每条连接启动一个 goroutine 调用 HandleConn，直到 Accept 返回错误（例如 l 被关闭）时返回该错误。
+// 与 http.Server.Serve 相同，Accept 遇到临时错误时等待一段时间后重试。HandleConn 返回的错误被忽略，
+// 需要这些错误时请自行接受连接并调用 HandleConn
+func (p *Proxy) Serve(l net.Listener) error {
+	var tempDelay time.Duration
+	for {
+		conn, err := l.Accept()
+		var temporary interface{ Temporary() bool }
+		if err != nil && errors.As(err, &temporary) && temporary.Temporary() {
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if tempDelay > time.Second {
+				tempDelay = time.Second
+			}
+			time.Sleep(tempDelay)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		tempDelay = 0
+		go func() { _ = p.HandleConn(conn) }()
+	}
+}
+
+// HandleConn 连接 Backend，在 client 与后端之间逐条转发记录，直到两个方向都结束后关闭两条连接。
+// 一个方向的对端正常关闭时，只关闭另一条连接的写方向（对方不支持时整条关闭），让对端也能正常结束；
+// 任一方向出错时关闭两条连接，并返回第一个错误。
+// 某个方向出现超过 MaxRecordLength 的记录时，此后该方向原样转发剩余的数据，不再发布事件
+func (p *Proxy) HandleConn(client net.Conn) error {
+	defer client.Close()
+	dialer := p.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	backend, err := dialer.Dial("tcp", p.Backend)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	id := p.nextID.Add(1)
+	errs := make(chan error, 2)
+	go func() { errs <- p.forward(id, client, backend, true) }()
+	go func() { errs <- p.forward(id, backend, client, false) }()
+
+	var first error
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			// 让另一个方向也尽快结束，它随后返回的错误是关闭连接造成的，不再报告
+			_ = client.Close()
+			_ = backend.Close()
+		}
+	}
+	return first
+}
+
+// forward 把 src 中的记录逐条转发到 dst，src 在记录边界上正常关闭时返回 nil
+func (p *Proxy) forward(id uint64, src, dst net.Conn, fromClient bool) error {
+	buf := make([]byte, HeaderLength+MaxRecordLength)
+	var handshakes handshakeFramer
+	afterCCS := false
+
+	for {
+		h, n, err := ReadHeader(src, buf)
+		if err == io.EOF && n == 0 {
+			closeWrite(dst)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if h.Length > MaxRecordLength {
+			// 之后的数据已经无法可靠地分帧，原样转发
+			if _, err := dst.Write(buf[:HeaderLength]); err != nil {
+				return err
+			}
+			if _, err := io.Copy(dst, src); err != nil {
+				return err
+			}
+			closeWrite(dst)
+			return nil
+		}
+
+		record := buf[:HeaderLength+h.Length]
+		if _, err := io.ReadFull(src, record[HeaderLength:]); err != nil {
+			return err
+		}
+		if _, err := dst.Write(record); err != nil {
+			return err
+		}
+
+		payload := record[HeaderLength:]
+		e := Event{
+			ConnID:     id,
+			FromClient: fromClient,
+			Header:     h,
+			Encrypted:  afterCCS || h.ContentType == contentTypeApplicationData,
+		}
+		if !e.Encrypted {
+			switch h.ContentType {
+			case contentTypeHandshake:
+				// 没有订阅者时也要跟踪消息边界，这样中途订阅时仍能正确地找到消息头
+				e.Handshakes = handshakes.feed(payload)
+			case contentTypeAlert:
+				if len(payload) == 2 {
+					e.Alert = &Alert{Level: payload[0], Description: payload[1]}
+				}
+			case contentTypeChangeCipherSpec:
+				afterCCS = true
+			}
+		}
+		if p.subs.has() {
+			e.Time = time.Now()
+			e.Payload = payload
+			p.subs.publish(e)
+		}
+	}
+}
+
+// closeWrite 关闭 conn 的写方向，conn 不支持半关闭时整条关闭
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
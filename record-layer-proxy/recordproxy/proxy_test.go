@@ -0,0 +1,189 @@
+package recordproxy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestProxyEvents 以 TLS 1.2 和 TLS 1.3 经过 Proxy 完成一次握手并回显数据，检查订阅者收到的事件
+func TestProxyEvents(t *testing.T) {
+	cert, pool := testCertificate(t)
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		t.Run(tls.VersionName(version), func(t *testing.T) {
+			var p, other Proxy
+			sub := p.Subscribe(1024)
+			defer sub.Close()
+			otherSub := other.Subscribe(1)
+			defer otherSub.Close()
+
+			echoThroughProxy(t, &p, version, cert, pool)
+			sub.Close()
+
+			var events []Event
+			for e := range sub.Events() {
+				events = append(events, e)
+			}
+			if len(events) == 0 {
+				t.Fatal("没有收到任何事件")
+			}
+			if e := events[0]; !e.FromClient || e.ContentType != contentTypeHandshake || e.Encrypted ||
+				len(e.Handshakes) != 1 || e.Handshakes[0].Type != 1 || e.Handshakes[0].Length != e.Length-4 {
+				t.Errorf("第一条事件应为客户端的 ClientHello，实际为 %+v", e)
+			}
+
+			sawServerHello, sawEncryptedData, sawCloseNotify := false, false, false
+			for _, e := range events {
+				if e.ConnID != 1 || len(e.Payload) != e.Length {
+					t.Errorf("事件的连接编号或载荷长度不正确：%+v", e)
+				}
+				for _, h := range e.Handshakes {
+					sawServerHello = sawServerHello || (!e.FromClient && h.Type == 2)
+				}
+				sawEncryptedData = sawEncryptedData || (e.Encrypted && e.ContentType == contentTypeApplicationData)
+				// TLS 1.2 的 close_notify 是加密的，不解码
+				if e.Alert != nil && e.Alert.Description == 0 {
+					sawCloseNotify = true
+				}
+				if e.Encrypted && (e.Handshakes != nil || e.Alert != nil) {
+					t.Errorf("加密的记录不应解码出握手消息头或警报：%+v", e)
+				}
+			}
+			if !sawServerHello || !sawEncryptedData {
+				t.Errorf("没有看到服务器的 ServerHello（%v）或加密的应用数据（%v）", sawServerHello, sawEncryptedData)
+			}
+			if sawCloseNotify {
+				t.Error("加密的 close_notify 被当作明文解码了")
+			}
+			if n := len(otherSub.Events()); n != 0 {
+				t.Errorf("另一个 Proxy 的订阅者收到了 %d 条事件", n)
+			}
+		})
+	}
+}
+
+// TestSlowSubscriber 检查处理很慢的订阅者不会拖慢转发：缓冲区只有一条事件且从不读取，
+// 连接仍能完成握手并回显数据，多出的事件被丢弃
+func TestSlowSubscriber(t *testing.T) {
+	cert, pool := testCertificate(t)
+	var p Proxy
+	sub := p.Subscribe(1)
+	defer sub.Close()
+
+	echoThroughProxy(t, &p, tls.VersionTLS13, cert, pool)
+	if got := len(sub.Events()); got != 1 {
+		t.Errorf("缓冲区中有 %d 条事件，期望 1 条", got)
+	}
+	if sub.Dropped() == 0 {
+		t.Error("缓冲区已满后的事件应被丢弃")
+	}
+}
+
+// echoThroughProxy 让 p 在本机的随机端口上转发到一个 crypto/tls 回显服务器，经过它发送一段数据并检查回显，
+// 返回时 p 已经处理完这条连接
+func echoThroughProxy(t *testing.T, p *Proxy, version uint16, cert tls.Certificate, pool *x509.CertPool) {
+	t.Helper()
+	serverListener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+		MaxVersion:   version,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverListener.Close()
+	go func() {
+		conn, err := serverListener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	proxyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyListener.Close()
+	p.Backend = serverListener.Addr().String()
+	proxyDone := make(chan error, 1)
+	go func() {
+		conn, err := proxyListener.Accept()
+		if err != nil {
+			proxyDone <- err
+			return
+		}
+		proxyDone <- p.HandleConn(conn)
+	}()
+
+	conn, err := tls.Dial("tcp", proxyListener.Addr().String(), &tls.Config{
+		ServerName: "localhost",
+		RootCAs:    pool,
+		MinVersion: version,
+		MaxVersion: version,
+	})
+	if err != nil {
+		t.Fatalf("经过 Proxy 的 TLS 连接失败：%v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// 超过一条记录的最大长度，确保至少有一条消息被拆成多条记录
+	message := bytes.Repeat([]byte("learn-tls-with-go "), 2000)
+	if _, err := conn.Write(message); err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, echo); err != nil || !bytes.Equal(echo, message) {
+		t.Fatalf("回显的数据与发送的不一致：%v", err)
+	}
+	conn.Close()
+
+	select {
+	case err := <-proxyDone:
+		if err != nil {
+			t.Errorf("HandleConn 返回了错误：%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("客户端关闭连接 5 秒后 Proxy 仍未结束该连接")
+	}
+}
+
+// testCertificate 生成 localhost 的自签名证书，返回证书和只信任它的证书池
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
@@ -0,0 +1,63 @@
+// Package recordproxy 是代理中可以被其他程序复用的部分：TLS 记录层的分帧，以及一个可以嵌入的记录层代理 Proxy，
+// 它把转发的每条记录作为事件发给自己的订阅者。
+// 命令行工具 record-layer-proxy 的所有转发方式（逐条解析、-raw、-count-only、-mitm 等）只使用这里的分帧函数，
+// 不经过 Proxy，也不发布事件
+package recordproxy
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// HeaderLength 是记录层头部的长度：内容类型 (1)、版本 (2)、长度 (2)
+const HeaderLength = 5
+
+// Header 是一个记录层头部
+type Header struct {
+	ContentType byte
+	Version     uint16
+	// 载荷的长度
+	Length int
+}
+
+// ParseHeader 解析 b 开头的记录层头部，b 不足 HeaderLength 字节时 ok 为 false。
+// 这里不检查内容类型和长度是否合理，由调用方按自己的需要判断
+func ParseHeader(b []byte) (h Header, ok bool) {
+	if len(b) < HeaderLength {
+		return Header{}, false
+	}
+	return Header{
+		ContentType: b[0],
+		Version:     binary.BigEndian.Uint16(b[1:3]),
+		Length:      int(binary.BigEndian.Uint16(b[3:5])),
+	}, true
+}
+
+// ReadHeader 从 r 读取一个完整的记录层头部放入 buf[:HeaderLength] 并解析。
+// 返回实际读到的字节数，调用方可以据此区分对端一个字节都没有发送就关闭连接的情况
+func ReadHeader(r io.Reader, buf []byte) (Header, int, error) {
+	n, err := io.ReadFull(r, buf[:HeaderLength])
+	if err != nil {
+		return Header{}, n, err
+	}
+	h, _ := ParseHeader(buf)
+	return h, n, nil
+}
+
+// Record 是一条完整的记录，Payload 引用原数据，不是副本
+type Record struct {
+	Header
+	Payload []byte
+}
+
+// SplitRecords 把 data 切分成依次排列的记录，返回其中完整的记录，以及末尾不足一条完整记录的剩余字节
+func SplitRecords(data []byte) (records []Record, rest []byte) {
+	for {
+		h, ok := ParseHeader(data)
+		if !ok || len(data) < HeaderLength+h.Length {
+			return records, data
+		}
+		records = append(records, Record{Header: h, Payload: data[HeaderLength : HeaderLength+h.Length]})
+		data = data[HeaderLength+h.Length:]
+	}
+}
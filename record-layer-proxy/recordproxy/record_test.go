@@ -0,0 +1,42 @@
+package recordproxy
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSplitRecords(t *testing.T) {
+	data := []byte{
+		22, 0x03, 0x01, 0x00, 0x02, 0xAA, 0xBB,
+		20, 0x03, 0x03, 0x00, 0x01, 0x01,
+		// 声明长度为 4，只剩 1 字节
+		23, 0x03, 0x03, 0x00, 0x04, 0xCC,
+	}
+	records, rest := SplitRecords(data)
+	want := []Record{
+		{Header{22, 0x0301, 2}, []byte{0xAA, 0xBB}},
+		{Header{20, 0x0303, 1}, []byte{0x01}},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("SplitRecords 返回的记录为 %+v，期望 %+v", records, want)
+	}
+	if !bytes.Equal(rest, data[13:]) {
+		t.Errorf("SplitRecords 返回的剩余字节为 %x，期望 %x", rest, data[13:])
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	buf := make([]byte, HeaderLength)
+	h, n, err := ReadHeader(bytes.NewReader([]byte{21, 0x03, 0x03, 0x00, 0x02}), buf)
+	if err != nil || n != HeaderLength || h != (Header{21, 0x0303, 2}) {
+		t.Errorf("ReadHeader = %+v, %d, %v", h, n, err)
+	}
+
+	// 只读到一部分头部时返回已读到的字节数
+	_, n, err = ReadHeader(bytes.NewReader([]byte{22, 0x03}), buf)
+	if err != io.ErrUnexpectedEOF || n != 2 {
+		t.Errorf("不完整的头部：n = %d, err = %v", n, err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// recordGapBuckets 是记录到达间隔直方图各个桶的上限（不含），最后一个桶收纳其余所有间隔。
+// 同一次写入拆出的多条记录间隔通常在 0.1ms 以内，等待对端回应的间隔约为一个往返时间，
+// 持续传输的应用数据则取决于应用本身
+var recordGapBuckets = []struct {
+	limit time.Duration
+	label string
+}{
+	{100 * time.Microsecond, "<0.1ms"},
+	{time.Millisecond, "0.1～1ms"},
+	{10 * time.Millisecond, "1～10ms"},
+	{100 * time.Millisecond, "10～100ms"},
+	{time.Second, "100ms～1s"},
+	{0, "≥1s"},
+}
+
+// recordGapStats 以直方图统计一个方向上相邻两条记录的到达间隔。
+// 与 contentTypeStats 一样只由该方向的转发 goroutine 写入，连接关闭后才读取
+type recordGapStats struct {
+	// 上一条记录到达的时间，尚未收到记录时为零值
+	last    time.Time
+	buckets [6]int64
+	max     time.Duration
+}
+
+// observe 记录一条在 at 时刻到达的记录，从第二条记录起计入直方图
+func (g *recordGapStats) observe(at time.Time) {
+	if !g.last.IsZero() {
+		gap := at.Sub(g.last)
+		i := 0
+		for i < len(recordGapBuckets)-1 && gap >= recordGapBuckets[i].limit {
+			i++
+		}
+		g.buckets[i]++
+		if gap > g.max {
+			g.max = gap
+		}
+	}
+	g.last = at
+}
+
+// count 返回计入直方图的间隔数，即记录数减一
+func (g *recordGapStats) count() int64 {
+	var n int64
+	for _, c := range g.buckets {
+		n += c
+	}
+	return n
+}
+
+// describe 返回形如 “<0.1ms 3，1～10ms 2，最长 4.2ms” 的直方图描述，省略空桶，没有间隔时返回空字符串
+func (g *recordGapStats) describe() string {
+	if g.count() == 0 {
+		return ""
+	}
+	desc := ""
+	for i, c := range g.buckets {
+		if c == 0 {
+			continue
+		}
+		desc += fmt.Sprintf("%s %d，", recordGapBuckets[i].label, c)
+	}
+	return desc + fmt.Sprintf("最长 %s", g.max.Round(time.Microsecond))
+}
+
+// jsonBuckets 把直方图转换为以桶名称为键的表，省略空桶
+func (g *recordGapStats) jsonBuckets() map[string]int64 {
+	m := map[string]int64{}
+	for i, c := range g.buckets {
+		if c > 0 {
+			m[recordGapBuckets[i].label] = c
+		}
+	}
+	return m
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// -log-max-size（MB）和 -log-max-files 的默认值，子命令的 -log-file 也按这两个值滚动
+const (
+	defaultLogMaxSizeMB = 100
+	defaultLogMaxFiles  = 5
+)
+
+// rotatingWriter 把日志写入 path，当文件超过 maxSize 字节或已写入超过 maxAge 时滚动：
+// path 重命名为 path.1，原来的 path.1 重命名为 path.2，依此类推，最多保留 maxFiles 个旧文件。
+// maxSize 或 maxAge 为 0 表示不按该条件滚动。调用方需保证不会并发调用 Write
+type rotatingWriter struct {
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func newRotatingWriter(path string, maxSize int64, maxAge time.Duration, maxFiles int) (*rotatingWriter, error) {
+	w := &rotatingWriter{
+		path:     path,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		maxFiles: maxFiles,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open 以追加方式打开 path，已有内容计入当前文件的大小
+func (w *rotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	if w.needsRotate(len(p)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingWriter) needsRotate(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.maxSize > 0 && w.size+int64(n) > w.maxSize {
+		return true
+	}
+	return w.maxAge > 0 && time.Since(w.openedAt) >= w.maxAge
+}
+
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if w.maxFiles <= 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return w.open()
+	}
+
+	// 最旧的文件被覆盖掉，其余的依次后移一位
+	for i := w.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return w.open()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// handshakeWatchdog 检查握手是否停滞：指定 -handshake-stall-timeout 时，超过这么久没有任何推进握手的记录即视为停滞；
+// 指定 -max-idle-records 时，连续这么多条记录都没有推进握手也视为停滞。推进握手的记录是指带来了一条完整的明文握手消息、
+// ChangeCipherSpec 或加密的握手消息（TLS 1.3 中握手完成前的 application_data）的记录，
+// 警告、心跳、零长度记录或只有半条握手消息的分片都不算。与按字节计算的读取超时不同，
+// 对端不断发送这类保活式的数据却始终不完成握手时，这里同样能发现。每条连接只报告一次，不会断开连接
+type handshakeWatchdog struct {
+	timer *time.Timer
+	// 推进握手的次数，以及此后连续的不推进握手的记录数
+	steps       uint64
+	idleRecords int
+	// 最近一次推进握手的内容、发送方和时间，尚未推进过时 last 为空
+	last           string
+	lastFromClient bool
+	lastAt         time.Time
+	reported       bool
+}
+
+// startHandshakeWatchdog 在指定 -handshake-stall-timeout 时启动计时，应在开始转发记录时调用一次
+func (s *connState) startHandshakeWatchdog() {
+	if config.handshakeStallTimeout <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchdog.lastAt = time.Now()
+	s.watchdog.timer = time.AfterFunc(config.handshakeStallTimeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.handshakeComplete() {
+			return
+		}
+		s.reportHandshakeStall(fmt.Sprintf("已经 %s 没有任何推进握手的记录", config.handshakeStallTimeout))
+	})
+}
+
+// stopHandshakeWatchdog 停止计时
+func (s *connState) stopHandshakeWatchdog() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watchdog.timer != nil {
+		s.watchdog.timer.Stop()
+	}
+}
+
+// markHandshakeProgress 记录 fromClient 一方推进了握手，what 是推进握手的内容，调用时需持有 mu
+func (s *connState) markHandshakeProgress(fromClient bool, what string) {
+	w := &s.watchdog
+	w.steps++
+	w.idleRecords = 0
+	w.last, w.lastFromClient, w.lastAt = what, fromClient, time.Now()
+	if w.timer != nil && !w.reported {
+		w.timer.Reset(config.handshakeStallTimeout)
+	}
+}
+
+// handshakeSteps 返回目前推进握手的次数，与 checkIdleRecord 配合判断一条记录是否推进了握手
+func (s *connState) handshakeSteps() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.watchdog.steps
+}
+
+// checkIdleRecord 在解析完一条记录后调用，stepsBefore 是解析这条记录之前 handshakeSteps 的返回值。
+// 握手完成前连续 -max-idle-records 条记录都没有推进握手时报告停滞
+func (s *connState) checkIdleRecord(stepsBefore uint64) {
+	if config.maxIdleRecords <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handshakeComplete() || s.watchdog.steps != stepsBefore {
+		return
+	}
+	s.watchdog.idleRecords++
+	if s.watchdog.idleRecords == config.maxIdleRecords {
+		s.reportHandshakeStall(fmt.Sprintf("连续 %d 条记录都没有推进握手", config.maxIdleRecords))
+	}
+}
+
+// reportHandshakeStall 报告握手停滞以及最后推进握手的内容，调用时需持有 mu。
+// 警告记在应当发送下一条握手消息的一方名下：最后推进握手的是对方，尚未开始握手时是客户端
+func (s *connState) reportHandshakeStall(reason string) {
+	w := &s.watchdog
+	if w.reported {
+		return
+	}
+	w.reported = true
+
+	waiting := !w.lastFromClient
+	last := "尚未收到任何握手消息"
+	if w.last == "" {
+		waiting = true
+	} else {
+		sender := "服务器"
+		if w.lastFromClient {
+			sender = "客户端"
+		}
+		last = fmt.Sprintf("最后推进握手的是 %s 之前%s发送的 %s", time.Since(w.lastAt).Round(time.Millisecond), sender, w.last)
+	}
+	s.warnf(waiting, "握手停滞：%s，%s", reason, last)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 全局统计计数器，由各连接的转发 goroutine 更新，由 runStatsTicker 定期读取
+var (
+	statConnOpened    atomic.Int64
+	statConnClosed    atomic.Int64
+	statBytes         atomic.Int64
+	statRecordsByType [256]atomic.Int64
+)
+
+// runStatsTicker 每隔 interval 输出一行统计信息，内容为这段时间内各计数器的增量
+func runStatsTicker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastOpened, lastClosed, lastBytes int64
+	var lastRecords [256]int64
+
+	for range ticker.C {
+		opened := statConnOpened.Load()
+		closed := statConnClosed.Load()
+		bytes := statBytes.Load()
+
+		var recordParts []string
+		for i := range statRecordsByType {
+			count := statRecordsByType[i].Load()
+			delta := count - lastRecords[i]
+			lastRecords[i] = count
+			if delta == 0 {
+				continue
+			}
+
+			contentType, hasType := CONTENT_TYPE_TABLE[byte(i)]
+			if !hasType {
+				contentType = fmt.Sprintf("未知 (%d)", i)
+			}
+			recordParts = append(recordParts, fmt.Sprintf("%s %d", contentType, delta))
+		}
+
+		recordInfo := "无"
+		if len(recordParts) > 0 {
+			recordInfo = strings.Join(recordParts, "、")
+		}
+
+		logPrintf(
+			"[stats] 最近 %s：新建连接 %d，关闭连接 %d，当前活跃连接 %d，转发记录：%s，转发字节：%d\n",
+			interval,
+			opened-lastOpened,
+			closed-lastClosed,
+			opened-closed,
+			recordInfo,
+			bytes-lastBytes,
+		)
+
+		lastOpened, lastClosed, lastBytes = opened, closed, bytes
+	}
+}
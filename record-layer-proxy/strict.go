@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// strictViolation 是 -strict 模式下第一处待中止连接的协议违规
+type strictViolation struct {
+	fromClient bool
+	alert      alertSpec
+	reason     string
+}
+
+// protocolViolation 输出一条协议违规警告并记入连接摘要，调用时需持有 mu。alert 是按 RFC 应当回应的警报描述。
+// 指定 -strict 时还会记下第一处违规，转发该方向的 goroutine 解析完当前记录后，不再转发它，而是用 abortStrict 中止连接。
+// 只是不常见、但 RFC 并未禁止的行为不应通过这里报告
+func (s *connState) protocolViolation(fromClient bool, alert byte, format string, args ...any) {
+	reason := fmt.Sprintf(format, args...)
+	s.warnf(fromClient, "协议违规：%s", reason)
+	if config.strict && s.strictViolation == nil {
+		s.strictViolation = &strictViolation{fromClient, alertSpec{alertLevelFatal, alert}, reason}
+	}
+}
+
+// takeStrictViolation 取出待中止连接的协议违规，没有时返回 nil。同一处违规只会被取出一次
+func (s *connState) takeStrictViolation() *strictViolation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.strictViolation
+	if v != nil && !s.strictAborted {
+		s.strictAborted = true
+		return v
+	}
+	return nil
+}
+
+// abortStrict 是 -strict 模式下中止连接的统一出口：违规的记录不再转发，代理代替对端向违规的一方、
+// 也向另一方各发送一条致命警报，然后断开两个连接。from 是违规一方的连接。
+// TLS 1.3 握手开始加密之后，这条明文警报对端未必能够处理，但连接总会被断开
+func (s *connState) abortStrict(from, to *net.TCPConn, fromClient bool, alert byte, reason string) {
+	spec := alertSpec{alertLevelFatal, alert}
+	logPrintf("[strict %s] 协议违规：%s，-strict 模式下向双方发送警报 %s 并中止连接\n", s.directionLabel(fromClient), reason, spec)
+	s.addWarning(fromClient, "-strict 模式下因协议违规中止了连接："+reason)
+	_ = s.writeTo(from, spec.record())
+	_ = s.writeTo(to, spec.record())
+	s.closeBoth()
+}
+
+// duplicateExtension 返回扩展列表中第一个重复出现的扩展类型。RFC 8446 4.2：同一个扩展块中，同一类型的扩展不能出现多次
+func duplicateExtension(extensions []tlsExtension) (uint16, bool) {
+	seen := map[uint16]bool{}
+	for _, ext := range extensions {
+		if seen[ext.extType] {
+			return ext.extType, true
+		}
+		seen[ext.extType] = true
+	}
+	return 0, false
+}
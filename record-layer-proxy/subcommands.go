@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// subcommand 是一个子命令。proxy 之外的子命令都只是一次性的工具，各自用独立的 FlagSet 解析参数，
+// 不会受到代理那几十个参数的干扰
+type subcommand struct {
+	name    string
+	summary string
+	// 不为 nil 时由该函数解析 args（子命令名之后的参数）并执行；proxy 为 nil，交回 main 按原来的参数列表处理
+	run func(args []string)
+}
+
+var subcommands = []subcommand{
+	{"proxy", "监听 -l，把连接转发到 -r 并解析其中的 TLS 记录（不写子命令时的默认行为）", nil},
+	{"analyze", "离线解析文件中连续的 TLS 记录并输出解析树", runAnalyzeCommand},
+	{"client", "作为 TLS 客户端连接服务器，输出服务器回应的记录", runClientCommand},
+	{"echo-server", "运行一个使用自签名证书的 TLS 回显服务器，供代理的 -r 指向", runEchoServerCommand},
+	{"jarm", "主动探测服务器，输出它的 JARM 指纹", runJARMCommand},
+}
+
+// usageExamples 是 -help 末尾的示例
+const usageExamples = `示例：
+  # 在 8443 端口监听，把连接转发到 example.com:443，输出两个方向的记录
+  %[1]s -l 127.0.0.1:8443 -r example.com:443
+
+  # 同上，显式写出子命令；每条记录以解析树的形式输出
+  %[1]s proxy -l 127.0.0.1:8443 -r example.com:443 -tree
+
+  # 只输出连接摘要，以 JSON 格式交给 jq 处理
+  %[1]s -l 127.0.0.1:8443 -r example.com:443 -summary-only -json-summary | jq .
+
+  # 没有现成的服务器时，先运行回显服务器，再让代理指向它
+  %[1]s echo-server -l 127.0.0.1:9443
+  %[1]s -l 127.0.0.1:8443 -r 127.0.0.1:9443
+
+  # 离线解析从 Wireshark 复制出的十六进制记录（或 -hello-bytes-dir 写出的文件）
+  %[1]s analyze hello.hex
+
+  # 探测服务器支持的版本和密码套件、JARM 指纹
+  %[1]s client -version 1.2 example.com:443
+  %[1]s jarm example.com:443
+
+  每个子命令的参数可以用 %[1]s <子命令> -help 查看
+`
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// printUsage 是 proxy（即默认的参数列表）的 -help 输出：子命令列表、fs 中的参数说明和示例
+func printUsage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintf(out, "用法：%s [子命令] [参数]\n\n子命令：\n", programName())
+	for _, cmd := range subcommands {
+		fmt.Fprintf(out, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nproxy 的参数：\n")
+	fs.PrintDefaults()
+	fmt.Fprintf(out, "\n"+usageExamples, programName())
+}
+
+// runSubcommand 在 args（os.Args[1:]）以子命令名开头时执行 proxy 以外的子命令并返回 true。
+// 返回 false 时由 main 按 proxy 处理，此时返回的 rest 是去掉子命令名 proxy 之后的参数
+func runSubcommand(args []string) (ran bool, rest []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return false, args
+	}
+	for _, cmd := range subcommands {
+		if cmd.name != args[0] {
+			continue
+		}
+		if cmd.run == nil {
+			return false, args[1:]
+		}
+		cmd.run(args[1:])
+		return true, nil
+	}
+	// 不认识的第一个参数交给 flag 包，和以前一样报告多余的参数
+	return false, args
+}
+
+// newSubcommandFlagSet 创建子命令的 FlagSet，usage 是参数之前的用法说明，如 “[参数] <文件>”
+func newSubcommandFlagSet(name, usage, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法：%s %s %s\n\n%s\n", programName(), name, usage, description)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(fs.Output(), "\n参数：\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// outputFlags 是每个子命令都有的输出参数，含义与 proxy 的同名参数相同
+type outputFlags struct {
+	logFile string
+	color   string
+}
+
+func addOutputFlags(fs *flag.FlagSet) *outputFlags {
+	o := &outputFlags{}
+	fs.StringVar(&o.logFile, "log-file", "", fmt.Sprintf("把输出写入该文件（而不是标准输出），超过 %d MB 时滚动，最多保留 %d 个旧文件", defaultLogMaxSizeMB, defaultLogMaxFiles))
+	fs.StringVar(&o.color, "color", "auto", "警告是否着色：always 总是、never 从不、auto 只在输出到终端时着色（遵循 NO_COLOR 和 CLICOLOR_FORCE）")
+	return o
+}
+
+// apply 按 -log-file 替换日志输出目标，并按 -color 决定警告是否着色
+func (o *outputFlags) apply(name string) {
+	if o.logFile != "" {
+		w, err := newRotatingWriter(o.logFile, defaultLogMaxSizeMB*1024*1024, 0, defaultLogMaxFiles)
+		panicIfErr(err, name)
+		setLogOutput(w)
+	}
+	enabled, err := decideColor(o.color, o.logFile == "" && isTerminal(os.Stdout))
+	panicIfErr(err, name)
+	config.color = enabled
+}
+
+// parseSubcommandArgs 解析子命令的参数并应用输出参数，要求之后恰好剩下 nArgs 个位置参数（地址或文件）
+func parseSubcommandArgs(fs *flag.FlagSet, args []string, out *outputFlags, nArgs int) []string {
+	_ = fs.Parse(args)
+	if fs.NArg() != nArgs {
+		fs.Usage()
+		os.Exit(2)
+	}
+	out.apply(fs.Name())
+	return fs.Args()
+}
+
+func runAnalyzeCommand(args []string) {
+	fs := newSubcommandFlagSet("analyze", "[参数] <文件>",
+		"离线解析文件中连续的 TLS 记录并输出解析树。文件可以是二进制数据，也可以是十六进制文本（忽略空白，如 Wireshark 复制的 Hex 流）")
+	inspectAfterCCS := fs.Bool("inspect-after-ccs", false, "（教学用）ChangeCipherSpec 之后仍把加密的握手和警报记录当作明文解析")
+	maxRecordLogBytes := fs.Int("max-record-log-bytes", 256, "每条记录最多输出的原始字节数，0 表示不限制")
+	out := addOutputFlags(fs)
+	path := parseSubcommandArgs(fs, args, out, 1)[0]
+
+	config.inspectAfterCCS = *inspectAfterCCS
+	config.maxRecordLogBytes = *maxRecordLogBytes
+	runAnalyze(path)
+}
+
+func runClientCommand(args []string) {
+	fs := newSubcommandFlagSet("client", "[参数] <host:port>",
+		"作为 TLS 客户端向服务器发送 ClientHello，输出服务器回应的记录直到证书（TLS 1.3 中直到加密的握手消息）后退出")
+	version := fs.String("version", "", "只提供该版本（1.0、1.1、1.2 或 1.3），默认同时提供 TLS 1.2 和 TLS 1.3")
+	sni := fs.String("sni", "", "ClientHello 中的 SNI，默认为目标地址中的主机名（是 IP 地址时不发送 SNI）")
+	alpn := fs.String("alpn", "", "提供的 ALPN 协议，以逗号分隔，如 h2,http/1.1")
+	ciphers := fs.String("ciphers", "", "提供的密码套件，以逗号分隔，可填写名称或编号（如 TLS_AES_128_GCM_SHA256 或 0x1301）")
+	out := addOutputFlags(fs)
+	addr := parseSubcommandArgs(fs, args, out, 1)[0]
+
+	opts, err := parseClientOptions(addr, *version, *sni, *alpn, *ciphers)
+	panicIfErr(err, "client")
+	runClient(addr, opts)
+}
+
+func runEchoServerCommand(args []string) {
+	fs := newSubcommandFlagSet("echo-server", "[参数]",
+		"在 -l 指定的地址运行一个使用内存中自签名证书的 crypto/tls 回显服务器，供代理的 -r 指向")
+	addr := fs.String("l", "127.0.0.1:9443", "监听地址")
+	out := addOutputFlags(fs)
+	parseSubcommandArgs(fs, args, out, 0)
+	runEchoServer(*addr)
+}
+
+func runJARMCommand(args []string) {
+	fs := newSubcommandFlagSet("jarm", "[参数] <host:port>",
+		"依次向服务器发送 JARM 的十种 ClientHello，输出每次探测的结果和服务器的 JARM 指纹")
+	out := addOutputFlags(fs)
+	runJARM(parseSubcommandArgs(fs, args, out, 1)[0])
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// printSummary 在连接关闭时输出一段连接摘要，调用时需持有 mu
+func (s *connState) printSummary() {
+	prefix := fmt.Sprintf("[summary %s --> %s] ", s.clientConn.RemoteAddr(), s.serverConn.RemoteAddr())
+
+	sni := s.sni
+	if sni == "" {
+		sni = "（无）"
+	}
+	version, cipherSuite := "未知", "未知"
+	if s.version != 0 {
+		version = formatVersion(s.version)
+		cipherSuite = formatCipherSuite(s.cipherSuite)
+	}
+
+	summary := prefix + fmt.Sprintf(
+		"连接 #%d 已关闭：版本 %s，密码套件 %s，SNI=%s，ALPN=%s，客户端发送 %d 字节，服务器发送 %d 字节，持续 %s\n",
+		s.id,
+		version,
+		cipherSuite,
+		sni,
+		s.describeALPN(),
+		s.bytesFromClient.Load(),
+		s.bytesFromServer.Load(),
+		time.Since(s.startTime).Round(time.Millisecond),
+	)
+	for i, direction := range []string{"客户端", "服务器"} {
+		if parts := s.typeStats[i].describe(); len(parts) > 0 {
+			summary += prefix + direction + "发送的记录：" + strings.Join(parts, "，") + "\n"
+		}
+	}
+	for i, direction := range []string{"客户端", "服务器"} {
+		if gaps := s.gapStats[i].describe(); gaps != "" {
+			summary += prefix + direction + "相邻记录的到达间隔：" + gaps + "\n"
+		}
+	}
+	if overhead := s.describeOverhead(); overhead != "" {
+		summary += prefix + overhead + "\n"
+	}
+	if !s.handshakeDoneAt.IsZero() {
+		summary += prefix + fmt.Sprintf("握手耗时 %s\n", s.handshakeDoneAt.Sub(s.startTime).Round(time.Microsecond))
+	}
+	if s.version != 0 {
+		summary += prefix + "会话恢复：" + s.describeResumption() + "\n"
+	}
+	if s.ja3Hash != "" {
+		summary += prefix + "JA3 哈希：" + s.ja3Hash + "\n"
+	}
+	if s.clientGuess != "" {
+		summary += prefix + "推测的客户端：" + s.clientGuess + "\n"
+	}
+	for _, w := range s.warnings {
+		summary += prefix + "警告：" + w + "\n"
+	}
+	logPrint(summary)
+}
+
+// printCountTally 是 -count-only 模式下的连接摘要：不解析记录，也就只有按内容类型的计数，调用时需持有 mu
+func (s *connState) printCountTally() {
+	prefix := fmt.Sprintf("[count %s --> %s] ", s.clientConn.RemoteAddr(), s.serverConn.RemoteAddr())
+	tally := prefix + fmt.Sprintf("连接 #%d 已关闭，持续 %s\n", s.id, time.Since(s.startTime).Round(time.Millisecond))
+	for i, direction := range []string{"客户端", "服务器"} {
+		total, bytes := int64(0), int64(0)
+		for contentType := range s.typeStats[i].records {
+			total += s.typeStats[i].records[contentType]
+			bytes += s.typeStats[i].bytes[contentType]
+		}
+		detail := ""
+		if parts := s.typeStats[i].describe(); len(parts) > 0 {
+			detail = "：" + strings.Join(parts, "，")
+		}
+		tally += prefix + fmt.Sprintf("%s共发送 %d 条记录 / %d 字节%s\n", direction, total, bytes, detail)
+	}
+	logPrint(tally)
+}
+
+// recordOverhead 汇总两个方向的记录，返回记录层开销和应用数据载荷的字节数。
+// 开销包括每条记录 5 字节的头部和握手、ChangeCipherSpec、警报等非应用数据记录的全部内容；
+// 应用数据记录载荷中的 IV、认证标签、填充等加密开销与应用数据本身无法区分，计入载荷
+func (s *connState) recordOverhead() (overhead, payload int64) {
+	for i := range s.typeStats {
+		t := &s.typeStats[i]
+		for contentType, records := range t.records {
+			if records == 0 {
+				continue
+			}
+			if byte(contentType) == contentTypeApplicationData {
+				overhead += 5 * records
+				payload += t.bytes[contentType] - 5*records
+			} else {
+				overhead += t.bytes[contentType]
+			}
+		}
+	}
+	return overhead, payload
+}
+
+// describeOverhead 以百分比说明记录层开销相对于应用数据载荷的比例，没有任何记录时返回空字符串
+func (s *connState) describeOverhead() string {
+	overhead, payload := s.recordOverhead()
+	switch {
+	case overhead == 0 && payload == 0:
+		return ""
+	case payload == 0:
+		return fmt.Sprintf("记录层开销：没有应用数据，转发的 %d 字节全部是记录头部和握手等开销", overhead)
+	}
+	return fmt.Sprintf("记录层开销：记录头部和握手等非应用数据共 %d 字节，应用数据载荷 %d 字节，开销为载荷的 %.1f%%",
+		overhead, payload, 100*float64(overhead)/float64(payload))
+}
+
+// summarySchemaVersion 是 JSON 连接摘要的格式版本。只增加字段时不变，删除或改变已有字段的含义时加一
+const summarySchemaVersion = 1
+
+// jsonSummary 是 -json-summary 模式下每条连接关闭时输出的一行 JSON
+type jsonSummary struct {
+	SchemaVersion int    `json:"schema_version"`
+	ID            uint64 `json:"id"`
+	Client        string `json:"client"`
+	Backend       string `json:"backend"`
+	// 连接建立的时间（RFC 3339），以及连接持续的时间、从连接建立到握手完成的时间（毫秒）
+	StartTime         string   `json:"start_time"`
+	DurationMS        float64  `json:"duration_ms"`
+	HandshakeComplete bool     `json:"handshake_complete"`
+	HandshakeMS       *float64 `json:"handshake_ms"`
+	// 版本和密码套件在未看到 ServerHello 时为 null
+	Version         *uint16  `json:"version"`
+	VersionName     string   `json:"version_name"`
+	CipherSuite     *uint16  `json:"cipher_suite"`
+	CipherSuiteName string   `json:"cipher_suite_name"`
+	SNI             string   `json:"sni"`
+	ALPNOffered     []string `json:"alpn_offered"`
+	// 服务器选中的 ALPN 协议，TLS 1.3 中代理看不到，为空字符串
+	ALPN                string   `json:"alpn"`
+	Resumed             bool     `json:"resumed"`
+	SessionTickets      int      `json:"session_tickets"`
+	BytesClientToServer int64    `json:"bytes_client_to_server"`
+	BytesServerToClient int64    `json:"bytes_server_to_client"`
+	JA3                 string   `json:"ja3"`
+	JA3Hash             string   `json:"ja3_hash"`
+	LeafSHA256          string   `json:"leaf_sha256"`
+	Warnings            []string `json:"warnings"`
+	// 两个方向上按内容类型名称统计的记录数和字节数（含记录层头部），不解析记录的模式下为空对象
+	RecordsClientToServer map[string]jsonRecordStats `json:"records_client_to_server"`
+	RecordsServerToClient map[string]jsonRecordStats `json:"records_server_to_client"`
+	// 两个方向上相邻记录到达间隔的直方图，以桶名称（如 "1～10ms"）为键，省略空桶
+	RecordGapsClientToServer map[string]int64 `json:"record_gaps_client_to_server"`
+	RecordGapsServerToClient map[string]int64 `json:"record_gaps_server_to_client"`
+	// 记录层开销（记录头部和非应用数据记录）占应用数据载荷的百分比，没有应用数据时为 null
+	OverheadPercent *float64 `json:"overhead_percent"`
+}
+
+// jsonRecordStats 是 JSON 摘要中一种内容类型的统计
+type jsonRecordStats struct {
+	Records int64 `json:"records"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// jsonRecords 把按内容类型的统计转换为以类型名称为键的表，未知类型以编号为键
+func (t *contentTypeStats) jsonRecords() map[string]jsonRecordStats {
+	m := map[string]jsonRecordStats{}
+	for contentType, records := range t.records {
+		if records == 0 {
+			continue
+		}
+		name, ok := CONTENT_TYPE_TABLE[byte(contentType)]
+		if !ok {
+			name = fmt.Sprint(contentType)
+		}
+		m[name] = jsonRecordStats{Records: records, Bytes: t.bytes[contentType]}
+	}
+	return m
+}
+
+// printJSONSummary 以一行 JSON 输出连接摘要，调用时需持有 mu
+func (s *connState) printJSONSummary() {
+	now := time.Now()
+	summary := jsonSummary{
+		SchemaVersion:       summarySchemaVersion,
+		ID:                  s.id,
+		Client:              s.clientConn.RemoteAddr().String(),
+		Backend:             s.serverConn.RemoteAddr().String(),
+		StartTime:           s.startTime.Format(time.RFC3339Nano),
+		DurationMS:          float64(now.Sub(s.startTime)) / float64(time.Millisecond),
+		HandshakeComplete:   s.handshakeComplete(),
+		SNI:                 s.sni,
+		ALPNOffered:         s.clientALPN,
+		ALPN:                s.serverALPN,
+		Resumed:             s.resumed,
+		SessionTickets:      s.sessionTickets,
+		BytesClientToServer: s.bytesFromClient.Load(),
+		BytesServerToClient: s.bytesFromServer.Load(),
+		JA3:                 s.ja3,
+		JA3Hash:             s.ja3Hash,
+		LeafSHA256:          s.leafSHA256,
+		Warnings:            s.warnings,
+
+		RecordsClientToServer: s.typeStats[0].jsonRecords(),
+		RecordsServerToClient: s.typeStats[1].jsonRecords(),
+
+		RecordGapsClientToServer: s.gapStats[0].jsonBuckets(),
+		RecordGapsServerToClient: s.gapStats[1].jsonBuckets(),
+	}
+	if overhead, payload := s.recordOverhead(); payload > 0 {
+		percent := 100 * float64(overhead) / float64(payload)
+		summary.OverheadPercent = &percent
+	}
+	if !s.handshakeDoneAt.IsZero() {
+		ms := float64(s.handshakeDoneAt.Sub(s.startTime)) / float64(time.Millisecond)
+		summary.HandshakeMS = &ms
+	}
+	if s.version != 0 {
+		version, cipherSuite := s.version, s.cipherSuite
+		summary.Version = &version
+		summary.VersionName = lookupName(VERSION_TABLE, version)
+		summary.CipherSuite = &cipherSuite
+		summary.CipherSuiteName = lookupName(CIPHER_SUITE_TABLE, cipherSuite)
+	}
+	// 列表字段总是输出数组而不是 null，方便 jq 处理
+	if summary.ALPNOffered == nil {
+		summary.ALPNOffered = []string{}
+	}
+	if summary.Warnings == nil {
+		summary.Warnings = []string{}
+	}
+
+	line, err := json.Marshal(summary)
+	if err != nil {
+		logPrintf("[summary] 无法生成 JSON 摘要：%v\n", err)
+		return
+	}
+	logPrint(string(line) + "\n")
+}
+
+// describeResumption 描述本次连接与会话恢复相关的情况，调用时需持有 mu
+func (s *connState) describeResumption() string {
+	desc := "本次为完整握手"
+	if s.resumed {
+		desc = "本次连接通过会话恢复建立，省去了证书验证和完整的密钥交换"
+	} else if s.offeredPSK {
+		desc = "客户端尝试用 PSK 恢复会话，但服务器选择了完整握手"
+	}
+	if s.offeredEarlyData {
+		desc += "；客户端尝试发送 0-RTT 数据"
+	}
+
+	if s.version == versionTLS13 {
+		// TLS 1.3 的 NewSessionTicket 在握手完成后以加密的应用数据记录发送，与普通应用数据无法区分
+		return desc + "；TLS 1.3 的 NewSessionTicket 是加密传输的，代理无法统计服务器签发了几张票据。" +
+			"若服务器签发了票据，客户端之后的连接可以用 PSK 恢复会话，若服务器允许还可以发送 0-RTT 数据"
+	}
+	if s.sessionTickets > 0 {
+		return desc + fmt.Sprintf("；服务器签发了 %d 张 NewSessionTicket，客户端之后的连接可以凭票据恢复会话", s.sessionTickets)
+	}
+	return desc + "；服务器没有签发 NewSessionTicket"
+}
+
+// describeALPN 描述协商出的 ALPN 协议，调用时需持有 mu
+func (s *connState) describeALPN() string {
+	switch {
+	case s.serverALPN != "":
+		return s.serverALPN
+	case len(s.clientALPN) == 0:
+		return "（无）"
+	case s.version == versionTLS13:
+		return fmt.Sprintf("（服务器的选择位于加密的 EncryptedExtensions 中，客户端提供了 %s）", strings.Join(s.clientALPN, "、"))
+	}
+	return fmt.Sprintf("（服务器未选择，客户端提供了 %s）", strings.Join(s.clientALPN, "、"))
+}
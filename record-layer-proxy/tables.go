@@ -0,0 +1,512 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var CONTENT_TYPE_TABLE = map[byte]string{
+	0:  "Invalid",
+	20: "Change Cipher Spec",
+	21: "Alert",
+	22: "Handshake",
+	23: "Application Data",
+}
+
+var HANDSHAKE_TYPE_TABLE = map[byte]string{
+	0:   "Hello Request",
+	1:   "Client Hello",
+	2:   "Server Hello",
+	3:   "Hello Verify Request",
+	4:   "New Session Ticket",
+	5:   "End Of Early Data",
+	6:   "Hello Retry Request (Reserved)",
+	8:   "Encrypted Extensions",
+	9:   "Request Connection ID",
+	10:  "New Connection ID",
+	11:  "Certificate",
+	12:  "Server Key Exchange",
+	13:  "Certificate Request",
+	14:  "Server Hello Done",
+	15:  "Certificate Verify",
+	16:  "Client Key Exchange",
+	17:  "Client Certificate Request",
+	20:  "Finished",
+	21:  "Certificate URL",
+	22:  "Certificate Status",
+	23:  "Supplemental Data",
+	24:  "Key Update",
+	25:  "Compressed Certificate",
+	26:  "EKT Key",
+	254: "Message Hash",
+}
+
+var ALERT_LEVEL_TABLE = map[byte]string{
+	1: "Warning",
+	2: "Fatal",
+}
+
+var ALERT_DESCRIPTION_TABLE = map[byte]string{
+	0:   "Close Notify",
+	10:  "Unexpected Message",
+	20:  "Bad Record MAC",
+	22:  "Record Overflow",
+	30:  "Decompression Failure",
+	40:  "Handshake Failure",
+	41:  "No Certificate",
+	42:  "Bad Certificate",
+	43:  "Unsupported Certificate",
+	44:  "Certificate Revoked",
+	45:  "Certificate Expired",
+	46:  "Certificate Unknown",
+	47:  "Illegal Parameter",
+	48:  "Unknown CA",
+	49:  "Access Denied",
+	50:  "Decode Error",
+	51:  "Decrypt Error",
+	60:  "Export Restriction",
+	70:  "Protocol Version",
+	71:  "Insufficient Security",
+	80:  "Internal Error",
+	86:  "Inappropriate Fallback",
+	90:  "User Canceled",
+	100: "No Renegotiation",
+	109: "Missing Extension",
+	110: "Unsupported Extension",
+	112: "Unrecognized Name",
+	113: "Bad Certificate Status Response",
+	115: "Unknown PSK Identity",
+	116: "Certificate Required",
+	120: "No Application Protocol",
+}
+
+var EXTENSION_TYPE_TABLE = map[uint16]string{
+	0:     "Server Name",
+	1:     "Max Fragment Length",
+	2:     "Client Certificate URL",
+	3:     "Trusted CA Keys",
+	4:     "Truncated HMAC",
+	5:     "Status Request",
+	6:     "User Mapping",
+	7:     "Client Authz",
+	8:     "Server Authz",
+	9:     "Cert Type",
+	10:    "Supported Groups",
+	11:    "EC Point Formats",
+	12:    "SRP",
+	13:    "Signature Algorithms",
+	14:    "Use SRTP",
+	15:    "Heartbeat",
+	16:    "Application Layer Protocol Negotiation",
+	17:    "Status Request V2",
+	18:    "Signed Certificate Timestamp",
+	19:    "Client Certificate Type",
+	20:    "Server Certificate Type",
+	21:    "Padding",
+	22:    "Encrypt Then MAC",
+	23:    "Extended Master Secret",
+	24:    "Token Binding",
+	25:    "Cached Info",
+	26:    "TLS LTS",
+	27:    "Compress Certificate",
+	28:    "Record Size Limit",
+	29:    "Pwd Protect",
+	30:    "Pwd Clear",
+	31:    "Password Salt",
+	32:    "Ticket Pinning",
+	33:    "TLS Cert With Extern PSK",
+	34:    "Delegated Credential",
+	35:    "Session Ticket",
+	36:    "TLMSP",
+	37:    "TLMSP Proxying",
+	38:    "TLMSP Delegate",
+	39:    "Supported EKT Ciphers",
+	41:    "Pre Shared Key",
+	42:    "Early Data",
+	43:    "Supported Versions",
+	44:    "Cookie",
+	45:    "PSK Key Exchange Modes",
+	47:    "Certificate Authorities",
+	48:    "OID Filters",
+	49:    "Post Handshake Auth",
+	50:    "Signature Algorithms Cert",
+	51:    "Key Share",
+	52:    "Transparency Info",
+	53:    "Connect ID (Deprecated)",
+	54:    "Connection ID",
+	55:    "External ID Hash",
+	56:    "External Session ID",
+	57:    "QUIC Transport Parameters",
+	58:    "Ticket Request",
+	59:    "DNSSEC Chain",
+	60:    "Sequence Number Encryption Algorithms",
+	61:    "RRC",
+	17513: "Application Settings (ALPS, experimental)",
+	17613: "Application Settings (ALPS, experimental, new codepoint)",
+	65037: "Encrypted Client Hello",
+	65281: "Renegotiation Info",
+}
+
+// lookupName 在表中查找 code 对应的名称，找不到时返回“未知”
+func lookupName[K comparable](table map[K]string, code K) string {
+	name, hasName := table[code]
+	if !hasName {
+		return "未知"
+	}
+	return name
+}
+
+// lookupCode 在表中按名称或数字查找代码，名称不区分大小写，且下划线、连字符与空格视为相同，
+// 因此 "handshake_failure"、"Handshake Failure" 和 "40" 都能匹配到同一项
+func lookupCode[K ~uint8 | ~uint16](table map[K]string, s string) (K, bool) {
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil && uint64(K(n)) == n {
+		return K(n), true
+	}
+
+	normalize := strings.NewReplacer("_", " ", "-", " ").Replace
+	for code, name := range table {
+		if strings.EqualFold(normalize(name), normalize(s)) {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+var VERSION_TABLE = map[uint16]string{
+	0x0300: "SSL 3.0",
+	0x0301: "TLS 1.0",
+	0x0302: "TLS 1.1",
+	0x0303: "TLS 1.2",
+	0x0304: "TLS 1.3",
+}
+
+var CIPHER_SUITE_TABLE = map[uint16]string{
+	0x0000: "TLS_NULL_WITH_NULL_NULL",
+	0x0001: "TLS_RSA_WITH_NULL_MD5",
+	0x0002: "TLS_RSA_WITH_NULL_SHA",
+	0x0003: "TLS_RSA_EXPORT_WITH_RC4_40_MD5",
+	0x0004: "TLS_RSA_WITH_RC4_128_MD5",
+	0x0005: "TLS_RSA_WITH_RC4_128_SHA",
+	0x0006: "TLS_RSA_EXPORT_WITH_RC2_CBC_40_MD5",
+	0x0007: "TLS_RSA_WITH_IDEA_CBC_SHA",
+	0x0008: "TLS_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x0009: "TLS_RSA_WITH_DES_CBC_SHA",
+	0x000A: "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x000B: "TLS_DH_DSS_EXPORT_WITH_DES40_CBC_SHA",
+	0x000C: "TLS_DH_DSS_WITH_DES_CBC_SHA",
+	0x000D: "TLS_DH_DSS_WITH_3DES_EDE_CBC_SHA",
+	0x000E: "TLS_DH_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x000F: "TLS_DH_RSA_WITH_DES_CBC_SHA",
+	0x0010: "TLS_DH_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x0011: "TLS_DHE_DSS_EXPORT_WITH_DES40_CBC_SHA",
+	0x0012: "TLS_DHE_DSS_WITH_DES_CBC_SHA",
+	0x0013: "TLS_DHE_DSS_WITH_3DES_EDE_CBC_SHA",
+	0x0014: "TLS_DHE_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x0015: "TLS_DHE_RSA_WITH_DES_CBC_SHA",
+	0x0016: "TLS_DHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x0017: "TLS_DH_anon_EXPORT_WITH_RC4_40_MD5",
+	0x0018: "TLS_DH_anon_WITH_RC4_128_MD5",
+	0x0019: "TLS_DH_anon_EXPORT_WITH_DES40_CBC_SHA",
+	0x001A: "TLS_DH_anon_WITH_DES_CBC_SHA",
+	0x001B: "TLS_DH_anon_WITH_3DES_EDE_CBC_SHA",
+	0x002C: "TLS_PSK_WITH_NULL_SHA",
+	0x002D: "TLS_DHE_PSK_WITH_NULL_SHA",
+	0x002E: "TLS_RSA_PSK_WITH_NULL_SHA",
+	0x002F: "TLS_RSA_WITH_AES_128_CBC_SHA",
+	0x0030: "TLS_DH_DSS_WITH_AES_128_CBC_SHA",
+	0x0031: "TLS_DH_RSA_WITH_AES_128_CBC_SHA",
+	0x0032: "TLS_DHE_DSS_WITH_AES_128_CBC_SHA",
+	0x0033: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA",
+	0x0034: "TLS_DH_anon_WITH_AES_128_CBC_SHA",
+	0x0035: "TLS_RSA_WITH_AES_256_CBC_SHA",
+	0x0036: "TLS_DH_DSS_WITH_AES_256_CBC_SHA",
+	0x0037: "TLS_DH_RSA_WITH_AES_256_CBC_SHA",
+	0x0038: "TLS_DHE_DSS_WITH_AES_256_CBC_SHA",
+	0x0039: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA",
+	0x003A: "TLS_DH_anon_WITH_AES_256_CBC_SHA",
+	0x003B: "TLS_RSA_WITH_NULL_SHA256",
+	0x003C: "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	0x003D: "TLS_RSA_WITH_AES_256_CBC_SHA256",
+	0x003E: "TLS_DH_DSS_WITH_AES_128_CBC_SHA256",
+	0x003F: "TLS_DH_RSA_WITH_AES_128_CBC_SHA256",
+	0x0040: "TLS_DHE_DSS_WITH_AES_128_CBC_SHA256",
+	0x0041: "TLS_RSA_WITH_CAMELLIA_128_CBC_SHA",
+	0x0045: "TLS_DHE_RSA_WITH_CAMELLIA_128_CBC_SHA",
+	0x0067: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA256",
+	0x006A: "TLS_DHE_DSS_WITH_AES_256_CBC_SHA256",
+	0x006B: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA256",
+	0x006C: "TLS_DH_anon_WITH_AES_128_CBC_SHA256",
+	0x006D: "TLS_DH_anon_WITH_AES_256_CBC_SHA256",
+	0x0084: "TLS_RSA_WITH_CAMELLIA_256_CBC_SHA",
+	0x0088: "TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA",
+	0x008A: "TLS_PSK_WITH_RC4_128_SHA",
+	0x008B: "TLS_PSK_WITH_3DES_EDE_CBC_SHA",
+	0x008C: "TLS_PSK_WITH_AES_128_CBC_SHA",
+	0x008D: "TLS_PSK_WITH_AES_256_CBC_SHA",
+	0x009C: "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	0x009D: "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	0x009E: "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256",
+	0x009F: "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384",
+	0x00A2: "TLS_DHE_DSS_WITH_AES_128_GCM_SHA256",
+	0x00A3: "TLS_DHE_DSS_WITH_AES_256_GCM_SHA384",
+	0x00A6: "TLS_DH_anon_WITH_AES_128_GCM_SHA256",
+	0x00A7: "TLS_DH_anon_WITH_AES_256_GCM_SHA384",
+	0x00A8: "TLS_PSK_WITH_AES_128_GCM_SHA256",
+	0x00A9: "TLS_PSK_WITH_AES_256_GCM_SHA384",
+	0x00FF: "TLS_EMPTY_RENEGOTIATION_INFO_SCSV",
+	0x1301: "TLS_AES_128_GCM_SHA256",
+	0x1302: "TLS_AES_256_GCM_SHA384",
+	0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+	0x1304: "TLS_AES_128_CCM_SHA256",
+	0x1305: "TLS_AES_128_CCM_8_SHA256",
+	0x5600: "TLS_FALLBACK_SCSV",
+	0xC001: "TLS_ECDH_ECDSA_WITH_NULL_SHA",
+	0xC002: "TLS_ECDH_ECDSA_WITH_RC4_128_SHA",
+	0xC003: "TLS_ECDH_ECDSA_WITH_3DES_EDE_CBC_SHA",
+	0xC004: "TLS_ECDH_ECDSA_WITH_AES_128_CBC_SHA",
+	0xC005: "TLS_ECDH_ECDSA_WITH_AES_256_CBC_SHA",
+	0xC006: "TLS_ECDHE_ECDSA_WITH_NULL_SHA",
+	0xC007: "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	0xC008: "TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA",
+	0xC009: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	0xC00A: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	0xC00B: "TLS_ECDH_RSA_WITH_NULL_SHA",
+	0xC00C: "TLS_ECDH_RSA_WITH_RC4_128_SHA",
+	0xC00D: "TLS_ECDH_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xC00E: "TLS_ECDH_RSA_WITH_AES_128_CBC_SHA",
+	0xC00F: "TLS_ECDH_RSA_WITH_AES_256_CBC_SHA",
+	0xC010: "TLS_ECDHE_RSA_WITH_NULL_SHA",
+	0xC011: "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	0xC012: "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xC013: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	0xC014: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	0xC015: "TLS_ECDH_anon_WITH_NULL_SHA",
+	0xC016: "TLS_ECDH_anon_WITH_RC4_128_SHA",
+	0xC017: "TLS_ECDH_anon_WITH_3DES_EDE_CBC_SHA",
+	0xC018: "TLS_ECDH_anon_WITH_AES_128_CBC_SHA",
+	0xC019: "TLS_ECDH_anon_WITH_AES_256_CBC_SHA",
+	0xC023: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	0xC024: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384",
+	0xC025: "TLS_ECDH_ECDSA_WITH_AES_128_CBC_SHA256",
+	0xC026: "TLS_ECDH_ECDSA_WITH_AES_256_CBC_SHA384",
+	0xC027: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	0xC028: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384",
+	0xC029: "TLS_ECDH_RSA_WITH_AES_128_CBC_SHA256",
+	0xC02A: "TLS_ECDH_RSA_WITH_AES_256_CBC_SHA384",
+	0xC02B: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	0xC02C: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	0xC02D: "TLS_ECDH_ECDSA_WITH_AES_128_GCM_SHA256",
+	0xC02E: "TLS_ECDH_ECDSA_WITH_AES_256_GCM_SHA384",
+	0xC02F: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	0xC030: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xC031: "TLS_ECDH_RSA_WITH_AES_128_GCM_SHA256",
+	0xC032: "TLS_ECDH_RSA_WITH_AES_256_GCM_SHA384",
+	0xC035: "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA",
+	0xC036: "TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA",
+	0xC09C: "TLS_RSA_WITH_AES_128_CCM",
+	0xC09D: "TLS_RSA_WITH_AES_256_CCM",
+	0xC09E: "TLS_DHE_RSA_WITH_AES_128_CCM",
+	0xC09F: "TLS_DHE_RSA_WITH_AES_256_CCM",
+	0xC0A0: "TLS_RSA_WITH_AES_128_CCM_8",
+	0xC0A1: "TLS_RSA_WITH_AES_256_CCM_8",
+	0xC0AC: "TLS_ECDHE_ECDSA_WITH_AES_128_CCM",
+	0xC0AD: "TLS_ECDHE_ECDSA_WITH_AES_256_CCM",
+	0xC0AE: "TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8",
+	0xC0AF: "TLS_ECDHE_ECDSA_WITH_AES_256_CCM_8",
+	0xC0B4: "TLS_SHA256_SHA256",
+	0xC0B5: "TLS_SHA384_SHA384",
+	0xCCA8: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCA9: "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCAA: "TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCAB: "TLS_PSK_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCAC: "TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCAD: "TLS_DHE_PSK_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCAE: "TLS_RSA_PSK_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+var SUPPORTED_GROUP_TABLE = map[uint16]string{
+	19:    "secp192r1",
+	21:    "secp224r1",
+	22:    "secp256k1",
+	23:    "secp256r1",
+	24:    "secp384r1",
+	25:    "secp521r1",
+	29:    "x25519",
+	30:    "x448",
+	256:   "ffdhe2048",
+	257:   "ffdhe3072",
+	258:   "ffdhe4096",
+	259:   "ffdhe6144",
+	260:   "ffdhe8192",
+	4587:  "SecP256r1MLKEM768",
+	4588:  "X25519MLKEM768",
+	4589:  "SecP384r1MLKEM1024",
+	25497: "X25519Kyber768Draft00",
+}
+
+// formatVersion 返回形如 "TLS 1.2 (0x0303)" 的版本描述
+func formatVersion(version uint16) string {
+	return fmt.Sprintf("%s (0x%04X)", lookupName(VERSION_TABLE, version), version)
+}
+
+// formatCipherSuite 返回形如 "TLS_AES_128_GCM_SHA256 (0x1301)" 的密码套件描述，
+// 不安全的密码套件后面会加上 “⚠ 不加密”、“⚠ 匿名” 等标记
+func formatCipherSuite(suite uint16) string {
+	desc := fmt.Sprintf("%s (0x%04X)", lookupName(CIPHER_SUITE_TABLE, suite), suite)
+	for _, flaw := range cipherSuiteFlaws(suite) {
+		desc += " ⚠ " + flaw
+	}
+	return desc
+}
+
+// cipherSuiteFlaws 返回密码套件在机密性或身份验证上的缺陷：
+// NULL 加密（包括 RFC 9150 中只做完整性保护的 TLS 1.3 套件）不提供机密性，数据以明文传输；
+// 匿名（anon）密钥交换不验证服务器的身份，任何中间人都可以冒充服务器
+func cipherSuiteFlaws(suite uint16) []string {
+	name := CIPHER_SUITE_TABLE[suite]
+	var flaws []string
+	if strings.Contains(name, "_WITH_NULL_") || suite == 0xC0B4 || suite == 0xC0B5 {
+		flaws = append(flaws, "不加密")
+	}
+	if strings.Contains(name, "_anon_") {
+		flaws = append(flaws, "匿名")
+	}
+	return flaws
+}
+
+// weakCipherClass 是一类在历史上被攻破或严重削弱的密码套件。与 cipherSuiteFlaws 中的缺陷不同，
+// 这些套件在设计上确实加密并验证了身份，只是所用的算法已不再安全
+type weakCipherClass struct {
+	name string
+	// 相关的攻击及其原理
+	attack string
+	match  func(name string) bool
+}
+
+var weakCipherClasses = []weakCipherClass{
+	{"出口级", "FREAK（CVE-2015-0204）和 Logjam（CVE-2015-4000）：90 年代美国的出口管制把 RSA 和 DH 限制在 512 位、对称密钥限制在 40 或 56 位，" +
+		"攻击者可以诱使双方降级到出口级套件后实时破解", func(name string) bool {
+		return strings.Contains(name, "_EXPORT")
+	}},
+	{"64 位分组（DES、3DES、IDEA）", "SWEET32（CVE-2016-2183）：64 位分组在同一密钥下加密约 32GB 数据后就很可能出现分组碰撞，从而泄露明文；单 DES 的 56 位密钥更是可以直接穷举", func(name string) bool {
+		return strings.Contains(name, "_DES_") || strings.Contains(name, "_DES40_") || strings.Contains(name, "_3DES_") || strings.Contains(name, "_IDEA_")
+	}},
+	{"RC4", "RC4 的密钥流存在统计偏差，可以从大量密文中恢复 Cookie 等明文（Bar Mitzvah、RC4 NOMORE），RFC 7465 已禁止在 TLS 中使用", func(name string) bool {
+		return strings.Contains(name, "_RC4_")
+	}},
+	{"HMAC-MD5", "MD5 已经可以轻易构造碰撞；HMAC-MD5 本身尚未被实际攻破，但这些套件都来自 SSL 时代，通常同时使用 RC4 或出口级算法", func(name string) bool {
+		return strings.HasSuffix(name, "_MD5")
+	}},
+}
+
+// describeWeakCipherSuites 把 suites 中已知脆弱的套件按类别归并，每类一行，说明数量、相关攻击和具体的套件，
+// 避免客户端提供大量旧套件时逐个刷屏。返回脆弱套件的个数（一个套件可能同时属于多个类别，只计一次）
+func describeWeakCipherSuites(suites []uint16) (lines []string, count int) {
+	members := make([][]string, len(weakCipherClasses))
+	for _, suite := range suites {
+		name := CIPHER_SUITE_TABLE[suite]
+		weak := false
+		for i, class := range weakCipherClasses {
+			if class.match(name) {
+				members[i] = append(members[i], name)
+				weak = true
+			}
+		}
+		if weak {
+			count++
+		}
+	}
+	for i, class := range weakCipherClasses {
+		if len(members[i]) > 0 {
+			lines = append(lines, fmt.Sprintf("%s %d 个——%s：%s", class.name, len(members[i]), class.attack, strings.Join(members[i], "、")))
+		}
+	}
+	return lines, count
+}
+
+var CERTIFICATE_TYPE_TABLE = map[byte]string{
+	1:  "RSA Sign",
+	2:  "DSS Sign",
+	3:  "RSA Fixed DH",
+	4:  "DSS Fixed DH",
+	5:  "RSA Ephemeral DH",
+	6:  "DSS Ephemeral DH",
+	20: "Fortezza DMS",
+	64: "ECDSA Sign",
+	65: "RSA Fixed ECDH",
+	66: "ECDSA Fixed ECDH",
+}
+
+var CERT_COMPRESSION_ALGORITHM_TABLE = map[uint16]string{
+	1: "zlib",
+	2: "brotli",
+	3: "zstd",
+}
+
+// TRUSTED_AUTHORITY_TYPE_TABLE 是 trusted_ca_keys 扩展中可信 CA 的标识方式（RFC 6066 6）
+var TRUSTED_AUTHORITY_TYPE_TABLE = map[byte]string{
+	0: "pre_agreed",
+	1: "key_sha1_hash",
+	2: "x509_name",
+	3: "cert_sha1_hash",
+}
+
+// QUIC_TRANSPORT_PARAMETER_TABLE 是 quic_transport_parameters 扩展中的传输参数（RFC 9000 18.2 及后续扩展）
+var QUIC_TRANSPORT_PARAMETER_TABLE = map[uint64]string{
+	0x00:   "original_destination_connection_id",
+	0x01:   "max_idle_timeout",
+	0x02:   "stateless_reset_token",
+	0x03:   "max_udp_payload_size",
+	0x04:   "initial_max_data",
+	0x05:   "initial_max_stream_data_bidi_local",
+	0x06:   "initial_max_stream_data_bidi_remote",
+	0x07:   "initial_max_stream_data_uni",
+	0x08:   "initial_max_streams_bidi",
+	0x09:   "initial_max_streams_uni",
+	0x0a:   "ack_delay_exponent",
+	0x0b:   "max_ack_delay",
+	0x0c:   "disable_active_migration",
+	0x0d:   "preferred_address",
+	0x0e:   "active_connection_id_limit",
+	0x0f:   "initial_source_connection_id",
+	0x10:   "retry_source_connection_id",
+	0x11:   "version_information",
+	0x20:   "max_datagram_frame_size",
+	0x2ab2: "grease_quic_bit",
+}
+
+var SIGNATURE_SCHEME_TABLE = map[uint16]string{
+	0x0201: "rsa_pkcs1_sha1",
+	0x0202: "dsa_sha1",
+	0x0203: "ecdsa_sha1",
+	0x0301: "rsa_pkcs1_sha224",
+	0x0302: "dsa_sha224",
+	0x0303: "ecdsa_sha224",
+	0x0401: "rsa_pkcs1_sha256",
+	0x0402: "dsa_sha256",
+	0x0403: "ecdsa_secp256r1_sha256",
+	0x0501: "rsa_pkcs1_sha384",
+	0x0502: "dsa_sha384",
+	0x0503: "ecdsa_secp384r1_sha384",
+	0x0601: "rsa_pkcs1_sha512",
+	0x0602: "dsa_sha512",
+	0x0603: "ecdsa_secp521r1_sha512",
+	0x0804: "rsa_pss_rsae_sha256",
+	0x0805: "rsa_pss_rsae_sha384",
+	0x0806: "rsa_pss_rsae_sha512",
+	0x0807: "ed25519",
+	0x0808: "ed448",
+	0x0809: "rsa_pss_pss_sha256",
+	0x080A: "rsa_pss_pss_sha384",
+	0x080B: "rsa_pss_pss_sha512",
+}
+
+// formatSignatureScheme 返回签名算法的名称，未知的算法以十六进制显示
+func formatSignatureScheme(scheme uint16) string {
+	name, hasName := SIGNATURE_SCHEME_TABLE[scheme]
+	if !hasName {
+		return fmt.Sprintf("未知 (0x%04X)", scheme)
+	}
+	return name
+}
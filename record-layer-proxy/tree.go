@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// treeHexLimit 是树中显示原始数据时每个字段最多显示的字节数，-max-record-log-bytes 更小时以它为准
+const treeHexLimit = 32
+
+// treeNode 是 -tree 模式下解析树中的一个节点，仿照 Wireshark 展示协议字段的方式逐层嵌套
+type treeNode struct {
+	label    string
+	children []*treeNode
+}
+
+// add 添加一个子节点并返回它，以便继续向下添加
+func (n *treeNode) add(format string, args ...any) *treeNode {
+	child := &treeNode{label: fmt.Sprintf(format, args...)}
+	n.children = append(n.children, child)
+	return child
+}
+
+// addHex 添加一个以十六进制显示原始数据的子节点，用于无法进一步解析的字段
+func (n *treeNode) addHex(name string, data []byte) *treeNode {
+	limit := treeHexLimit
+	if config.maxRecordLogBytes > 0 && config.maxRecordLogBytes < limit {
+		limit = config.maxRecordLogBytes
+	}
+	shown, more := truncateForLog(data, limit)
+	return n.add("%s（%d 字节）：%s%s", name, len(data), hex.EncodeToString(shown), more)
+}
+
+// String 以缩进的树形输出该节点及其全部子节点
+func (n *treeNode) String() string {
+	var b strings.Builder
+	b.WriteString(n.label + "\n")
+	n.writeChildren(&b, "")
+	return b.String()
+}
+
+func (n *treeNode) writeChildren(b *strings.Builder, prefix string) {
+	for i, child := range n.children {
+		branch, next := "├─ ", "│  "
+		if i == len(n.children)-1 {
+			branch, next = "└─ ", "   "
+		}
+		b.WriteString(prefix + branch + child.label + "\n")
+		child.writeChildren(b, prefix+next)
+	}
+}
+
+// recordTree 构造一条记录的解析树。encrypted 表示该方向此前已发送过 ChangeCipherSpec（TLS 1.2），
+// 此时握手记录的内容是加密的 Finished，开头并不是握手消息头，不能按类型和长度解析
+func recordTree(contentType byte, version uint16, payload []byte, encrypted bool) *treeNode {
+	root := &treeNode{label: fmt.Sprintf(
+		"TLS 记录：%s (%d)，长度 %d",
+		lookupName(CONTENT_TYPE_TABLE, contentType),
+		contentType,
+		len(payload),
+	)}
+	root.add("内容类型：%s (%d)", lookupName(CONTENT_TYPE_TABLE, contentType), contentType)
+	root.add("版本：%s", formatVersion(version))
+	root.add("长度：%d", len(payload))
+
+	switch {
+	case contentType == contentTypeHandshake && encrypted:
+		root.addHex("加密的 Finished（ChangeCipherSpec 之后的握手记录）", payload)
+		if config.inspectAfterCCS {
+			root.add("%s", inspectEncryptedRecord(contentType, payload))
+		}
+
+	case contentType == contentTypeHandshake:
+		// 一条记录里可能有多条握手消息，最后一条也可能延续到下一条记录
+		r := payload
+		for len(r) > 0 {
+			if len(r) < 4 {
+				root.addHex("握手消息片段", r)
+				break
+			}
+			msgLength := int(r[1])<<16 | int(r[2])<<8 | int(r[3])
+			if len(r) < 4+msgLength {
+				node := root.add("握手消息片段：%s (%d)，声明长度 %d，本记录中只有 %d 字节", lookupName(HANDSHAKE_TYPE_TABLE, r[0]), r[0], msgLength, len(r)-4)
+				node.addHex("数据", r[4:])
+				break
+			}
+			handshakeTree(root, r[0], r[4:4+msgLength])
+			r = r[4+msgLength:]
+		}
+
+	case contentType == contentTypeAlert && !encrypted && len(payload) == 2:
+		node := root.add("警报")
+		node.add("级别：%s (%d)", lookupName(ALERT_LEVEL_TABLE, payload[0]), payload[0])
+		node.add("描述：%s (%d)", lookupName(ALERT_DESCRIPTION_TABLE, payload[1]), payload[1])
+
+	case contentType == contentTypeAlert && encrypted:
+		root.addHex("加密的警报（ChangeCipherSpec 之后的警报记录）", payload)
+		if config.inspectAfterCCS {
+			root.add("%s", inspectEncryptedRecord(contentType, payload))
+		}
+
+	case contentType == contentTypeChangeCipherSpec && len(payload) == 1:
+		root.add("ChangeCipherSpec 消息：%d", payload[0])
+
+	default:
+		root.addHex("加密或未解析的数据", payload)
+	}
+	return root
+}
+
+// handshakeTree 在 parent 下添加一条完整握手消息的解析树
+func handshakeTree(parent *treeNode, msgType byte, body []byte) {
+	node := parent.add("握手协议：%s (%d)", lookupName(HANDSHAKE_TYPE_TABLE, msgType), msgType)
+	node.add("握手类型：%s (%d)", lookupName(HANDSHAKE_TYPE_TABLE, msgType), msgType)
+	node.add("长度：%d", len(body))
+
+	switch msgType {
+	case handshakeTypeClientHello:
+		hello, err := parseClientHello(body)
+		if err != nil {
+			node.add("无法解析：%v", err)
+			node.addHex("消息体", body)
+			return
+		}
+		node.add("legacy_version：%s", formatVersion(hello.legacyVersion))
+		node.addHex("random", hello.random)
+		node.addHex("session_id", hello.sessionID)
+		suites := node.add("密码套件（%d 个）", len(hello.cipherSuites))
+		for _, suite := range hello.cipherSuites {
+			if isGREASE(suite) {
+				suites.add("GREASE (0x%04X)", suite)
+			} else {
+				suites.add("%s", formatCipherSuite(suite))
+			}
+		}
+		node.add("压缩方法（%d 个）：%s", len(hello.compressionMethods), hex.EncodeToString(hello.compressionMethods))
+		exts := node.add("扩展（%d 个）", len(hello.extensions))
+		for _, ext := range hello.extensions {
+			extNode := exts.add("扩展：%s，长度 %d", formatCode(EXTENSION_TYPE_TABLE, ext.extType), len(ext.data))
+			clientExtensionTree(extNode, hello, ext)
+		}
+
+	case handshakeTypeServerHello:
+		hello, err := parseServerHello(body)
+		if err != nil {
+			node.add("无法解析：%v", err)
+			node.addHex("消息体", body)
+			return
+		}
+		node.add("legacy_version：%s", formatVersion(hello.legacyVersion))
+		node.addHex("random", hello.random)
+		node.addHex("session_id", hello.sessionID)
+		node.add("密码套件：%s", formatCipherSuite(hello.cipherSuite))
+		node.add("压缩方法：%d", hello.compressionMethod)
+		exts := node.add("扩展（%d 个）", len(hello.extensions))
+		for _, ext := range hello.extensions {
+			extNode := exts.add("扩展：%s，长度 %d", formatCode(EXTENSION_TYPE_TABLE, ext.extType), len(ext.data))
+			serverExtensionTree(extNode, ext)
+		}
+
+	case handshakeTypeCertificate:
+		certs, err := parseCertificateList(body)
+		if err != nil {
+			node.addHex("消息体（无法按 TLS 1.2 的格式解析）", body)
+			return
+		}
+		list := node.add("证书（%d 张）", len(certs))
+		for i, cert := range certs {
+			list.addHex(fmt.Sprintf("证书 #%d", i+1), cert)
+		}
+
+	case handshakeTypeCompressedCertificate:
+		cc, err := parseCompressedCertificate(body)
+		if err != nil {
+			node.addHex("消息体", body)
+			return
+		}
+		node.add("algorithm：%s", formatCode(CERT_COMPRESSION_ALGORITHM_TABLE, cc.algorithm))
+		node.add("uncompressed_length：%d", cc.uncompressedLength)
+		node.addHex("compressed_certificate_message", cc.compressed)
+
+	case handshakeTypeNewSessionTicket:
+		ticket, err := parseNewSessionTicket(body)
+		if err != nil {
+			node.addHex("消息体", body)
+			return
+		}
+		node.add("ticket_lifetime_hint：%d 秒", ticket.lifetimeHint)
+		node.addHex("ticket", ticket.ticket)
+
+	case handshakeTypeCertificateStatus:
+		st, err := parseCertificateStatus(body)
+		if err != nil {
+			node.addHex("消息体", body)
+			return
+		}
+		node.add("status_type：%d", st.statusType)
+		node.addHex("OCSP 响应", st.response)
+
+	case handshakeTypeServerHelloDone:
+		// ServerHelloDone 没有消息体
+
+	default:
+		node.addHex("消息体", body)
+	}
+}
+
+// clientExtensionTree 在 parent 下添加 ClientHello 中一个扩展的内容。
+// server_name 逐层展开，其余常见扩展用 describeExtension 的解码结果，无法解码的显示原始数据
+func clientExtensionTree(parent *treeNode, hello *clientHello, ext tlsExtension) {
+	if ext.extType == extServerName {
+		r := byteReader(ext.data)
+		var list byteReader
+		if r.readUint16LengthPrefixed(&list) {
+			listNode := parent.add("Server Name 列表，长度 %d", len(list))
+			for !list.empty() {
+				var nameType uint8
+				var name byteReader
+				if !list.readUint8(&nameType) || !list.readUint16LengthPrefixed(&name) {
+					listNode.addHex("无法解析的数据", list)
+					break
+				}
+				entry := listNode.add("Server Name 类型：%d", nameType)
+				if nameType == 0 {
+					entry.add("HostName：%s", string(name))
+				} else {
+					entry.addHex("名称", name)
+				}
+			}
+			return
+		}
+	}
+
+	if detail := describeExtension(hello, ext); detail != "" {
+		parent.add("%s", detail)
+	} else if len(ext.data) > 0 {
+		parent.addHex("数据", ext.data)
+	}
+}
+
+// serverExtensionTree 在 parent 下添加 ServerHello 中一个扩展的内容。
+// ServerHello 中的扩展格式与 ClientHello 中的同名扩展不同（例如只包含选定的一个值）
+func serverExtensionTree(parent *treeNode, ext tlsExtension) {
+	r := byteReader(ext.data)
+	switch ext.extType {
+	case extSupportedVersions:
+		var version uint16
+		if r.readUint16(&version) && r.empty() {
+			parent.add("选定的版本：%s", formatVersion(version))
+			return
+		}
+	case extKeyShare:
+		var group uint16
+		var key byteReader
+		if r.readUint16(&group) && r.readUint16LengthPrefixed(&key) && r.empty() {
+			parent.add("组：%s", formatCode(SUPPORTED_GROUP_TABLE, group))
+			parent.addHex("公钥", key)
+			return
+		}
+	case extALPN:
+		if protocols := alpnProtocols([]tlsExtension{ext}); len(protocols) == 1 {
+			parent.add("选定的协议：%q", protocols[0])
+			return
+		}
+	}
+	if len(ext.data) > 0 {
+		parent.addHex("数据", ext.data)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// extTrustedCAKeys 是 trusted_ca_keys 扩展（RFC 6066 6）。内存受限的客户端只预置了少数几个根证书，
+// 用它告诉服务器自己信任哪些 CA，服务器有多条证书链时据此挑选一条。实际很少有客户端发送这个扩展
+const extTrustedCAKeys uint16 = 3
+
+// trustedAuthority 是 trusted_ca_keys 扩展中的一项。identifier 的格式取决于 identifierType：
+// pre_agreed 为空，key_sha1_hash 和 cert_sha1_hash 为 20 字节的 SHA-1，x509_name 为 DER 编码的 DistinguishedName
+type trustedAuthority struct {
+	identifierType byte
+	identifier     []byte
+}
+
+// trustedCAKeys 返回 ClientHello 中 trusted_ca_keys 扩展列出的可信 CA，未携带该扩展或格式错误时 ok 为 false。
+// 列表可以为空，表示客户端支持该扩展但没有列出任何 CA
+func trustedCAKeys(extensions []tlsExtension) (authorities []trustedAuthority, ok bool) {
+	data, found := findExtension(extensions, extTrustedCAKeys)
+	if !found {
+		return nil, false
+	}
+	r := byteReader(data)
+	var list byteReader
+	if !r.readUint16LengthPrefixed(&list) || !r.empty() {
+		return nil, false
+	}
+	for !list.empty() {
+		var ta trustedAuthority
+		if !list.readUint8(&ta.identifierType) {
+			return nil, false
+		}
+		switch ta.identifierType {
+		case 0:
+		case 1, 3:
+			if !list.readBytes(20, &ta.identifier) {
+				return nil, false
+			}
+		case 2:
+			var name byteReader
+			if !list.readUint16LengthPrefixed(&name) || len(name) == 0 {
+				return nil, false
+			}
+			ta.identifier = name
+		default:
+			// 未知的标识方式不知道长度，无法继续解析后面的项
+			return nil, false
+		}
+		authorities = append(authorities, ta)
+	}
+	return authorities, true
+}
+
+// String 返回形如 “key_sha1_hash (1) 5fb7ee06…” 的描述，x509_name 会解码出名称
+func (ta trustedAuthority) String() string {
+	desc := fmt.Sprintf("%s (%d)", lookupName(TRUSTED_AUTHORITY_TYPE_TABLE, ta.identifierType), ta.identifierType)
+	switch ta.identifierType {
+	case 1, 3:
+		return desc + " " + hex.EncodeToString(ta.identifier)
+	case 2:
+		var rdn pkix.RDNSequence
+		if rest, err := asn1.Unmarshal(ta.identifier, &rdn); err == nil && len(rest) == 0 {
+			var name pkix.Name
+			name.FillFromRDNSequence(&rdn)
+			return desc + " " + name.String()
+		}
+		return desc + fmt.Sprintf(" 无法解码的名称（%d 字节）", len(ta.identifier))
+	}
+	return desc
+}
+
+// describeTrustedCAKeys 返回可信 CA 列表的描述：先按标识方式统计数量，再逐项列出
+func describeTrustedCAKeys(authorities []trustedAuthority) string {
+	if len(authorities) == 0 {
+		return "空列表（表示支持该扩展，但没有列出任何 CA）"
+	}
+	counts := map[byte]int{}
+	var order []byte
+	for _, ta := range authorities {
+		if counts[ta.identifierType] == 0 {
+			order = append(order, ta.identifierType)
+		}
+		counts[ta.identifierType]++
+	}
+	var countParts, items []string
+	for _, t := range order {
+		countParts = append(countParts, fmt.Sprintf("%s %d 项", lookupName(TRUSTED_AUTHORITY_TYPE_TABLE, t), counts[t]))
+	}
+	for _, ta := range authorities {
+		items = append(items, ta.String())
+	}
+	return fmt.Sprintf("共 %d 项（%s）：%s", len(authorities), strings.Join(countParts, "、"), strings.Join(items, "；"))
+}
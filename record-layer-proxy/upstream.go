@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// newUpstreamTLSConfig 按 -upstream-* 参数创建与后端之间的 TLS 配置。serverName 和 alpn 为空时，
+// 每条连接再分别取后端地址中的主机名（-mitm 模式下取客户端的 SNI）和客户端的 ALPN
+func newUpstreamTLSConfig(serverName, alpn, minVersion, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	upstream := &tls.Config{ServerName: serverName, InsecureSkipVerify: insecure}
+	if alpn != "" {
+		for _, proto := range strings.Split(alpn, ",") {
+			if proto = strings.TrimSpace(proto); proto != "" {
+				upstream.NextProtos = append(upstream.NextProtos, proto)
+			}
+		}
+	}
+	if minVersion != "" {
+		version, err := parseForceVersion(minVersion)
+		if err != nil {
+			return nil, fmt.Errorf("-upstream-min-version：%w", err)
+		}
+		upstream.MinVersion = version
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("-upstream-cert 和 -upstream-key 必须同时指定")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取客户端证书：%w", err)
+		}
+		upstream.Certificates = []tls.Certificate{cert}
+	}
+	return upstream, nil
+}
+
+// upstreamHandshake 在 state.serverConn 之上与后端完成 TLS 握手。hello 不为 nil 时（-mitm 模式），
+// 没有用 -upstream-sni 和 -upstream-alpn 指定的 SNI 和 ALPN 沿用客户端 ClientHello 中的值。
+// 默认用系统根证书验证后端的证书，验证失败时返回错误，除非指定了 -upstream-insecure 或 -mitm-insecure
+func upstreamHandshake(ctx context.Context, state *connState, remoteAddr string, hello *tls.ClientHelloInfo) (*tls.Conn, error) {
+	upstream := &tls.Config{}
+	if config.upstreamTLS != nil {
+		upstream = config.upstreamTLS.Clone()
+	}
+	if upstream.ServerName == "" && hello != nil {
+		upstream.ServerName = hello.ServerName
+	}
+	if upstream.ServerName == "" {
+		upstream.ServerName = mitmBackendHost(remoteAddr)
+	}
+	if upstream.NextProtos == nil && hello != nil {
+		upstream.NextProtos = hello.SupportedProtos
+	}
+	upstream.InsecureSkipVerify = upstream.InsecureSkipVerify || config.mitmInsecure
+
+	backend := tls.Client(state.serverConn, upstream)
+	ctx, cancel := context.WithTimeout(ctx, backendDialer.Timeout)
+	defer cancel()
+	if err := backend.HandshakeContext(ctx); err != nil {
+		var unknownAuthority x509.UnknownAuthorityError
+		var hostnameErr x509.HostnameError
+		var invalidErr x509.CertificateInvalidError
+		if errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+			logPrintf("[upstream %s] 后端 %s 的证书无法通过验证，关闭连接：%v（实验环境中可以指定 -upstream-insecure 或 -mitm-insecure 跳过验证）\n",
+				state.clientConn.RemoteAddr(), state.serverConn.RemoteAddr(), err)
+		} else {
+			logPrintf("[upstream %s] 与后端 %s 的 TLS 握手失败：%v\n", state.clientConn.RemoteAddr(), state.serverConn.RemoteAddr(), err)
+		}
+		return nil, err
+	}
+	return backend, nil
+}
+
+// upstreamForward 是 -upstream-tls 模式下一条连接的转发：与后端之间先建立一条 TLS 连接，
+// 客户端发来的字节（可以是明文，也可以是客户端自己的 TLS 记录）原样放在这条 TLS 连接中转发，
+// 相当于 stunnel 的客户端模式。隧道中的数据不再解析，连接关闭时仍会输出流量统计
+func upstreamForward(state *connState, remoteAddr string) {
+	defer state.closeBoth()
+
+	backend, err := upstreamHandshake(context.Background(), state, remoteAddr, nil)
+	if err != nil {
+		return
+	}
+	backendState := backend.ConnectionState()
+	state.mu.Lock()
+	state.version, state.cipherSuite = backendState.Version, backendState.CipherSuite
+	state.serverALPN = backendState.NegotiatedProtocol
+	state.resumed = backendState.DidResume
+	state.mu.Unlock()
+	logPrintf("[upstream %s --> %s] 已与后端建立 TLS 隧道：%s、%s、ALPN %s\n",
+		state.clientConn.RemoteAddr(), state.serverConn.RemoteAddr(),
+		formatVersion(backendState.Version), formatCipherSuite(backendState.CipherSuite), describeALPN(backendState.NegotiatedProtocol))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		upstreamCopy(state, backend, state.clientConn, true)
+	}()
+	go func() {
+		defer wg.Done()
+		upstreamCopy(state, state.clientConn, backend, false)
+	}()
+	wg.Wait()
+}
+
+// upstreamCopy 把 from 读到的数据原样转发给 to。from 正常关闭后关闭 to 的写方向
+// （对 TLS 连接而言是发送 close_notify），另一个方向仍可继续传输
+func upstreamCopy(state *connState, to, from net.Conn, fromClient bool) {
+	buf := make([]byte, maxCiphertextLength)
+	for {
+		n, err := from.Read(buf)
+		if n > 0 {
+			statBytes.Add(int64(n))
+			state.addBytes(fromClient, int64(n))
+			if werr := writeFull(to, buf[:n]); werr != nil {
+				logDetailf("[upstream %s] 转发时出错：%s\n", state.directionLabel(fromClient), describeConnError(werr))
+				state.closeBoth()
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logDetailf("[upstream %s] %s\n", state.directionLabel(fromClient), describeConnError(err))
+				state.closeBoth()
+				return
+			}
+			if closer, ok := to.(interface{ CloseWrite() error }); ok {
+				_ = closer.CloseWrite()
+			}
+			return
+		}
+	}
+}